@@ -0,0 +1,373 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/notify"
+	"github.com/KunalDuran/weather-api/provider"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+// maxAlertRules is how many alert rules a user can define.
+const maxAlertRules = 20
+
+// maxCooldownMinutes is the longest cooldown a rule can have, one week.
+const maxCooldownMinutes = 7 * 24 * 60
+
+// confirmationResend is how long before a confirmation is mailed again to an
+// address that was not confirmed, which bounds the mail a user can have sent
+// to someone who does not want it. confirmationExpiry is how long the token
+// of a confirmation can be used.
+const (
+	confirmationResend = 24 * time.Hour
+	confirmationExpiry = 7 * 24 * time.Hour
+)
+
+// alertRuleFromBody reads an alert rule from the JSON request body, writing a
+// 400 response and returning false when it is invalid. Units default to the
+// user's preference, the cooldown to an hour and rules start enabled.
+func (s *server) alertRuleFromBody(w http.ResponseWriter, r *http.Request, prefs models.Preferences) (models.AlertRule, bool) {
+	var body struct {
+		Name string `json:"name"`
+		models.Location
+		TempBelow       *float64 `json:"temp_below"`
+		TempAbove       *float64 `json:"temp_above"`
+		Condition       string   `json:"condition"`
+		Units           string   `json:"units"`
+		Channel         string   `json:"channel"`
+		Target          string   `json:"target"`
+		CooldownMinutes *int     `json:"cooldown_minutes"`
+		Enabled         *bool    `json:"enabled"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid JSON provided.",
+			Data:    nil,
+		})
+		return models.AlertRule{}, false
+	}
+
+	rule := models.AlertRule{
+		Name:            strings.TrimSpace(body.Name),
+		Location:        body.Location,
+		TempBelow:       body.TempBelow,
+		TempAbove:       body.TempAbove,
+		Condition:       strings.TrimSpace(body.Condition),
+		Units:           body.Units,
+		Channel:         strings.ToLower(strings.TrimSpace(body.Channel)),
+		Target:          strings.TrimSpace(body.Target),
+		CooldownMinutes: 60,
+		Enabled:         true,
+	}
+	rule.City = strings.TrimSpace(rule.City)
+	rule.Zip = strings.TrimSpace(rule.Zip)
+	rule.Country = strings.TrimSpace(rule.Country)
+	if rule.Units == "" {
+		rule.Units = prefs.Units
+	}
+	if body.CooldownMinutes != nil {
+		rule.CooldownMinutes = *body.CooldownMinutes
+	}
+	if body.Enabled != nil {
+		rule.Enabled = *body.Enabled
+	}
+
+	message := ""
+	switch {
+	case rule.Name == "":
+		message = "Name is required."
+	case len(rule.Name) > 100:
+		message = "Name is too long."
+	case rule.TempBelow == nil && rule.TempAbove == nil && rule.Condition == "":
+		message = "Set at least one of temp_below, temp_above or condition."
+	case len(rule.Condition) > 50:
+		message = "Condition is too long."
+	case !util.ValidUnits(rule.Units):
+		message = "Invalid units, use standard, metric or imperial."
+	case rule.CooldownMinutes < 1 || rule.CooldownMinutes > maxCooldownMinutes:
+		message = "Cooldown must be between 1 and 10080 minutes."
+	case rule.Channel != notify.ChannelWebhook && rule.Channel != notify.ChannelEmail:
+		message = "Invalid channel, use webhook or email."
+	case s.notifiers[rule.Channel] == nil:
+		message = "Email notifications are not configured."
+	case rule.Channel == notify.ChannelWebhook && !util.ValidateWebhookURL(rule.Target):
+		message = "Invalid webhook URL."
+	case rule.Channel == notify.ChannelEmail && !util.ValidateEmail(rule.Target):
+		message = "Invalid email address."
+	default:
+		if err := provider.ValidateLocation(rule.Location); err != nil {
+			message = err.Error()
+		}
+	}
+	if message != "" {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: message,
+			Data:    nil,
+		})
+		return rule, false
+	}
+
+	return rule, true
+}
+
+// confirmEmailTarget reports whether principal confirmed that alerts can be
+// mailed to address. Unless a confirmation was mailed there within
+// confirmationResend, one is sent, with a token that confirms the address
+// when posted to /api/alerts/confirm.
+func (s *server) confirmEmailTarget(principal *Principal, address string, now time.Time) (bool, error) {
+	address = strings.ToLower(address)
+	existing, err := s.alerts.GetAlertEmailAddress(principal.UserID, address)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	if existing != nil && existing.ConfirmedAt != nil {
+		return true, nil
+	}
+	if existing != nil && now.Before(existing.SentAt.Add(confirmationResend)) {
+		return false, nil
+	}
+
+	confirmer, ok := s.notifiers[notify.ChannelEmail].(notify.Confirmer)
+	if !ok {
+		return false, errors.New("the email notifier cannot send confirmations")
+	}
+	token, err := util.GenerateRefreshToken()
+	if err != nil {
+		return false, err
+	}
+
+	// Mail first, so that a failure leaves nothing recorded and the next
+	// save of the rule tries again.
+	err = confirmer.Confirm(address, notify.Confirmation{
+		Username: principal.Username,
+		Token:    token,
+		URL:      s.publicURL + "/api/alerts/confirm",
+		SentAt:   now,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if existing != nil {
+		return false, s.alerts.ResendAlertEmailAddress(existing.ID, util.HashToken(token), now)
+	}
+	_, err = s.alerts.CreateAlertEmailAddress(models.AlertEmailAddress{
+		UserID:    principal.UserID,
+		Address:   address,
+		TokenHash: util.HashToken(token),
+		SentAt:    now,
+	})
+	return false, err
+}
+
+// alertSavedMessage returns the message answering a saved rule, which tells
+// the user when notifications wait for its email target to be confirmed.
+func (s *server) alertSavedMessage(principal *Principal, rule models.AlertRule, saved string) string {
+	if rule.Channel != notify.ChannelEmail {
+		return saved
+	}
+
+	confirmed, err := s.confirmEmailTarget(principal, rule.Target, time.Now())
+	if err != nil {
+		log.Errorf("Confirming alert email address of user %d: %s", principal.UserID, err)
+	}
+	if confirmed {
+		return saved
+	}
+	return "Alert rule saved, confirm the email address to receive its notifications."
+}
+
+// alertID reads the alertID query parameter, writing a 400 response and
+// returning false when it is not a valid id.
+func alertID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("alertID"))
+	if err != nil || id <= 0 {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid alertID.",
+			Data:    nil,
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// matchAlertRule returns why weather matches rule, or nil when it does not.
+// Thresholds are compared in the units of the rule.
+func matchAlertRule(rule models.AlertRule, weather *models.WeatherResponse) []string {
+	var reasons []string
+
+	temp := util.ConvertTemperature(weather.Main.Temp, rule.Units)
+	unit := util.Labels(rule.Units).Temperature
+	if rule.TempBelow != nil && temp < *rule.TempBelow {
+		reasons = append(reasons, fmt.Sprintf("Temperature %g %s is below %g %s", temp, unit, *rule.TempBelow, unit))
+	}
+	if rule.TempAbove != nil && temp > *rule.TempAbove {
+		reasons = append(reasons, fmt.Sprintf("Temperature %g %s is above %g %s", temp, unit, *rule.TempAbove, unit))
+	}
+	if rule.Condition != "" {
+		for _, condition := range weather.Weathers {
+			if strings.EqualFold(condition.Main, rule.Condition) {
+				reasons = append(reasons, fmt.Sprintf("Conditions are %s", condition.Main))
+				break
+			}
+		}
+	}
+
+	return reasons
+}
+
+// evaluateAlerts checks every enabled alert rule against the current weather
+// at its location, fetching each watched location once through the cache.
+//
+// Like the scheduler, it looks up at most s.pollBudget locations per run,
+// taking turns so that every location is checked within a few runs, and
+// stops for pollQuotaPause when the provider reports that the upstream
+// quota is used up.
+//
+// A rule notifies when it starts matching, then stays active without
+// notifying again until it stops matching. Rules emailing an address that
+// was not confirmed are left alone. A rule that starts matching again
+// within its cooldown of the last notification is not notified, and stays
+// inactive so it notifies on a later evaluation once the cooldown is over. A
+// notification that fails to send is tried again on the next evaluation.
+func (s *server) evaluateAlerts(now time.Time) error {
+	if s.upstreamPause.paused(now) {
+		return nil
+	}
+
+	rules, err := s.alerts.FetchEnabledAlertRules()
+	if err != nil {
+		return err
+	}
+
+	var locs []models.Location
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		key := provider.LocationKey(rule.Location)
+		if !seen[key] {
+			seen[key] = true
+			locs = append(locs, rule.Location)
+		}
+	}
+
+	if len(locs) > s.pollBudget {
+		start := s.alertOffset % len(locs)
+		s.alertOffset = start + s.pollBudget
+		turn := make([]models.Location, s.pollBudget)
+		for i := range turn {
+			turn[i] = locs[(start+i)%len(locs)]
+		}
+		locs = turn
+	}
+
+	index := make(map[string]int)
+	for i, loc := range locs {
+		index[provider.LocationKey(loc)] = i
+	}
+	results := s.fetchCurrentWeatherAll(locs, provider.Options{})
+
+	for _, result := range results {
+		if errors.Is(result.err, provider.ErrRateLimited) {
+			s.upstreamPause.pause(now)
+			break
+		}
+	}
+
+	for _, rule := range rules {
+		i, ok := index[provider.LocationKey(rule.Location)]
+		if !ok {
+			// Not looked up on this turn
+			continue
+		}
+		result := results[i]
+		if result.err != nil {
+			log.Errorf("Evaluating alert rule %d: %s", rule.ID, result.err)
+			continue
+		}
+
+		reasons := matchAlertRule(rule, result.weather)
+		switch {
+		case reasons == nil:
+			if rule.Active {
+				if err := s.alerts.SetAlertRuleActive(rule.ID, false); err != nil {
+					log.Error(err)
+				}
+			}
+			continue
+		case rule.Active:
+			continue
+		case rule.LastNotifiedAt != nil && now.Before(rule.LastNotifiedAt.Add(time.Duration(rule.CooldownMinutes)*time.Minute)):
+			continue
+		}
+
+		if rule.Channel == notify.ChannelEmail {
+			address, err := s.alerts.GetAlertEmailAddress(rule.UserID, strings.ToLower(rule.Target))
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				log.Error(err)
+				continue
+			}
+			if address == nil || address.ConfirmedAt == nil {
+				continue
+			}
+		}
+
+		claimed, err := s.alerts.ClaimAlertNotification(rule.ID, rule.NotificationCount, now)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+
+		notifier := s.notifiers[rule.Channel]
+		if notifier == nil {
+			log.Errorf("Alert rule %d: no notifier for channel %q", rule.ID, rule.Channel)
+			continue
+		}
+
+		weather := *result.weather
+		weather.Raw = nil
+		util.ConvertWeather(&weather, rule.Units)
+
+		err = notifier.Notify(rule.Target, notify.Notification{
+			RuleID:      rule.ID,
+			RuleName:    rule.Name,
+			Reasons:     reasons,
+			Weather:     weather,
+			TriggeredAt: now,
+		})
+		if err != nil {
+			// Release the claim, so the rule is notified on the next
+			// evaluation rather than after its cooldown.
+			log.Errorf("Alert rule %d: %s", rule.ID, err)
+			if _, err := s.alerts.ReleaseAlertNotification(rule.ID, rule.NotificationCount, rule.LastNotifiedAt); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// watchAlerts calls evaluateAlerts every interval until the process exits.
+func (s *server) watchAlerts(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := s.evaluateAlerts(time.Now()); err != nil {
+			log.Error(err)
+		}
+	}
+}
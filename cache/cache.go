@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"strings"
+	"time"
+)
+
+// Cache stores serialized upstream responses for a limited time.
+type Cache interface {
+	// Get returns the cached value for key and whether it was found and not expired.
+	Get(key string) ([]byte, bool, error)
+	// Set stores value under key for the given ttl.
+	Set(key string, value []byte, ttl time.Duration) error
+}
+
+// Key builds a cache key from its parts, normalizing case and whitespace so that
+// "New  Delhi" and "new delhi" share an entry.
+func Key(parts ...string) string {
+	normalized := make([]string, len(parts))
+	for i, part := range parts {
+		normalized[i] = strings.ToLower(strings.Join(strings.Fields(part), " "))
+	}
+	return strings.Join(normalized, "|")
+}
+
+// Nop is a Cache that never stores anything.
+type Nop struct{}
+
+func (Nop) Get(key string) ([]byte, bool, error) {
+	return nil, false, nil
+}
+
+func (Nop) Set(key string, value []byte, ttl time.Duration) error {
+	return nil
+}
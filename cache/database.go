@@ -0,0 +1,33 @@
+package cache
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/KunalDuran/weather-api/data"
+)
+
+// Database is a Cache shared between API instances through the weather_cache table.
+type Database struct {
+	db *data.DB
+}
+
+// NewDatabase returns a Cache stored in the weather_cache table of db.
+func NewDatabase(db *data.DB) *Database {
+	return &Database{db: db}
+}
+
+func (c *Database) Get(key string) ([]byte, bool, error) {
+	payload, err := data.GetCachedWeather(c.db, key, time.Now())
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return payload, true, nil
+}
+
+func (c *Database) Set(key string, value []byte, ttl time.Duration) error {
+	return data.SetCachedWeather(c.db, key, value, time.Now().Add(ttl))
+}
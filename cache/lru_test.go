@@ -0,0 +1,47 @@
+package cache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyNormalizesCity(t *testing.T) {
+	assert.Equal(t, Key("current", "new delhi", "metric", ""), Key("current", "  New   Delhi ", "METRIC", ""))
+	assert.NotEqual(t, Key("current", "pune", "metric", ""), Key("current", "pune", "imperial", ""))
+}
+
+func TestLRUExpiry(t *testing.T) {
+	c := NewLRU(10)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	assert.NoError(t, c.Set("pune", []byte("sunny"), time.Minute))
+
+	value, ok, err := c.Get("pune")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("sunny"), value)
+
+	now = now.Add(2 * time.Minute)
+	_, ok, _ = c.Get("pune")
+	assert.False(t, ok)
+}
+
+func TestLRUEviction(t *testing.T) {
+	c := NewLRU(2)
+
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Minute)
+	c.Get("a")
+	c.Set("c", []byte("3"), time.Minute)
+
+	_, ok, _ := c.Get("b")
+	assert.False(t, ok, "least recently used entry should be evicted")
+
+	_, ok, _ = c.Get("a")
+	assert.True(t, ok)
+	_, ok, _ = c.Get("c")
+	assert.True(t, ok)
+}
@@ -0,0 +1,297 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+const alertRuleColumns = "id, user_id, name, city, lat, lon, city_id, zip, country, temp_below, temp_above, weather_condition, units, channel, target, cooldown_minutes, enabled, active, notification_count, last_notified_at, created_at"
+
+func CreateAlertRule(db *DB, rule models.AlertRule, userID int) (int, error) {
+	stmt := "INSERT INTO alert_rules (user_id, name, city, lat, lon, city_id, zip, country, temp_below, temp_above, weather_condition, units, channel, target, cooldown_minutes, enabled) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	return db.insert(stmt,
+		userID,
+		rule.Name,
+		rule.City,
+		rule.Lat,
+		rule.Lon,
+		rule.CityID,
+		rule.Zip,
+		rule.Country,
+		rule.TempBelow,
+		rule.TempAbove,
+		rule.Condition,
+		rule.Units,
+		rule.Channel,
+		rule.Target,
+		rule.CooldownMinutes,
+		rule.Enabled,
+	)
+}
+
+func FetchAlertRules(db *DB, userID int) ([]models.AlertRule, error) {
+	stmt := "SELECT " + alertRuleColumns + " FROM alert_rules WHERE user_id = ? ORDER BY id"
+
+	return queryAlertRules(db, stmt, userID)
+}
+
+// FetchEnabledAlertRules returns the enabled rules of every user, for evaluation.
+func FetchEnabledAlertRules(db *DB) ([]models.AlertRule, error) {
+	stmt := "SELECT " + alertRuleColumns + " FROM alert_rules WHERE enabled = ? ORDER BY id"
+
+	return queryAlertRules(db, stmt, true)
+}
+
+func GetAlertRuleByID(db *DB, id int, userID int) (*models.AlertRule, error) {
+	stmt := "SELECT " + alertRuleColumns + " FROM alert_rules WHERE id = ? AND user_id = ?"
+
+	return scanAlertRule(db.QueryRow(stmt, id, userID))
+}
+
+// UpdateAlertRule changes the definition of a rule of userID, returning
+// sql.ErrNoRows when it has no rule with that id. The rule starts over as not
+// active, so a changed rule that matches notifies again.
+func UpdateAlertRule(db *DB, rule *models.AlertRule, userID int) error {
+	stmt := "UPDATE alert_rules SET name = ?, city = ?, lat = ?, lon = ?, city_id = ?, zip = ?, country = ?, temp_below = ?, temp_above = ?, weather_condition = ?, units = ?, channel = ?, target = ?, cooldown_minutes = ?, enabled = ?, active = ? WHERE id = ? AND user_id = ?"
+
+	result, err := db.Exec(stmt,
+		rule.Name,
+		rule.City,
+		rule.Lat,
+		rule.Lon,
+		rule.CityID,
+		rule.Zip,
+		rule.Country,
+		rule.TempBelow,
+		rule.TempAbove,
+		rule.Condition,
+		rule.Units,
+		rule.Channel,
+		rule.Target,
+		rule.CooldownMinutes,
+		rule.Enabled,
+		false,
+		rule.ID,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// MySQL reports no affected rows when nothing changed, so tell an
+	// unchanged rule apart from a missing one.
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affectedRows == 0 {
+		_, err := GetAlertRuleByID(db, rule.ID, userID)
+		return err
+	}
+
+	return nil
+}
+
+func DeleteAlertRule(db *DB, id int, userID int) (int, error) {
+	stmt := "DELETE FROM alert_rules WHERE id = ? AND user_id = ?"
+
+	result, err := db.Exec(stmt, id, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affectedRows), nil
+}
+
+// SetAlertRuleActive records whether a rule matched when it was last evaluated.
+func SetAlertRuleActive(db *DB, id int, active bool) error {
+	_, err := db.Exec("UPDATE alert_rules SET active = ? WHERE id = ?", active, id)
+	return err
+}
+
+// ClaimAlertNotification marks a rule as active and notified at now, provided
+// its notification count is still count. It reports whether the claim
+// succeeded, so that of several evaluators only one sends the notification.
+func ClaimAlertNotification(db *DB, id int, count int, now time.Time) (bool, error) {
+	stmt := "UPDATE alert_rules SET active = ?, notification_count = notification_count + 1, last_notified_at = ? WHERE id = ? AND notification_count = ?"
+
+	result, err := db.Exec(stmt, true, now.UTC(), id, count)
+	if err != nil {
+		return false, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affectedRows == 1, nil
+}
+
+// ReleaseAlertNotification undoes the claim made on a rule with count when
+// its notification could not be sent, restoring the rule as not active and
+// last notified at lastNotifiedAt so that it is notified on the next
+// evaluation. It reports whether the claim was still held.
+func ReleaseAlertNotification(db *DB, id int, count int, lastNotifiedAt *time.Time) (bool, error) {
+	stmt := "UPDATE alert_rules SET active = ?, notification_count = ?, last_notified_at = ? WHERE id = ? AND notification_count = ?"
+
+	result, err := db.Exec(stmt, false, count, nullableTime(lastNotifiedAt), id, count+1)
+	if err != nil {
+		return false, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affectedRows == 1, nil
+}
+
+// CreateAlertEmailAddress stores an address a user wants alert emails sent
+// to, along with the hash of the token mailed there to confirm it.
+func CreateAlertEmailAddress(db *DB, address models.AlertEmailAddress) (int, error) {
+	stmt := "INSERT INTO alert_email_addresses (user_id, address, token_hash, sent_at) VALUES (?, ?, ?, ?)"
+
+	return db.insert(stmt, address.UserID, address.Address, address.TokenHash, address.SentAt.UTC())
+}
+
+// GetAlertEmailAddress returns the alert email address of userID, or
+// sql.ErrNoRows when the user never asked for alerts to be mailed there.
+func GetAlertEmailAddress(db *DB, userID int, address string) (*models.AlertEmailAddress, error) {
+	stmt := "SELECT id, user_id, address, token_hash, sent_at, confirmed_at, created_at FROM alert_email_addresses WHERE user_id = ? AND address = ?"
+
+	return scanAlertEmailAddress(db.QueryRow(stmt, userID, address))
+}
+
+// ResendAlertEmailAddress replaces the confirmation token of an address not
+// confirmed yet, when a new one was mailed there at sentAt.
+func ResendAlertEmailAddress(db *DB, id int, tokenHash string, sentAt time.Time) error {
+	stmt := "UPDATE alert_email_addresses SET token_hash = ?, sent_at = ? WHERE id = ? AND confirmed_at IS NULL"
+
+	_, err := db.Exec(stmt, tokenHash, sentAt.UTC(), id)
+	return err
+}
+
+// ConfirmAlertEmailAddress confirms at now the address whose token hashes to
+// tokenHash, provided the token was sent after since. It returns how many
+// addresses were confirmed.
+func ConfirmAlertEmailAddress(db *DB, tokenHash string, since, now time.Time) (int, error) {
+	stmt := "UPDATE alert_email_addresses SET confirmed_at = ? WHERE token_hash = ? AND confirmed_at IS NULL AND sent_at >= ?"
+
+	result, err := db.Exec(stmt, now.UTC(), tokenHash, since.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affectedRows), nil
+}
+
+func scanAlertEmailAddress(row rowScanner) (*models.AlertEmailAddress, error) {
+	address := &models.AlertEmailAddress{}
+
+	var sentAt, createdAt string
+	var confirmedAt sql.NullString
+	err := row.Scan(
+		&address.ID,
+		&address.UserID,
+		&address.Address,
+		&address.TokenHash,
+		&sentAt,
+		&confirmedAt,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	address.SentAt, _ = util.ParseTimestamp(sentAt)
+	if confirmedAt.Valid {
+		t, _ := util.ParseTimestamp(confirmedAt.String)
+		address.ConfirmedAt = &t
+	}
+	address.CreatedAt, _ = util.ParseTimestamp(createdAt)
+
+	return address, nil
+}
+
+func queryAlertRules(db *DB, stmt string, args ...interface{}) ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rule, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+
+	return rules, rows.Err()
+}
+
+func scanAlertRule(row rowScanner) (*models.AlertRule, error) {
+	rule := &models.AlertRule{}
+
+	var lat, lon, tempBelow, tempAbove sql.NullFloat64
+	var lastNotifiedAt sql.NullString
+	var createdAt string
+	err := row.Scan(
+		&rule.ID,
+		&rule.UserID,
+		&rule.Name,
+		&rule.City,
+		&lat,
+		&lon,
+		&rule.CityID,
+		&rule.Zip,
+		&rule.Country,
+		&tempBelow,
+		&tempAbove,
+		&rule.Condition,
+		&rule.Units,
+		&rule.Channel,
+		&rule.Target,
+		&rule.CooldownMinutes,
+		&rule.Enabled,
+		&rule.Active,
+		&rule.NotificationCount,
+		&lastNotifiedAt,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lat.Valid && lon.Valid {
+		rule.Lat, rule.Lon = &lat.Float64, &lon.Float64
+	}
+	if tempBelow.Valid {
+		rule.TempBelow = &tempBelow.Float64
+	}
+	if tempAbove.Valid {
+		rule.TempAbove = &tempAbove.Float64
+	}
+	if lastNotifiedAt.Valid {
+		t, _ := util.ParseTimestamp(lastNotifiedAt.String)
+		rule.LastNotifiedAt = &t
+	}
+	rule.CreatedAt, _ = util.ParseTimestamp(createdAt)
+
+	return rule, nil
+}
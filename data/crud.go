@@ -2,25 +2,60 @@ package data
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/KunalDuran/weather-api/models"
 	"github.com/KunalDuran/weather-api/util"
 )
 
-func InsertWeatherHistory(db *sql.DB, weather models.WeatherResponse, userID string) (int, error) {
-	var insertedID int64
-	stmt := "INSERT INTO weather_history (city_name, user_id, coord_lon, coord_lat, weather_id, weather_main, weather_description, weather_icon, base, temp, feels_like, temp_min, temp_max, pressure, humidity, visibility, wind_speed, wind_deg, clouds_all, dt, sys_type, sys_id, sys_country, sys_sunrise, sys_sunset, timezone) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+// InsertWeatherHistory records a lookup made by userID together with all of
+// its conditions.
+func InsertWeatherHistory(db *DB, weather models.WeatherResponse, userID int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	id, err := insertWeatherHistory(tx, weather, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// InsertWeatherHistoryBatch records several lookups made by userID in one
+// transaction, so that either all of them are recorded or none is. It returns
+// their ids in the order of weathers.
+func InsertWeatherHistoryBatch(db *DB, weathers []models.WeatherResponse, userID int) ([]int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(weathers))
+	for i, weather := range weathers {
+		ids[i], err = insertWeatherHistory(tx, weather, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ids, tx.Commit()
+}
+
+func insertWeatherHistory(q queryer, weather models.WeatherResponse, userID int) (int, error) {
+	stmt := "INSERT INTO weather_history (city_name, user_id, coord_lon, coord_lat, base, temp, feels_like, temp_min, temp_max, pressure, humidity, visibility, wind_speed, wind_deg, clouds_all, dt, sys_type, sys_id, sys_country, sys_sunrise, sys_sunset, timezone, provider, provider_response_ms, raw_payload) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
 
-	result, err := db.Exec(stmt,
+	id, err := q.insert(stmt,
 		weather.Name,
 		userID,
 		weather.Coord.Lon,
 		weather.Coord.Lat,
-		weather.Weathers[0].ID,
-		weather.Weathers[0].Main,
-		weather.Weathers[0].Description,
-		weather.Weathers[0].Icon,
 		weather.Base,
 		weather.Main.Temp,
 		weather.Main.FeelsLike,
@@ -39,24 +74,28 @@ func InsertWeatherHistory(db *sql.DB, weather models.WeatherResponse, userID str
 		weather.Sys.Sunrise,
 		weather.Sys.Sunset,
 		weather.Timezone,
+		weather.Provider,
+		weather.ProviderResponseMS,
+		rawPayload(weather.Raw),
 	)
 	if err != nil {
 		return 0, err
 	}
 
-	insertedID, err = result.LastInsertId()
-	if err != nil {
+	if err := insertWeatherConditions(q, id, weather.Weathers); err != nil {
 		return 0, err
 	}
 
-	return int(insertedID), nil
+	return id, nil
 }
 
-func DeleteWeather(db *sql.DB, id int) (int, error) {
+// DeleteWeather deletes a history record owned by userID. Records owned by
+// other users are left alone and count as not found.
+func DeleteWeather(db *DB, id int, userID int) (int, error) {
 
-	stmt := "DELETE FROM weather_history WHERE id = ?"
+	stmt := "DELETE FROM weather_history WHERE id = ? AND user_id = ?"
 
-	result, err := db.Exec(stmt, id)
+	result, err := db.Exec(stmt, id, userID)
 	if err != nil {
 		return 0, err
 	}
@@ -68,169 +107,90 @@ func DeleteWeather(db *sql.DB, id int) (int, error) {
 	return int(affectedRows), nil
 }
 
-func UpdateWeatherIfExists(db *sql.DB, weather models.WeatherResponse, userID int) error {
-
-	sqlStatement := `UPDATE weather_history SET
-	  coord_lon = ?,
-	  coord_lat = ?,
-	  weather_id = ?,
-	  weather_main = ?,
-	  weather_description = ?,
-	  weather_icon = ?,
-	  base = ?,
-	  temp = ?,
-	  feels_like = ?,
-	  temp_min = ?,
-	  temp_max = ?,
-	  pressure = ?,
-	  humidity = ?,
-	  visibility = ?,
-	  wind_speed = ?,
-	  wind_deg = ?,
-	  clouds_all = ?,
-	  dt = ?,
-	  sys_type = ?,
-	  sys_id = ?,
-	  sys_country = ?,
-	  sys_sunrise = ?,
-	  sys_sunset = ?,
-	  timezone = ?
-	WHERE id = ?`
-
-	_, err := db.Exec(sqlStatement,
-		weather.Coord.Lon,
-		weather.Coord.Lat,
-		weather.Weathers[0].ID,
-		weather.Weathers[0].Main,
-		weather.Weathers[0].Description,
-		weather.Weathers[0].Icon,
-		weather.Base,
-		weather.Main.Temp,
-		weather.Main.FeelsLike,
-		weather.Main.TempMin,
-		weather.Main.TempMax,
-		weather.Main.Pressure,
-		weather.Main.Humidity,
-		weather.Visibility,
-		weather.Wind.Speed,
-		weather.Wind.Deg,
-		weather.Clouds.All,
-		weather.Dt,
-		weather.Sys.Type,
-		weather.Sys.ID,
-		weather.Sys.Country,
-		weather.Sys.Sunrise,
-		weather.Sys.Sunset,
-		weather.Timezone,
-		userID)
-
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func FetchWeatherHistory(db *sql.DB, userID string) ([]models.WeatherResponse, error) {
+func FetchWeatherHistory(db *DB, userID int) ([]models.WeatherResponse, error) {
 
 	var weatherHistory []models.WeatherResponse
 
-	stmt := "SELECT * FROM weather_history where user_id = ?"
+	stmt := "SELECT " + historyColumns + " FROM weather_history WHERE user_id = ? ORDER BY id"
 
 	rows, err := db.Query(stmt, userID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		weather := models.WeatherResponse{}
-		cityWeather := &models.Weather{}
-
-		var createdAt string
-		err := rows.Scan(
-			&weather.WeatherID,
-			&weather.Name,
-			&weather.UserID,
-			&weather.Coord.Lon,
-			&weather.Coord.Lat,
-			&cityWeather.ID,
-			&cityWeather.Main,
-			&cityWeather.Description,
-			&cityWeather.Icon,
-			&weather.Base,
-			&weather.Main.Temp,
-			&weather.Main.FeelsLike,
-			&weather.Main.TempMin,
-			&weather.Main.TempMax,
-			&weather.Main.Pressure,
-			&weather.Main.Humidity,
-			&weather.Visibility,
-			&weather.Wind.Speed,
-			&weather.Wind.Deg,
-			&weather.Clouds.All,
-			&weather.Dt,
-			&weather.Sys.Type,
-			&weather.Sys.ID,
-			&weather.Sys.Country,
-			&weather.Sys.Sunrise,
-			&weather.Sys.Sunset,
-			&weather.Timezone,
-			&createdAt)
+		weather, err := scanWeather(rows, false)
 		if err != nil {
 			return nil, err
 		}
+		weatherHistory = append(weatherHistory, *weather)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		weather.CreatedAt, _ = util.ParseTimestamp(createdAt)
-		weatherHistory = append(weatherHistory, weather)
+	if err := attachConditions(db, weatherHistory); err != nil {
+		return nil, err
 	}
 
 	return weatherHistory, nil
 }
 
-func CreateUser(db *sql.DB, username string, password string, birthDate time.Time) (int, error) {
+func CreateUser(db *DB, username string, password string, birthDate time.Time) (int, error) {
 	stmt := "INSERT INTO users (username, password, date_of_birth) VALUES (?, ?, ?)"
 
-	result, err := db.Exec(stmt, username, password, birthDate)
-	if err != nil {
-		return 0, err
-	}
+	return db.insert(stmt, username, password, birthDate.Format("2006-01-02"))
+}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
+// userColumns are the users columns read by scanUser, in order.
+const userColumns = "id, username, password, date_of_birth, created_at, units, lang"
+
+func GetUserByUsername(db *DB, username string) (*models.User, error) {
+
+	stmt := "SELECT " + userColumns + " FROM users WHERE username = ?"
 
-	return int(id), nil
+	return scanUser(db.QueryRow(stmt, username))
 }
 
-func GetUserByUsername(db *sql.DB, username string) (*models.User, error) {
+func GetUserByID(db *DB, id int) (*models.User, error) {
 
-	stmt := "SELECT * FROM users WHERE username = ?"
+	stmt := "SELECT " + userColumns + " FROM users WHERE id = ?"
 
-	rows := db.QueryRow(stmt, username)
+	return scanUser(db.QueryRow(stmt, id))
+}
 
+func scanUser(row *sql.Row) (*models.User, error) {
 	user := &models.User{}
 
 	var birthDate, createdAt string
-	err := rows.Scan(
+	err := row.Scan(
 		&user.ID,
 		&user.Username,
 		&user.Password,
 		&birthDate,
 		&createdAt,
+		&user.Units,
+		&user.Lang,
 	)
-
-	user.DateOfBirth, _ = util.ParseDOB(birthDate)
-	user.CreatedAt, _ = util.ParseTimestamp(createdAt)
-
 	if err != nil {
 		return nil, err
 	}
 
+	user.DateOfBirth, _ = util.ParseDOB(birthDate)
+	user.CreatedAt, _ = util.ParseTimestamp(createdAt)
+
 	return user, nil
 }
 
-func BulkDeleteWeathers(db *sql.DB, userID string) (int, error) {
+// UpdateUserPreferences stores the preferences of the user.
+func UpdateUserPreferences(db *DB, userID int, prefs models.Preferences) error {
+	stmt := "UPDATE users SET units = ?, lang = ? WHERE id = ?"
+	_, err := db.Exec(stmt, prefs.Units, prefs.Lang, userID)
+	return err
+}
+
+func BulkDeleteWeathers(db *DB, userID int) (int, error) {
 	stmt := "DELETE FROM weather_history WHERE user_id = ?"
 	result, err := db.Exec(stmt, userID)
 	if err != nil {
@@ -245,69 +205,40 @@ func BulkDeleteWeathers(db *sql.DB, userID string) (int, error) {
 	return int(affectedRows), nil
 }
 
-func GetWeatherByID(db *sql.DB, id int) (*models.WeatherResponse, error) {
-
-	stmt := "SELECT * FROM weather_history WHERE id = ?"
-
-	rows := db.QueryRow(stmt, id)
-
-	weather := &models.WeatherResponse{}
-	cityWeather := &models.Weather{}
-
-	var createdAt string
-	err := rows.Scan(
-		&weather.WeatherID,
-		&weather.Name,
-		&weather.UserID,
-		&weather.Coord.Lon,
-		&weather.Coord.Lat,
-		&cityWeather.ID,
-		&cityWeather.Main,
-		&cityWeather.Description,
-		&cityWeather.Icon,
-		&weather.Base,
-		&weather.Main.Temp,
-		&weather.Main.FeelsLike,
-		&weather.Main.TempMin,
-		&weather.Main.TempMax,
-		&weather.Main.Pressure,
-		&weather.Main.Humidity,
-		&weather.Visibility,
-		&weather.Wind.Speed,
-		&weather.Wind.Deg,
-		&weather.Clouds.All,
-		&weather.Dt,
-		&weather.Sys.Type,
-		&weather.Sys.ID,
-		&weather.Sys.Country,
-		&weather.Sys.Sunrise,
-		&weather.Sys.Sunset,
-		&weather.Timezone,
-		&createdAt,
-	)
+// GetWeatherByID returns a history record owned by userID, or sql.ErrNoRows.
+func GetWeatherByID(db *DB, id int, userID int) (*models.WeatherResponse, error) {
 
-	weather.CreatedAt, _ = util.ParseTimestamp(createdAt)
-	weather.Weathers = append(weather.Weathers, *cityWeather)
+	stmt := "SELECT " + historyColumns + ", raw_payload FROM weather_history WHERE id = ? AND user_id = ?"
 
+	weather, err := scanWeather(db.QueryRow(stmt, id, userID), true)
 	if err != nil {
 		return nil, err
 	}
 
-	return weather, nil
+	history := []models.WeatherResponse{*weather}
+	if err := attachConditions(db, history); err != nil {
+		return nil, err
+	}
+
+	return &history[0], nil
 }
 
-func UpdateWeather(db *sql.DB, weather *models.WeatherResponse) error {
+// UpdateWeather overwrites a history record owned by userID, including its
+// conditions, returning sql.ErrNoRows when there is no such record.
+func UpdateWeather(db *DB, weather *models.WeatherResponse, userID int) error {
 
-	stmt := "UPDATE weather_history SET city_name = ?, coord_lon = ?, coord_lat = ?, weather_id = ?, weather_main = ?, weather_description = ?, weather_icon = ?, base = ?, temp = ?, feels_like = ?, temp_min = ?, temp_max = ?, pressure = ?, humidity = ?, visibility = ?, wind_speed = ?, wind_deg = ?, clouds_all = ?, dt = ?, sys_type = ?, sys_id = ?, sys_country = ?, sys_sunrise = ?, sys_sunset = ?, timezone = ? WHERE id = ?"
+	stmt := "UPDATE weather_history SET city_name = ?, coord_lon = ?, coord_lat = ?, base = ?, temp = ?, feels_like = ?, temp_min = ?, temp_max = ?, pressure = ?, humidity = ?, visibility = ?, wind_speed = ?, wind_deg = ?, clouds_all = ?, dt = ?, sys_type = ?, sys_id = ?, sys_country = ?, sys_sunrise = ?, sys_sunset = ?, timezone = ?, provider = ?, provider_response_ms = ?, raw_payload = ? WHERE id = ? AND user_id = ?"
 
-	_, err := db.Exec(stmt,
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(stmt,
 		weather.Name,
 		weather.Coord.Lon,
 		weather.Coord.Lat,
-		weather.Weathers[0].ID,
-		weather.Weathers[0].Main,
-		weather.Weathers[0].Description,
-		weather.Weathers[0].Icon,
 		weather.Base,
 		weather.Main.Temp,
 		weather.Main.FeelsLike,
@@ -326,11 +257,270 @@ func UpdateWeather(db *sql.DB, weather *models.WeatherResponse) error {
 		weather.Sys.Sunrise,
 		weather.Sys.Sunset,
 		weather.Timezone,
+		weather.Provider,
+		weather.ProviderResponseMS,
+		rawPayload(weather.Raw),
 		weather.WeatherID,
+		userID,
 	)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	// MySQL reports no affected rows when nothing changed, so tell an
+	// unchanged record apart from a missing one.
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affectedRows == 0 {
+		var exists int
+		err := tx.QueryRow("SELECT 1 FROM weather_history WHERE id = ? AND user_id = ?", weather.WeatherID, userID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM weather_history_conditions WHERE weather_history_id = ?", weather.WeatherID); err != nil {
+		return err
+	}
+	if err := insertWeatherConditions(tx, weather.WeatherID, weather.Weathers); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateWeatherIfExists overwrites a history record owned by userID like
+// UpdateWeather, but leaves it alone without an error when there is no such
+// record.
+func UpdateWeatherIfExists(db *DB, weather models.WeatherResponse, userID int) error {
+	err := UpdateWeather(db, &weather, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+func GetCachedWeather(db *DB, key string, now time.Time) ([]byte, error) {
+	stmt := "SELECT payload FROM weather_cache WHERE cache_key = ? AND expires_at > ?"
+
+	var payload []byte
+	err := db.QueryRow(stmt, key, now.UTC()).Scan(&payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+func SetCachedWeather(db *DB, key string, payload []byte, expiresAt time.Time) error {
+	stmt := "INSERT INTO weather_cache (cache_key, payload, expires_at) VALUES (?, ?, ?)" + db.upsert("cache_key", "payload", "expires_at")
+
+	_, err := db.Exec(stmt, key, payload, expiresAt.UTC())
+	return err
+}
+
+func InsertForecastHistory(db *DB, forecast models.ForecastResponse, userID int) (int, error) {
+	payload, err := json.Marshal(forecast)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := "INSERT INTO forecast_history (user_id, city_name, sys_country, payload) VALUES (?, ?, ?, ?)"
+
+	return db.insert(stmt, userID, forecast.City.Name, forecast.City.Country, payload)
+}
+
+// sessionColumns are the columns of sessions in the order scanSession reads
+// them.
+const sessionColumns = "id, user_id, token_hash, device, ip, created_at, last_used_at, expires_at, revoked_at, access_token_id, access_expires_at"
+
+func CreateSession(db *DB, session models.Session) (int, error) {
+	stmt := "INSERT INTO sessions (user_id, token_hash, device, ip, last_used_at, expires_at, access_token_id, access_expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+
+	return db.insert(stmt,
+		session.UserID,
+		session.TokenHash,
+		session.Device,
+		session.IP,
+		session.LastUsedAt.UTC(),
+		session.ExpiresAt.UTC(),
+		session.AccessTokenID,
+		session.AccessExpiresAt.UTC(),
+	)
+}
+
+func GetSessionByTokenHash(db *DB, tokenHash string) (*models.Session, error) {
+	stmt := "SELECT " + sessionColumns + " FROM sessions WHERE token_hash = ?"
+
+	return scanSession(db.QueryRow(stmt, tokenHash))
+}
+
+func GetSessionByID(db *DB, id int, userID int) (*models.Session, error) {
+	stmt := "SELECT " + sessionColumns + " FROM sessions WHERE id = ? AND user_id = ?"
+
+	return scanSession(db.QueryRow(stmt, id, userID))
+}
+
+// RotateSession replaces the refresh token of a live session, so that the
+// previous token can no longer be exchanged, and records the access token
+// issued with the new one.
+func RotateSession(db *DB, id int, oldTokenHash, newTokenHash string, lastUsedAt, expiresAt time.Time, accessTokenID string, accessExpiresAt time.Time) (int, error) {
+	stmt := "UPDATE sessions SET token_hash = ?, last_used_at = ?, expires_at = ?, access_token_id = ?, access_expires_at = ? WHERE id = ? AND token_hash = ? AND revoked_at IS NULL"
+
+	result, err := db.Exec(stmt, newTokenHash, lastUsedAt.UTC(), expiresAt.UTC(), accessTokenID, accessExpiresAt.UTC(), id, oldTokenHash)
+	if err != nil {
+		return 0, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affectedRows), nil
+}
+
+func FetchActiveSessions(db *DB, userID int, now time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+
+	stmt := "SELECT " + sessionColumns + " FROM sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ? ORDER BY last_used_at DESC"
+
+	rows, err := db.Query(stmt, userID, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+
+	return sessions, rows.Err()
+}
+
+func RevokeSession(db *DB, id int, userID int, now time.Time) (int, error) {
+	stmt := "UPDATE sessions SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL"
+
+	result, err := db.Exec(stmt, now.UTC(), id, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affectedRows), nil
+}
+
+func RevokeSessionByTokenHash(db *DB, tokenHash string, userID int, now time.Time) (int, error) {
+	stmt := "UPDATE sessions SET revoked_at = ? WHERE token_hash = ? AND user_id = ? AND revoked_at IS NULL"
+
+	result, err := db.Exec(stmt, now.UTC(), tokenHash, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affectedRows), nil
+}
+
+func RevokeToken(db *DB, jti string, userID int, expiresAt time.Time) error {
+	stmt := "INSERT INTO revoked_tokens (jti, user_id, expires_at) VALUES (?, ?, ?)" + db.upsert("jti", "expires_at")
+
+	_, err := db.Exec(stmt, jti, userID, expiresAt.UTC())
+	return err
+}
+
+// FetchRevokedTokens returns the ids of revoked tokens that have not yet expired,
+// mapped to their expiry.
+func FetchRevokedTokens(db *DB, now time.Time) (map[string]time.Time, error) {
+	revoked := make(map[string]time.Time)
+
+	stmt := "SELECT jti, expires_at FROM revoked_tokens WHERE expires_at > ?"
+
+	rows, err := db.Query(stmt, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var jti, expiresAt string
+		if err := rows.Scan(&jti, &expiresAt); err != nil {
+			return nil, err
+		}
+		revoked[jti], _ = util.ParseTimestamp(expiresAt)
+	}
+
+	return revoked, rows.Err()
+}
+
+func PurgeExpiredRevokedTokens(db *DB, now time.Time) (int, error) {
+	stmt := "DELETE FROM revoked_tokens WHERE expires_at <= ?"
+
+	result, err := db.Exec(stmt, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(affectedRows), nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	session := &models.Session{}
+
+	var createdAt, lastUsedAt, expiresAt string
+	var revokedAt, accessTokenID, accessExpiresAt sql.NullString
+	err := row.Scan(
+		&session.ID,
+		&session.UserID,
+		&session.TokenHash,
+		&session.Device,
+		&session.IP,
+		&createdAt,
+		&lastUsedAt,
+		&expiresAt,
+		&revokedAt,
+		&accessTokenID,
+		&accessExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	session.CreatedAt, _ = util.ParseTimestamp(createdAt)
+	session.LastUsedAt, _ = util.ParseTimestamp(lastUsedAt)
+	session.ExpiresAt, _ = util.ParseTimestamp(expiresAt)
+	if revokedAt.Valid {
+		t, _ := util.ParseTimestamp(revokedAt.String)
+		session.RevokedAt = &t
+	}
+	// Sessions started before access tokens were recorded have none
+	if accessTokenID.Valid && accessExpiresAt.Valid {
+		session.AccessTokenID = accessTokenID.String
+		session.AccessExpiresAt, _ = util.ParseTimestamp(accessExpiresAt.String)
+	}
+
+	return session, nil
 }
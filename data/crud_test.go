@@ -0,0 +1,892 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDB connects to the MySQL server described by the TEST_DB_* environment
+// variables, skipping the test when none is configured.
+func testDB(t *testing.T) *DB {
+	host := os.Getenv("TEST_DB_HOST")
+	if host == "" {
+		t.Skip("TEST_DB_HOST not set, skipping database test")
+	}
+
+	name := os.Getenv("TEST_DB_NAME")
+	if name == "" {
+		name = "weather_test"
+	}
+
+	db, err := InitDB(host, os.Getenv("TEST_DB_PORT"), os.Getenv("TEST_DB_USER"), os.Getenv("TEST_DB_PASS"), name)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = MigrateUp(db)
+	require.NoError(t, err)
+
+	return db
+}
+
+// testSQLiteDB creates a SQLite database in a temporary file.
+func testSQLiteDB(t *testing.T) *DB {
+	db, err := InitSQLite(filepath.Join(t.TempDir(), "weather.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = MigrateUp(db)
+	require.NoError(t, err)
+
+	return db
+}
+
+// testPostgresDB connects to the Postgres server described by the TEST_PG_*
+// environment variables, skipping the test when none is configured.
+func testPostgresDB(t *testing.T) *DB {
+	host := os.Getenv("TEST_PG_HOST")
+	if host == "" {
+		t.Skip("TEST_PG_HOST not set, skipping database test")
+	}
+
+	port := os.Getenv("TEST_PG_PORT")
+	if port == "" {
+		port = "5432"
+	}
+	name := os.Getenv("TEST_PG_NAME")
+	if name == "" {
+		name = "weather_test"
+	}
+
+	db, err := InitPostgres(host, port, os.Getenv("TEST_PG_USER"), os.Getenv("TEST_PG_PASS"), name, "disable")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	_, err = MigrateUp(db)
+	require.NoError(t, err)
+
+	return db
+}
+
+// forEachStore runs test as a subtest against every Store implementation, so
+// all backends are held to the same contract.
+func forEachStore(t *testing.T, test func(t *testing.T, store Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		test(t, NewSQLStore(testSQLiteDB(t)))
+	})
+	t.Run("mysql", func(t *testing.T) {
+		test(t, NewSQLStore(testDB(t)))
+	})
+	t.Run("postgres", func(t *testing.T) {
+		test(t, NewSQLStore(testPostgresDB(t)))
+	})
+}
+
+// createTestUser creates a user with a unique username and returns its id.
+func createTestUser(t *testing.T, store Store, name string) int {
+	username := name + "-" + time.Now().Format("150405.000000000") + "@example.com"
+	id, err := store.CreateUser(username, "hash", testBirthDate)
+	require.NoError(t, err)
+	if s, ok := store.(*SQLStore); ok {
+		t.Cleanup(func() { s.DB().Exec("DELETE FROM users WHERE id = ?", id) })
+	}
+	return id
+}
+
+var testBirthDate = time.Date(1990, 5, 15, 0, 0, 0, 0, time.UTC)
+
+func testWeather(city string) models.WeatherResponse {
+	weather := models.WeatherResponse{Name: city}
+	weather.Weathers = []models.Weather{{ID: 800, Main: "Clear", Description: "clear sky", Icon: "01d"}}
+	weather.Main.Temp = 300
+	weather.Sys.Country = "IN"
+	return weather
+}
+
+func TestUsers(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		id := createTestUser(t, store, "user")
+
+		user, err := store.GetUserByID(id)
+		require.NoError(t, err)
+		assert.Equal(t, testBirthDate, user.DateOfBirth)
+		assert.False(t, user.CreatedAt.IsZero())
+
+		byName, err := store.GetUserByUsername(user.Username)
+		require.NoError(t, err)
+		assert.Equal(t, id, byName.ID)
+		assert.Equal(t, "hash", byName.Password)
+
+		_, err = store.CreateUser(user.Username, "other", time.Now())
+		assert.Error(t, err)
+
+		_, err = store.GetUserByUsername("nobody@example.com")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func TestUserPreferences(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		id := createTestUser(t, store, "prefs")
+
+		user, err := store.GetUserByID(id)
+		require.NoError(t, err)
+		assert.Equal(t, "standard", user.Units)
+		assert.Empty(t, user.Lang)
+
+		require.NoError(t, store.UpdateUserPreferences(id, models.Preferences{Units: "imperial", Lang: "hi"}))
+
+		user, err = store.GetUserByUsername(user.Username)
+		require.NoError(t, err)
+		assert.Equal(t, "imperial", user.Units)
+		assert.Equal(t, "hi", user.Lang)
+	})
+}
+
+func TestHistoryIsScopedToOwner(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		owner := createTestUser(t, store, "owner")
+		intruder := createTestUser(t, store, "intruder")
+
+		weatherID, err := store.InsertWeatherHistory(testWeather("Pune"), owner)
+		require.NoError(t, err)
+
+		// Another user can neither see, change nor delete the record
+		_, err = store.GetWeatherByID(weatherID, intruder)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		history, err := store.FetchWeatherHistory(intruder)
+		assert.NoError(t, err)
+		assert.Empty(t, history)
+
+		changed := testWeather("Mumbai")
+		changed.WeatherID = weatherID
+		err = store.UpdateWeather(&changed, intruder)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		affectedRows, err := store.DeleteWeather(weatherID, intruder)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, affectedRows)
+
+		affectedRows, err = store.BulkDeleteWeathers(intruder)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, affectedRows)
+
+		// The owner still sees the untouched record and can delete it
+		weather, err := store.GetWeatherByID(weatherID, owner)
+		require.NoError(t, err)
+		assert.Equal(t, "Pune", weather.Name)
+
+		err = store.UpdateWeather(&changed, owner)
+		assert.NoError(t, err)
+
+		weather, err = store.GetWeatherByID(weatherID, owner)
+		require.NoError(t, err)
+		assert.Equal(t, "Mumbai", weather.Name)
+
+		affectedRows, err = store.DeleteWeather(weatherID, owner)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, affectedRows)
+
+		_, err = store.GetWeatherByID(weatherID, owner)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func TestHistoryKeepsAllConditions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		userID := createTestUser(t, store, "conditions")
+
+		weather := testWeather("Pune")
+		weather.Weathers = []models.Weather{
+			{ID: 501, Main: "Rain", Description: "moderate rain", Icon: "10d"},
+			{ID: 701, Main: "Mist", Description: "mist", Icon: "50d"},
+			{ID: 211, Main: "Thunderstorm", Description: "thunderstorm", Icon: "11d"},
+		}
+		weatherID, err := store.InsertWeatherHistory(weather, userID)
+		require.NoError(t, err)
+
+		// Lookups without conditions are stored rather than rejected
+		clearID, err := store.InsertWeatherHistory(models.WeatherResponse{Name: "Delhi"}, userID)
+		require.NoError(t, err)
+
+		saved, err := store.GetWeatherByID(weatherID, userID)
+		require.NoError(t, err)
+		assert.Equal(t, weather.Weathers, saved.Weathers)
+
+		empty, err := store.GetWeatherByID(clearID, userID)
+		require.NoError(t, err)
+		assert.Empty(t, empty.Weathers)
+
+		// Filtering by condition matches any of them, not just the first
+		page, err := store.FetchWeatherHistoryPage(userID, models.HistoryQuery{Sort: "created_at", Order: "desc", Limit: 10, Condition: "thunderstorm"})
+		require.NoError(t, err)
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, weather.Weathers, page.Items[0].Weathers)
+
+		history, err := store.FetchWeatherHistory(userID)
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		assert.Len(t, history[0].Weathers, 3)
+
+		saved.Weathers = saved.Weathers[2:]
+		require.NoError(t, store.UpdateWeather(saved, userID))
+
+		updated, err := store.GetWeatherByID(weatherID, userID)
+		require.NoError(t, err)
+		assert.Equal(t, []models.Weather{weather.Weathers[2]}, updated.Weathers)
+	})
+}
+
+func TestHistoryKeepsRawPayload(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		userID := createTestUser(t, store, "raw")
+
+		weather := testWeather("Pune")
+		weather.Provider = "openweathermap"
+		weather.ProviderResponseMS = 120
+		weather.Raw = json.RawMessage(`{"name":"Pune","rain":{"1h":0.25}}`)
+		weatherID, err := store.InsertWeatherHistory(weather, userID)
+		require.NoError(t, err)
+
+		_, err = store.InsertWeatherHistory(testWeather("Delhi"), userID)
+		require.NoError(t, err)
+
+		saved, err := store.GetWeatherByID(weatherID, userID)
+		require.NoError(t, err)
+		assert.Equal(t, "openweathermap", saved.Provider)
+		assert.Equal(t, 120, saved.ProviderResponseMS)
+		assert.JSONEq(t, string(weather.Raw), string(saved.Raw))
+
+		query := models.HistoryQuery{Sort: "city", Order: "desc", Limit: 10}
+		page, err := store.FetchWeatherHistoryPage(userID, query)
+		require.NoError(t, err)
+		require.Len(t, page.Items, 2)
+		assert.Equal(t, "openweathermap", page.Items[0].Provider)
+		assert.Nil(t, page.Items[0].Raw)
+
+		query.IncludeRaw = true
+		page, err = store.FetchWeatherHistoryPage(userID, query)
+		require.NoError(t, err)
+		assert.JSONEq(t, string(weather.Raw), string(page.Items[0].Raw))
+		assert.Nil(t, page.Items[1].Raw)
+	})
+}
+
+func TestHistoryBatch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		owner := createTestUser(t, store, "batch")
+
+		ids, err := store.InsertWeatherHistoryBatch([]models.WeatherResponse{testWeather("Pune"), testWeather("Delhi")}, owner)
+		require.NoError(t, err)
+		require.Len(t, ids, 2)
+		assert.NotEqual(t, ids[0], ids[1])
+
+		for i, city := range []string{"Pune", "Delhi"} {
+			weather, err := store.GetWeatherByID(ids[i], owner)
+			require.NoError(t, err)
+			assert.Equal(t, city, weather.Name)
+			assert.Len(t, weather.Weathers, len(testWeather(city).Weathers))
+		}
+	})
+}
+
+func TestHistoryPage(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		userID := createTestUser(t, store, "pager")
+
+		for i, city := range []string{"Pune", "Delhi", "Mumbai", "Chennai"} {
+			weather := testWeather(city)
+			weather.Main.Temp = float64(290 + i)
+			if city == "Mumbai" {
+				weather.Weathers[0].Main = "Rain"
+			}
+			_, err := store.InsertWeatherHistory(weather, userID)
+			require.NoError(t, err)
+		}
+
+		query := models.HistoryQuery{Sort: "city", Order: "asc", Limit: 3}
+		page, err := store.FetchWeatherHistoryPage(userID, query)
+		require.NoError(t, err)
+		assert.Equal(t, 4, page.TotalCount)
+		require.Len(t, page.Items, 3)
+		assert.Equal(t, "Chennai", page.Items[0].Name)
+		require.NotEmpty(t, page.NextCursor)
+
+		query.Cursor = page.NextCursor
+		page, err = store.FetchWeatherHistoryPage(userID, query)
+		require.NoError(t, err)
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, "Pune", page.Items[0].Name)
+		assert.Empty(t, page.NextCursor)
+
+		minTemp := 291.0
+		page, err = store.FetchWeatherHistoryPage(userID, models.HistoryQuery{Sort: "temp", Order: "desc", Limit: 10, MinTemp: &minTemp, Country: "in"})
+		require.NoError(t, err)
+		require.Len(t, page.Items, 3)
+		assert.Equal(t, "Chennai", page.Items[0].Name)
+
+		page, err = store.FetchWeatherHistoryPage(userID, models.HistoryQuery{Sort: "created_at", Order: "desc", Limit: 10, Condition: "Rain"})
+		require.NoError(t, err)
+		require.Len(t, page.Items, 1)
+		assert.Equal(t, "Mumbai", page.Items[0].Name)
+
+		tomorrow := time.Now().Add(24 * time.Hour)
+		page, err = store.FetchWeatherHistoryPage(userID, models.HistoryQuery{Sort: "created_at", Order: "desc", Limit: 10, From: &tomorrow})
+		require.NoError(t, err)
+		assert.Equal(t, 0, page.TotalCount)
+
+		_, err = store.FetchWeatherHistoryPage(userID, models.HistoryQuery{Sort: "temp", Order: "desc", Limit: 10, Cursor: "garbage"})
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+}
+
+func TestFavorites(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		owner := createTestUser(t, store, "favorites")
+		intruder := createTestUser(t, store, "favorites-intruder")
+
+		lat, lon := 18.52, 73.85
+		homeID, err := store.CreateFavorite(models.FavoriteLocation{Label: "Home", Location: models.Location{City: "Pune"}}, owner)
+		require.NoError(t, err)
+		officeID, err := store.CreateFavorite(models.FavoriteLocation{Label: "Office", Location: models.Location{Lat: &lat, Lon: &lon}}, owner)
+		require.NoError(t, err)
+		_, err = store.CreateFavorite(models.FavoriteLocation{Label: "Elsewhere", Location: models.Location{Zip: "10001", Country: "US"}}, intruder)
+		require.NoError(t, err)
+
+		favorites, err := store.FetchFavorites(owner)
+		require.NoError(t, err)
+		require.Len(t, favorites, 2)
+		assert.Equal(t, "Home", favorites[0].Label)
+		assert.Equal(t, "Pune", favorites[0].City)
+		assert.Nil(t, favorites[0].Lat)
+		assert.Equal(t, "Office", favorites[1].Label)
+		assert.Equal(t, 18.52, *favorites[1].Lat)
+		assert.Equal(t, 73.85, *favorites[1].Lon)
+		assert.Less(t, favorites[0].Position, favorites[1].Position)
+		assert.False(t, favorites[0].CreatedAt.IsZero())
+
+		require.NoError(t, store.ReorderFavorites(owner, []int{officeID, homeID}))
+		favorites, err = store.FetchFavorites(owner)
+		require.NoError(t, err)
+		assert.Equal(t, officeID, favorites[0].ID)
+		assert.Equal(t, homeID, favorites[1].ID)
+
+		changed := models.FavoriteLocation{ID: homeID, Label: "Parents", Location: models.Location{CityID: 1259229}}
+		require.NoError(t, store.UpdateFavorite(&changed, owner))
+		favorite, err := store.GetFavoriteByID(homeID, owner)
+		require.NoError(t, err)
+		assert.Equal(t, "Parents", favorite.Label)
+		assert.Equal(t, 1259229, favorite.CityID)
+		assert.Empty(t, favorite.City)
+		require.NoError(t, store.UpdateFavorite(&changed, owner))
+
+		// Another user can neither see, change nor delete the favorite
+		_, err = store.GetFavoriteByID(homeID, intruder)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		assert.ErrorIs(t, store.UpdateFavorite(&changed, intruder), sql.ErrNoRows)
+		deleted, err := store.DeleteFavorite(homeID, intruder)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, deleted)
+
+		deleted, err = store.DeleteFavorite(homeID, owner)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+		favorites, err = store.FetchFavorites(owner)
+		require.NoError(t, err)
+		assert.Len(t, favorites, 1)
+	})
+}
+
+func TestAlertRules(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		owner := createTestUser(t, store, "alerts")
+		intruder := createTestUser(t, store, "alerts-intruder")
+
+		below := 5.0
+		rule := models.AlertRule{
+			Name:            "Cold in Pune",
+			Location:        models.Location{City: "Pune"},
+			TempBelow:       &below,
+			Condition:       "Thunderstorm",
+			Units:           "metric",
+			Channel:         "webhook",
+			Target:          "https://example.com/hook",
+			CooldownMinutes: 30,
+			Enabled:         true,
+		}
+		id, err := store.CreateAlertRule(rule, owner)
+		require.NoError(t, err)
+
+		disabled := rule
+		disabled.Enabled = false
+		_, err = store.CreateAlertRule(disabled, intruder)
+		require.NoError(t, err)
+
+		rules, err := store.FetchAlertRules(owner)
+		require.NoError(t, err)
+		require.Len(t, rules, 1)
+		assert.Equal(t, "Cold in Pune", rules[0].Name)
+		assert.Equal(t, "Pune", rules[0].City)
+		assert.Equal(t, 5.0, *rules[0].TempBelow)
+		assert.Nil(t, rules[0].TempAbove)
+		assert.Equal(t, "Thunderstorm", rules[0].Condition)
+		assert.True(t, rules[0].Enabled)
+		assert.False(t, rules[0].Active)
+		assert.Nil(t, rules[0].LastNotifiedAt)
+
+		enabled, err := store.FetchEnabledAlertRules()
+		require.NoError(t, err)
+		require.Len(t, enabled, 1)
+		assert.Equal(t, owner, enabled[0].UserID)
+
+		// Only one of two evaluators holding the same count gets to notify
+		now := time.Date(2023, 7, 30, 12, 0, 0, 0, time.UTC)
+		claimed, err := store.ClaimAlertNotification(id, 0, now)
+		require.NoError(t, err)
+		assert.True(t, claimed)
+		claimed, err = store.ClaimAlertNotification(id, 0, now)
+		require.NoError(t, err)
+		assert.False(t, claimed)
+
+		got, err := store.GetAlertRuleByID(id, owner)
+		require.NoError(t, err)
+		assert.True(t, got.Active)
+		assert.Equal(t, 1, got.NotificationCount)
+		assert.Equal(t, now, *got.LastNotifiedAt)
+
+		// A claim whose notification failed is released as it was before
+		claimed, err = store.ClaimAlertNotification(id, 1, now.Add(time.Hour))
+		require.NoError(t, err)
+		require.True(t, claimed)
+		released, err := store.ReleaseAlertNotification(id, 1, &now)
+		require.NoError(t, err)
+		assert.True(t, released)
+		released, err = store.ReleaseAlertNotification(id, 1, &now)
+		require.NoError(t, err)
+		assert.False(t, released)
+
+		got, err = store.GetAlertRuleByID(id, owner)
+		require.NoError(t, err)
+		assert.False(t, got.Active)
+		assert.Equal(t, 1, got.NotificationCount)
+		assert.Equal(t, now, *got.LastNotifiedAt)
+
+		require.NoError(t, store.SetAlertRuleActive(id, true))
+		require.NoError(t, store.SetAlertRuleActive(id, false))
+		got, err = store.GetAlertRuleByID(id, owner)
+		require.NoError(t, err)
+		assert.False(t, got.Active)
+
+		changed := rule
+		changed.ID = id
+		changed.Name = "Storm in Pune"
+		changed.TempBelow = nil
+		require.NoError(t, store.UpdateAlertRule(&changed, owner))
+		got, err = store.GetAlertRuleByID(id, owner)
+		require.NoError(t, err)
+		assert.Equal(t, "Storm in Pune", got.Name)
+		assert.Nil(t, got.TempBelow)
+		assert.Equal(t, 1, got.NotificationCount)
+
+		// Another user can neither see, change nor delete the rule
+		_, err = store.GetAlertRuleByID(id, intruder)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		assert.ErrorIs(t, store.UpdateAlertRule(&changed, intruder), sql.ErrNoRows)
+		deleted, err := store.DeleteAlertRule(id, intruder)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, deleted)
+
+		deleted, err = store.DeleteAlertRule(id, owner)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+	})
+}
+
+func TestAlertEmailAddresses(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		owner := createTestUser(t, store, "alert-emails")
+		other := createTestUser(t, store, "alert-emails-other")
+
+		sentAt := time.Date(2023, 7, 30, 12, 0, 0, 0, time.UTC)
+		id, err := store.CreateAlertEmailAddress(models.AlertEmailAddress{UserID: owner, Address: "team@example.com", TokenHash: "first", SentAt: sentAt})
+		require.NoError(t, err)
+
+		_, err = store.GetAlertEmailAddress(other, "team@example.com")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		got, err := store.GetAlertEmailAddress(owner, "team@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, id, got.ID)
+		assert.Equal(t, "first", got.TokenHash)
+		assert.Equal(t, sentAt, got.SentAt)
+		assert.Nil(t, got.ConfirmedAt)
+
+		// Resending replaces the token, and old or expired tokens confirm nothing
+		require.NoError(t, store.ResendAlertEmailAddress(id, "second", sentAt.Add(time.Hour)))
+		confirmed, err := store.ConfirmAlertEmailAddress("first", sentAt, sentAt.Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 0, confirmed)
+		confirmed, err = store.ConfirmAlertEmailAddress("second", sentAt.Add(2*time.Hour), sentAt.Add(2*time.Hour))
+		require.NoError(t, err)
+		assert.Equal(t, 0, confirmed)
+
+		now := sentAt.Add(2 * time.Hour)
+		confirmed, err = store.ConfirmAlertEmailAddress("second", sentAt, now)
+		require.NoError(t, err)
+		assert.Equal(t, 1, confirmed)
+		confirmed, err = store.ConfirmAlertEmailAddress("second", sentAt, now)
+		require.NoError(t, err)
+		assert.Equal(t, 0, confirmed)
+
+		got, err = store.GetAlertEmailAddress(owner, "team@example.com")
+		require.NoError(t, err)
+		require.NotNil(t, got.ConfirmedAt)
+		assert.Equal(t, now, *got.ConfirmedAt)
+
+		// A confirmed address keeps its token
+		require.NoError(t, store.ResendAlertEmailAddress(id, "third", now))
+		got, err = store.GetAlertEmailAddress(owner, "team@example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "second", got.TokenHash)
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		owner := createTestUser(t, store, "webhooks")
+		intruder := createTestUser(t, store, "webhooks-intruder")
+
+		hookID, err := store.CreateWebhook(models.Webhook{
+			URL:    "https://example.com/hook",
+			Secret: "s3cret",
+			Events: []string{"weather.fetched", "history.cleared"},
+		}, owner)
+		require.NoError(t, err)
+		allID, err := store.CreateWebhook(models.Webhook{URL: "https://example.com/all", Secret: "s3cret"}, owner)
+		require.NoError(t, err)
+
+		hooks, err := store.FetchWebhooks(owner)
+		require.NoError(t, err)
+		require.Len(t, hooks, 2)
+		assert.Equal(t, "https://example.com/hook", hooks[0].URL)
+		assert.Equal(t, "s3cret", hooks[0].Secret)
+		assert.Equal(t, []string{"weather.fetched", "history.cleared"}, hooks[0].Events)
+		assert.Empty(t, hooks[1].Events)
+
+		nextAttemptAt := time.Date(2023, 7, 30, 11, 0, 0, 0, time.UTC)
+		deliveryID, err := store.CreateWebhookDelivery(models.WebhookDelivery{
+			WebhookID:     hookID,
+			UserID:        owner,
+			Event:         "weather.fetched",
+			Payload:       json.RawMessage(`{"event":"weather.fetched"}`),
+			Status:        "pending",
+			NextAttemptAt: &nextAttemptAt,
+		})
+		require.NoError(t, err)
+		_, err = store.CreateWebhookDelivery(models.WebhookDelivery{
+			WebhookID: allID,
+			UserID:    owner,
+			Event:     "history.cleared",
+			Payload:   json.RawMessage(`{"event":"history.cleared"}`),
+			Status:    "pending",
+		})
+		require.NoError(t, err)
+
+		// Only deliveries with an attempt due are fetched, and each attempt
+		// is claimed once
+		due, err := store.FetchDueWebhookDeliveries(nextAttemptAt.Add(-time.Second), nil, 10)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+		due, err = store.FetchDueWebhookDeliveries(nextAttemptAt, nil, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		assert.Equal(t, deliveryID, due[0].ID)
+		assert.Equal(t, nextAttemptAt, *due[0].NextAttemptAt)
+
+		// Deliveries to webhooks being skipped are left out
+		due, err = store.FetchDueWebhookDeliveries(nextAttemptAt, []int{hookID, allID}, 10)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+
+		claimed, err := store.ClaimWebhookDelivery(deliveryID, 0, nextAttemptAt.Add(time.Minute))
+		require.NoError(t, err)
+		assert.True(t, claimed)
+		claimed, err = store.ClaimWebhookDelivery(deliveryID, 0, nextAttemptAt.Add(time.Minute))
+		require.NoError(t, err)
+		assert.False(t, claimed)
+		due, err = store.FetchDueWebhookDeliveries(nextAttemptAt, nil, 10)
+		require.NoError(t, err)
+		assert.Empty(t, due)
+
+		deliveredAt := time.Date(2023, 7, 30, 12, 0, 0, 0, time.UTC)
+		require.NoError(t, store.UpdateWebhookDelivery(&models.WebhookDelivery{
+			ID:             deliveryID,
+			Status:         "succeeded",
+			Attempts:       2,
+			ResponseStatus: 200,
+			DeliveredAt:    &deliveredAt,
+		}))
+
+		deliveries, err := store.FetchWebhookDeliveries(hookID, owner, 10)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, deliveryID, deliveries[0].ID)
+		assert.Equal(t, "succeeded", deliveries[0].Status)
+		assert.Equal(t, 2, deliveries[0].Attempts)
+		assert.Equal(t, 200, deliveries[0].ResponseStatus)
+		assert.Equal(t, deliveredAt, *deliveries[0].DeliveredAt)
+		assert.Nil(t, deliveries[0].NextAttemptAt)
+		assert.JSONEq(t, `{"event":"weather.fetched"}`, string(deliveries[0].Payload))
+
+		// Another user can neither see nor delete the webhook or its deliveries
+		_, err = store.GetWebhookByID(hookID, intruder)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		_, err = store.GetWebhookDeliveryByID(deliveryID, intruder)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		deliveries, err = store.FetchWebhookDeliveries(hookID, intruder, 10)
+		require.NoError(t, err)
+		assert.Empty(t, deliveries)
+		deleted, err := store.DeleteWebhook(hookID, intruder)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, deleted)
+
+		// Deleting a webhook deletes its deliveries
+		deleted, err = store.DeleteWebhook(hookID, owner)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+		_, err = store.GetWebhookDeliveryByID(deliveryID, owner)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func TestSchedules(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		owner := createTestUser(t, store, "schedules")
+		intruder := createTestUser(t, store, "schedules-intruder")
+
+		now := time.Date(2023, 7, 30, 12, 0, 0, 0, time.UTC)
+		schedule := models.PollSchedule{
+			Location:        models.Location{City: "Pune"},
+			IntervalMinutes: 30,
+			Enabled:         true,
+			NextRunAt:       now,
+		}
+		id, err := store.CreateSchedule(schedule, owner)
+		require.NoError(t, err)
+
+		later := schedule
+		later.Location = models.Location{City: "Delhi"}
+		later.NextRunAt = now.Add(time.Hour)
+		laterID, err := store.CreateSchedule(later, owner)
+		require.NoError(t, err)
+
+		disabled := schedule
+		disabled.Enabled = false
+		_, err = store.CreateSchedule(disabled, intruder)
+		require.NoError(t, err)
+
+		schedules, err := store.FetchSchedules(owner)
+		require.NoError(t, err)
+		require.Len(t, schedules, 2)
+		assert.Equal(t, "Pune", schedules[0].City)
+		assert.Equal(t, 30, schedules[0].IntervalMinutes)
+		assert.Equal(t, now, schedules[0].NextRunAt)
+		assert.Nil(t, schedules[0].LastRunAt)
+
+		// Only enabled schedules that are due are returned, the longest overdue first
+		due, err := store.FetchDueSchedules(now.Add(2*time.Hour), 10)
+		require.NoError(t, err)
+		require.Len(t, due, 2)
+		assert.Equal(t, id, due[0].ID)
+		assert.Equal(t, owner, due[0].UserID)
+		assert.Equal(t, laterID, due[1].ID)
+
+		due, err = store.FetchDueSchedules(now, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		due, err = store.FetchDueSchedules(now.Add(2*time.Hour), 1)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+
+		// Only one of two schedulers holding the same count gets to run it
+		next := now.Add(30 * time.Minute)
+		claimed, err := store.ClaimSchedule(id, 0, now, next)
+		require.NoError(t, err)
+		assert.True(t, claimed)
+		claimed, err = store.ClaimSchedule(id, 0, now, next)
+		require.NoError(t, err)
+		assert.False(t, claimed)
+
+		require.NoError(t, store.SetScheduleError(id, "City not found."))
+		got, err := store.GetScheduleByID(id, owner)
+		require.NoError(t, err)
+		assert.Equal(t, 1, got.RunCount)
+		assert.Equal(t, now, *got.LastRunAt)
+		assert.Equal(t, next, got.NextRunAt)
+		assert.Equal(t, "City not found.", got.LastError)
+
+		changed := *got
+		changed.IntervalMinutes = 60
+		changed.Enabled = false
+		require.NoError(t, store.UpdateSchedule(&changed, owner))
+		got, err = store.GetScheduleByID(id, owner)
+		require.NoError(t, err)
+		assert.Equal(t, 60, got.IntervalMinutes)
+		assert.False(t, got.Enabled)
+		assert.Equal(t, 1, got.RunCount)
+
+		// Another user can neither see, change nor delete the schedule
+		_, err = store.GetScheduleByID(id, intruder)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+		assert.ErrorIs(t, store.UpdateSchedule(&changed, intruder), sql.ErrNoRows)
+		deleted, err := store.DeleteSchedule(id, intruder)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, deleted)
+
+		deleted, err = store.DeleteSchedule(id, owner)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, deleted)
+	})
+}
+
+func TestLoginAttempts(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		username := "attempts-" + time.Now().Format("150405.000000000") + "@example.com"
+		if s, ok := store.(*SQLStore); ok {
+			t.Cleanup(func() { s.DB().Exec("DELETE FROM login_attempts WHERE username = ? OR ip = ?", username, "192.0.2.7") })
+		}
+
+		now := time.Date(2023, 7, 30, 12, 0, 0, 0, time.UTC)
+		for _, attempt := range []models.LoginAttempt{
+			{Username: username, IP: "192.0.2.7", Event: "failure", CreatedAt: now.Add(-time.Hour)},
+			{Username: username, IP: "192.0.2.7", Event: "failure", CreatedAt: now},
+			{Username: username, IP: "198.51.100.1", Event: "success", CreatedAt: now.Add(time.Minute)},
+			{Username: "other-" + username, IP: "192.0.2.7", Event: "failure", CreatedAt: now.Add(2 * time.Minute)},
+			{Username: "other-" + username, IP: "198.51.100.2", Event: "failure", CreatedAt: now.Add(3 * time.Minute)},
+		} {
+			id, err := store.RecordLoginAttempt(attempt)
+			require.NoError(t, err)
+			assert.NotZero(t, id)
+		}
+
+		// Attempts of the username from any IP and from the IP for any
+		// username, oldest first
+		attempts, err := store.FetchLoginAttempts(username, "192.0.2.7", now.Add(-time.Minute))
+		require.NoError(t, err)
+		require.Len(t, attempts, 3)
+		assert.Equal(t, "failure", attempts[0].Event)
+		assert.True(t, now.Equal(attempts[0].CreatedAt))
+		assert.Equal(t, "198.51.100.1", attempts[1].IP)
+		assert.Equal(t, "success", attempts[1].Event)
+		assert.Equal(t, "other-"+username, attempts[2].Username)
+
+		require.NoError(t, store.UpdateLoginAttempt(attempts[0].ID, "throttled"))
+		attempts, err = store.FetchLoginAttempts(username, "192.0.2.7", now.Add(-time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, "throttled", attempts[0].Event)
+
+		// Purging keeps the attempts made since
+		purged, err := store.PurgeLoginAttempts(now.Add(time.Minute))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, purged, 2)
+		attempts, err = store.FetchLoginAttempts(username, "192.0.2.7", now.Add(-2*time.Hour))
+		require.NoError(t, err)
+		require.Len(t, attempts, 2)
+		assert.Equal(t, "success", attempts[0].Event)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		userID := createTestUser(t, store, "sessions")
+		now := time.Now().UTC().Truncate(time.Second)
+		hash := "old-" + now.Format("150405.000000000")
+
+		sessionID, err := store.CreateSession(models.Session{
+			UserID:          userID,
+			TokenHash:       hash,
+			Device:          "phone",
+			IP:              "127.0.0.1",
+			LastUsedAt:      now,
+			ExpiresAt:       now.Add(time.Hour),
+			AccessTokenID:   "first-" + hash,
+			AccessExpiresAt: now.Add(15 * time.Minute),
+		})
+		require.NoError(t, err)
+
+		session, err := store.GetSessionByTokenHash(hash)
+		require.NoError(t, err)
+		assert.Equal(t, sessionID, session.ID)
+		assert.Equal(t, now.Add(time.Hour), session.ExpiresAt)
+		assert.Nil(t, session.RevokedAt)
+		assert.Equal(t, "first-"+hash, session.AccessTokenID)
+		assert.Equal(t, now.Add(15*time.Minute), session.AccessExpiresAt)
+
+		// A refresh token can only be rotated once
+		affectedRows, err := store.RotateSession(sessionID, hash, "new-"+hash, now, now.Add(2*time.Hour), "second-"+hash, now.Add(30*time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, 1, affectedRows)
+
+		affectedRows, err = store.RotateSession(sessionID, hash, "other-"+hash, now, now.Add(2*time.Hour), "third-"+hash, now.Add(30*time.Minute))
+		require.NoError(t, err)
+		assert.Equal(t, 0, affectedRows)
+
+		session, err = store.GetSessionByID(sessionID, userID)
+		require.NoError(t, err)
+		assert.Equal(t, "new-"+hash, session.TokenHash)
+		assert.Equal(t, "second-"+hash, session.AccessTokenID)
+		assert.Equal(t, now.Add(30*time.Minute), session.AccessExpiresAt)
+		_, err = store.GetSessionByID(sessionID, userID+1)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+
+		sessions, err := store.FetchActiveSessions(userID, now)
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+		assert.Equal(t, "phone", sessions[0].Device)
+
+		affectedRows, err = store.RevokeSession(sessionID, userID+1, now)
+		require.NoError(t, err)
+		assert.Equal(t, 0, affectedRows)
+
+		affectedRows, err = store.RevokeSessionByTokenHash("new-"+hash, userID, now)
+		require.NoError(t, err)
+		assert.Equal(t, 1, affectedRows)
+
+		sessions, err = store.FetchActiveSessions(userID, now)
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+	})
+}
+
+func TestRevokedTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Store) {
+		now := time.Now().UTC().Truncate(time.Second)
+		live := "live-" + now.Format("150405.000000000")
+		expired := "expired-" + now.Format("150405.000000000")
+
+		require.NoError(t, store.RevokeToken(live, 1, now.Add(time.Hour)))
+		require.NoError(t, store.RevokeToken(expired, 1, now.Add(-time.Hour)))
+		// Revoking twice extends the expiry instead of failing
+		require.NoError(t, store.RevokeToken(live, 1, now.Add(2*time.Hour)))
+
+		revoked, err := store.FetchRevokedTokens(now)
+		require.NoError(t, err)
+		assert.Equal(t, now.Add(2*time.Hour), revoked[live])
+		assert.NotContains(t, revoked, expired)
+
+		purged, err := store.PurgeExpiredRevokedTokens(now)
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, purged, 1)
+	})
+}
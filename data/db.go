@@ -0,0 +1,187 @@
+package data
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect identifies the SQL flavour spoken by a database.
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// DB is a database handle that knows its dialect. Queries are written once
+// with "?" placeholders, and DB adapts them and their arguments to the
+// database it is connected to.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.Exec(db.rebind(query), db.args(args)...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.Query(db.rebind(query), db.args(args)...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRow(db.rebind(query), db.args(args)...)
+}
+
+// Begin starts a transaction whose queries are adapted like those of db.
+func (db *DB) Begin() (*Tx, error) {
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, db: db}, nil
+}
+
+func (db *DB) insert(query string, args ...interface{}) (int, error) {
+	return insert(db, db.Dialect, query, args...)
+}
+
+// Tx is a transaction on a DB.
+type Tx struct {
+	*sql.Tx
+	db *DB
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.db.rebind(query), tx.db.args(args)...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.db.rebind(query), tx.db.args(args)...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.db.rebind(query), tx.db.args(args)...)
+}
+
+func (tx *Tx) insert(query string, args ...interface{}) (int, error) {
+	return insert(tx, tx.db.Dialect, query, args...)
+}
+
+// queryer is implemented by DB and Tx, so that functions can run either on
+// their own or as part of a transaction.
+type queryer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	insert(query string, args ...interface{}) (int, error)
+}
+
+// insert runs an INSERT into a table with an id column and returns the id of
+// the new row. Postgres has no LastInsertId, so the id is read back with
+// RETURNING instead.
+func insert(q queryer, dialect Dialect, query string, args ...interface{}) (int, error) {
+	if dialect == Postgres {
+		var id int
+		err := q.QueryRow(query+" RETURNING id", args...).Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+		return id, nil
+	}
+
+	result, err := q.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+// rebind rewrites the "?" placeholders of query into the numbered $1, $2, ...
+// placeholders used by Postgres. Queries never contain a literal "?".
+func (db *DB) rebind(query string) string {
+	if db.Dialect != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// args converts query arguments to what the database stores. SQLite has no
+// date type, so times are written as UTC text in the same layout as its
+// CURRENT_TIMESTAMP, which keeps them comparable and readable by
+// util.ParseTimestamp.
+func (db *DB) args(args []interface{}) []interface{} {
+	if db.Dialect != SQLite {
+		return args
+	}
+
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		if t, ok := arg.(time.Time); ok {
+			arg = t.UTC().Format("2006-01-02 15:04:05")
+		}
+		converted[i] = arg
+	}
+	return converted
+}
+
+// ignoreConflict returns the clause that turns an INSERT into a no-op, which
+// affects no rows, when a row with the same key already exists. MySQL has no
+// such clause, so there the key is set to itself, which also counts as no
+// affected rows unless the connection asks for found rows.
+func (db *DB) ignoreConflict(key string) string {
+	if db.Dialect == MySQL {
+		return " ON DUPLICATE KEY UPDATE " + key + " = " + key
+	}
+	return " ON CONFLICT (" + key + ") DO NOTHING"
+}
+
+// nullableTime returns the UTC time t points to as a query argument, or nil
+// to store NULL when t is nil.
+func nullableTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// upsert returns the clause that turns an INSERT into an update of columns
+// when a row with the same key already exists.
+func (db *DB) upsert(key string, columns ...string) string {
+	clause := ""
+	for i, column := range columns {
+		if i > 0 {
+			clause += ", "
+		}
+		if db.Dialect == MySQL {
+			clause += column + " = VALUES(" + column + ")"
+		} else {
+			clause += column + " = excluded." + column
+		}
+	}
+
+	if db.Dialect == MySQL {
+		return " ON DUPLICATE KEY UPDATE " + clause
+	}
+	return " ON CONFLICT (" + key + ") DO UPDATE SET " + clause
+}
@@ -0,0 +1,37 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRebind(t *testing.T) {
+	query := "SELECT id FROM weather_history WHERE user_id = ? AND (temp < ? OR (temp = ? AND id < ?)) LIMIT ?"
+
+	mysql := &DB{Dialect: MySQL}
+	assert.Equal(t, query, mysql.rebind(query))
+
+	postgres := &DB{Dialect: Postgres}
+	assert.Equal(t, "SELECT id FROM weather_history WHERE user_id = $1 AND (temp < $2 OR (temp = $3 AND id < $4)) LIMIT $5", postgres.rebind(query))
+}
+
+func TestUpsert(t *testing.T) {
+	mysql := &DB{Dialect: MySQL}
+	assert.Equal(t, " ON DUPLICATE KEY UPDATE payload = VALUES(payload), expires_at = VALUES(expires_at)", mysql.upsert("cache_key", "payload", "expires_at"))
+
+	for _, dialect := range []Dialect{SQLite, Postgres} {
+		db := &DB{Dialect: dialect}
+		assert.Equal(t, " ON CONFLICT (cache_key) DO UPDATE SET payload = excluded.payload, expires_at = excluded.expires_at", db.upsert("cache_key", "payload", "expires_at"))
+	}
+}
+
+func TestIgnoreConflict(t *testing.T) {
+	mysql := &DB{Dialect: MySQL}
+	assert.Equal(t, " ON DUPLICATE KEY UPDATE bucket_key = bucket_key", mysql.ignoreConflict("bucket_key"))
+
+	for _, dialect := range []Dialect{SQLite, Postgres} {
+		db := &DB{Dialect: dialect}
+		assert.Equal(t, " ON CONFLICT (bucket_key) DO NOTHING", db.ignoreConflict("bucket_key"))
+	}
+}
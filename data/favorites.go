@@ -0,0 +1,170 @@
+package data
+
+import (
+	"database/sql"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+const favoriteColumns = "id, label, position, city, lat, lon, city_id, zip, country, created_at"
+
+// CreateFavorite saves a favorite for userID after the ones it already has.
+func CreateFavorite(db *DB, favorite models.FavoriteLocation, userID int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var position int
+	err = tx.QueryRow("SELECT COALESCE(MAX(position) + 1, 0) FROM favorite_locations WHERE user_id = ?", userID).Scan(&position)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := "INSERT INTO favorite_locations (user_id, label, position, city, lat, lon, city_id, zip, country) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	id, err := tx.insert(stmt,
+		userID,
+		favorite.Label,
+		position,
+		favorite.City,
+		favorite.Lat,
+		favorite.Lon,
+		favorite.CityID,
+		favorite.Zip,
+		favorite.Country,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// FetchFavorites returns the favorites of userID in their order.
+func FetchFavorites(db *DB, userID int) ([]models.FavoriteLocation, error) {
+	var favorites []models.FavoriteLocation
+
+	stmt := "SELECT " + favoriteColumns + " FROM favorite_locations WHERE user_id = ? ORDER BY position, id"
+
+	rows, err := db.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		favorite, err := scanFavorite(rows)
+		if err != nil {
+			return nil, err
+		}
+		favorites = append(favorites, *favorite)
+	}
+
+	return favorites, rows.Err()
+}
+
+func GetFavoriteByID(db *DB, id int, userID int) (*models.FavoriteLocation, error) {
+	stmt := "SELECT " + favoriteColumns + " FROM favorite_locations WHERE id = ? AND user_id = ?"
+
+	return scanFavorite(db.QueryRow(stmt, id, userID))
+}
+
+// UpdateFavorite changes the label and location of a favorite of userID,
+// returning sql.ErrNoRows when it has no favorite with that id.
+func UpdateFavorite(db *DB, favorite *models.FavoriteLocation, userID int) error {
+	stmt := "UPDATE favorite_locations SET label = ?, city = ?, lat = ?, lon = ?, city_id = ?, zip = ?, country = ? WHERE id = ? AND user_id = ?"
+
+	result, err := db.Exec(stmt,
+		favorite.Label,
+		favorite.City,
+		favorite.Lat,
+		favorite.Lon,
+		favorite.CityID,
+		favorite.Zip,
+		favorite.Country,
+		favorite.ID,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// MySQL reports no affected rows when nothing changed, so tell an
+	// unchanged favorite apart from a missing one.
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affectedRows == 0 {
+		_, err := GetFavoriteByID(db, favorite.ID, userID)
+		return err
+	}
+
+	return nil
+}
+
+func DeleteFavorite(db *DB, id int, userID int) (int, error) {
+	stmt := "DELETE FROM favorite_locations WHERE id = ? AND user_id = ?"
+
+	result, err := db.Exec(stmt, id, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affectedRows), nil
+}
+
+// ReorderFavorites gives the favorites of userID the positions of their ids
+// in ids. Ids of favorites belonging to other users are ignored.
+func ReorderFavorites(db *DB, userID int, ids []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for position, id := range ids {
+		_, err := tx.Exec("UPDATE favorite_locations SET position = ? WHERE id = ? AND user_id = ?", position, id, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func scanFavorite(row rowScanner) (*models.FavoriteLocation, error) {
+	favorite := &models.FavoriteLocation{}
+
+	var lat, lon sql.NullFloat64
+	var createdAt string
+	err := row.Scan(
+		&favorite.ID,
+		&favorite.Label,
+		&favorite.Position,
+		&favorite.City,
+		&lat,
+		&lon,
+		&favorite.CityID,
+		&favorite.Zip,
+		&favorite.Country,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lat.Valid && lon.Valid {
+		favorite.Lat, favorite.Lon = &lat.Float64, &lon.Float64
+	}
+	favorite.CreatedAt, _ = util.ParseTimestamp(createdAt)
+
+	return favorite, nil
+}
@@ -0,0 +1,342 @@
+package data
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded or
+// was issued for another sort, order or set of filters.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+const historyColumns = "id, city_name, user_id, coord_lon, coord_lat, base, temp, feels_like, temp_min, temp_max, pressure, humidity, visibility, wind_speed, wind_deg, clouds_all, dt, sys_type, sys_id, sys_country, sys_sunrise, sys_sunset, timezone, provider, provider_response_ms, created_at"
+
+// historySortColumns maps the sort names accepted by the API to columns.
+// Sorting by creation time uses the id, which increases with every insert.
+var historySortColumns = map[string]string{
+	"created_at": "id",
+	"temp":       "temp",
+	"city":       "city_name",
+}
+
+// historyCursor marks the last row of a page: its sort value and id, along
+// with the sort, order and filters of the query it continues, since resuming
+// after that row under any others would skip or repeat rows.
+type historyCursor struct {
+	Sort    string      `json:"s"`
+	Order   string      `json:"o"`
+	Filters string      `json:"f"`
+	Value   interface{} `json:"v"`
+	ID      int         `json:"id"`
+}
+
+func encodeHistoryCursor(query models.HistoryQuery, value interface{}, id int) string {
+	b, _ := json.Marshal(historyCursor{
+		Sort:    query.Sort,
+		Order:   query.Order,
+		Filters: historyFilters(query),
+		Value:   value,
+		ID:      id,
+	})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeHistoryCursor(query models.HistoryQuery) (*historyCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c historyCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != query.Sort || c.Order != query.Order || c.Filters != historyFilters(query) {
+		return nil, ErrInvalidCursor
+	}
+
+	switch query.Sort {
+	case "temp":
+		if _, ok := c.Value.(float64); !ok {
+			return nil, ErrInvalidCursor
+		}
+	case "city":
+		if _, ok := c.Value.(string); !ok {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	return &c, nil
+}
+
+// historyFilters returns a short digest of the filters of query, which keeps
+// cursors small while telling the filters they were issued for apart.
+func historyFilters(query models.HistoryQuery) string {
+	utc := func(t *time.Time) *time.Time {
+		if t == nil {
+			return nil
+		}
+		u := t.UTC()
+		return &u
+	}
+
+	b, _ := json.Marshal([]interface{}{
+		query.City,
+		query.Country,
+		query.Condition,
+		utc(query.From),
+		utc(query.To),
+		query.MinTemp,
+		query.MaxTemp,
+	})
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// historyFilter builds the WHERE clause shared by the page and count queries.
+func historyFilter(userID int, query models.HistoryQuery) (string, []interface{}) {
+	conditions := []string{"user_id = ?"}
+	args := []interface{}{userID}
+
+	if query.City != "" {
+		conditions = append(conditions, "city_name = ?")
+		args = append(args, query.City)
+	}
+	if query.Country != "" {
+		conditions = append(conditions, "sys_country = ?")
+		args = append(args, query.Country)
+	}
+	if query.Condition != "" {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM weather_history_conditions c WHERE c.weather_history_id = weather_history.id AND c.main = ?)")
+		args = append(args, query.Condition)
+	}
+	if query.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, query.From.UTC())
+	}
+	if query.To != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, query.To.UTC())
+	}
+	if query.MinTemp != nil {
+		conditions = append(conditions, "temp >= ?")
+		args = append(args, *query.MinTemp)
+	}
+	if query.MaxTemp != nil {
+		conditions = append(conditions, "temp <= ?")
+		args = append(args, *query.MaxTemp)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// FetchWeatherHistoryPage returns one page of userID's history matching query,
+// ordered by query.Sort and query.Order, with the total number of matches.
+func FetchWeatherHistoryPage(db *DB, userID int, query models.HistoryQuery) (*models.HistoryPage, error) {
+	column, ok := historySortColumns[query.Sort]
+	if !ok {
+		return nil, errors.New("invalid sort")
+	}
+
+	where, args := historyFilter(userID, query)
+
+	page := &models.HistoryPage{Items: []models.WeatherResponse{}, Limit: query.Limit}
+	err := db.QueryRow("SELECT COUNT(*) FROM weather_history WHERE "+where, args...).Scan(&page.TotalCount)
+	if err != nil {
+		return nil, err
+	}
+
+	direction, comparison := "DESC", "<"
+	if query.Order == "asc" {
+		direction, comparison = "ASC", ">"
+	}
+
+	// Keyset pagination: continue strictly after the (sort value, id) of the
+	// last row of the previous page, using the id to break ties.
+	if query.Cursor != "" {
+		cursor, err := decodeHistoryCursor(query)
+		if err != nil {
+			return nil, err
+		}
+		if column == "id" {
+			where += " AND id " + comparison + " ?"
+			args = append(args, cursor.ID)
+		} else {
+			where += " AND (" + column + " " + comparison + " ? OR (" + column + " = ? AND id " + comparison + " ?))"
+			args = append(args, cursor.Value, cursor.Value, cursor.ID)
+		}
+	}
+
+	columns := historyColumns
+	if query.IncludeRaw {
+		columns += ", raw_payload"
+	}
+
+	stmt := "SELECT " + columns + " FROM weather_history WHERE " + where + " ORDER BY " + column + " " + direction
+	if column != "id" {
+		stmt += ", id " + direction
+	}
+	stmt += " LIMIT ?"
+	// Fetch one extra row to learn whether there is a next page.
+	args = append(args, query.Limit+1)
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		weather, err := scanWeather(rows, query.IncludeRaw)
+		if err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, *weather)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if len(page.Items) > query.Limit {
+		page.Items = page.Items[:query.Limit]
+		last := page.Items[len(page.Items)-1]
+
+		var value interface{}
+		switch query.Sort {
+		case "temp":
+			value = last.Main.Temp
+		case "city":
+			value = last.Name
+		}
+		page.NextCursor = encodeHistoryCursor(query, value, last.WeatherID)
+	}
+
+	if err := attachConditions(db, page.Items); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// scanWeather reads a row of historyColumns, followed by raw_payload when
+// includeRaw is set.
+func scanWeather(row rowScanner, includeRaw bool) (*models.WeatherResponse, error) {
+	weather := &models.WeatherResponse{}
+
+	var createdAt string
+	var raw sql.NullString
+	dest := []interface{}{
+		&weather.WeatherID,
+		&weather.Name,
+		&weather.UserID,
+		&weather.Coord.Lon,
+		&weather.Coord.Lat,
+		&weather.Base,
+		&weather.Main.Temp,
+		&weather.Main.FeelsLike,
+		&weather.Main.TempMin,
+		&weather.Main.TempMax,
+		&weather.Main.Pressure,
+		&weather.Main.Humidity,
+		&weather.Visibility,
+		&weather.Wind.Speed,
+		&weather.Wind.Deg,
+		&weather.Clouds.All,
+		&weather.Dt,
+		&weather.Sys.Type,
+		&weather.Sys.ID,
+		&weather.Sys.Country,
+		&weather.Sys.Sunrise,
+		&weather.Sys.Sunset,
+		&weather.Timezone,
+		&weather.Provider,
+		&weather.ProviderResponseMS,
+		&createdAt,
+	}
+	if includeRaw {
+		dest = append(dest, &raw)
+	}
+
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	weather.CreatedAt, _ = util.ParseTimestamp(createdAt)
+	if raw.Valid {
+		weather.Raw = json.RawMessage(raw.String)
+	}
+
+	return weather, nil
+}
+
+// rawPayload returns the value stored in raw_payload: the payload as text, or
+// NULL when the provider did not supply one.
+func rawPayload(raw json.RawMessage) interface{} {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
+
+// insertWeatherConditions stores the conditions of a lookup in the order the
+// provider returned them.
+func insertWeatherConditions(q queryer, weatherID int, conditions []models.Weather) error {
+	stmt := "INSERT INTO weather_history_conditions (weather_history_id, position, condition_id, main, description, icon) VALUES (?, ?, ?, ?, ?, ?)"
+
+	for i, condition := range conditions {
+		_, err := q.Exec(stmt, weatherID, i, condition.ID, condition.Main, condition.Description, condition.Icon)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// attachConditions loads the conditions of every lookup in history with a
+// single query. Lookups without conditions get an empty list.
+func attachConditions(q queryer, history []models.WeatherResponse) error {
+	if len(history) == 0 {
+		return nil
+	}
+
+	index := make(map[int]int, len(history))
+	placeholders := make([]string, len(history))
+	args := make([]interface{}, len(history))
+	for i := range history {
+		history[i].Weathers = []models.Weather{}
+		index[history[i].WeatherID] = i
+		placeholders[i] = "?"
+		args[i] = history[i].WeatherID
+	}
+
+	stmt := "SELECT weather_history_id, condition_id, main, description, icon FROM weather_history_conditions WHERE weather_history_id IN (" + strings.Join(placeholders, ", ") + ") ORDER BY weather_history_id, position"
+
+	rows, err := q.Query(stmt, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var weatherID int
+		var condition models.Weather
+		if err := rows.Scan(&weatherID, &condition.ID, &condition.Main, &condition.Description, &condition.Icon); err != nil {
+			return err
+		}
+		i := index[weatherID]
+		history[i].Weathers = append(history[i].Weathers, condition)
+	}
+
+	return rows.Err()
+}
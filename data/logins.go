@@ -0,0 +1,61 @@
+package data
+
+import (
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+func RecordLoginAttempt(db *DB, attempt models.LoginAttempt) (int, error) {
+	stmt := "INSERT INTO login_attempts (username, ip, event, created_at) VALUES (?, ?, ?, ?)"
+
+	return db.insert(stmt, attempt.Username, attempt.IP, attempt.Event, attempt.CreatedAt.UTC())
+}
+
+// UpdateLoginAttempt changes the event of a login attempt, such as a pending
+// login that turned out to fail.
+func UpdateLoginAttempt(db *DB, id int, event string) error {
+	_, err := db.Exec("UPDATE login_attempts SET event = ? WHERE id = ?", event, id)
+	return err
+}
+
+// FetchLoginAttempts returns the login events of username and those from ip
+// since since, in the order they were recorded.
+func FetchLoginAttempts(db *DB, username string, ip string, since time.Time) ([]models.LoginAttempt, error) {
+	stmt := "SELECT id, username, ip, event, created_at FROM login_attempts WHERE (username = ? OR ip = ?) AND created_at >= ? ORDER BY id"
+
+	var attempts []models.LoginAttempt
+
+	rows, err := db.Query(stmt, username, ip, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		var createdAt string
+		if err := rows.Scan(&attempt.ID, &attempt.Username, &attempt.IP, &attempt.Event, &createdAt); err != nil {
+			return nil, err
+		}
+		attempt.CreatedAt, _ = util.ParseTimestamp(createdAt)
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
+
+// PurgeLoginAttempts deletes the login history recorded before before.
+func PurgeLoginAttempts(db *DB, before time.Time) (int, error) {
+	result, err := db.Exec("DELETE FROM login_attempts WHERE created_at < ?", before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affectedRows), nil
+}
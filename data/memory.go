@@ -0,0 +1,1183 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+)
+
+// ErrDuplicateUsername is returned by MemoryStore.CreateUser when the username is taken.
+var ErrDuplicateUsername = errors.New("username already exists")
+
+// MemoryStore is a Store kept in process memory. It is meant for tests and
+// local development; nothing survives a restart.
+type MemoryStore struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	users     []models.User
+	history   []models.WeatherResponse
+	forecast  []models.ForecastResponse
+	favorites []memoryFavorite
+	alerts    []models.AlertRule
+	// emails holds the alert email addresses, by ID - 1.
+	emails   []models.AlertEmailAddress
+	webhooks []models.Webhook
+	// deliveries holds the deliveries of every webhook, by delivery ID - 1.
+	deliveries []models.WebhookDelivery
+	schedules  []models.PollSchedule
+	sessions   []models.Session
+	logins     []models.LoginAttempt
+	revoked    map[string]time.Time
+}
+
+// memoryFavorite is a favorite together with its owner. Deleted favorites
+// keep their slot with a zero ID.
+type memoryFavorite struct {
+	userID   int
+	favorite models.FavoriteLocation
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		now:     time.Now,
+		revoked: make(map[string]time.Time),
+	}
+}
+
+func (m *MemoryStore) CreateUser(username string, password string, birthDate time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if strings.EqualFold(user.Username, username) {
+			return 0, ErrDuplicateUsername
+		}
+	}
+
+	user := models.User{
+		ID:          len(m.users) + 1,
+		Username:    username,
+		Password:    password,
+		DateOfBirth: birthDate,
+		CreatedAt:   m.now().UTC(),
+		Units:       "standard",
+	}
+	m.users = append(m.users, user)
+
+	return user.ID, nil
+}
+
+func (m *MemoryStore) GetUserByUsername(username string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if strings.EqualFold(user.Username, username) {
+			return &user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetUserByID(id int) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, user := range m.users {
+		if user.ID == id {
+			return &user, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) UpdateUserPreferences(userID int, prefs models.Preferences) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].ID == userID {
+			m.users[i].Units = prefs.Units
+			m.users[i].Lang = prefs.Lang
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) InsertWeatherHistory(weather models.WeatherResponse, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertWeatherHistory(weather, userID), nil
+}
+
+func (m *MemoryStore) InsertWeatherHistoryBatch(weathers []models.WeatherResponse, userID int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ids := make([]int, len(weathers))
+	for i, weather := range weathers {
+		ids[i] = m.insertWeatherHistory(weather, userID)
+	}
+	return ids, nil
+}
+
+// insertWeatherHistory records weather for userID. The caller holds m.mu.
+func (m *MemoryStore) insertWeatherHistory(weather models.WeatherResponse, userID int) int {
+	weather.WeatherID = len(m.history) + 1
+	weather.UserID = strconv.Itoa(userID)
+	weather.Weathers = append([]models.Weather{}, weather.Weathers...)
+	weather.CreatedAt = m.now().UTC()
+	m.history = append(m.history, weather)
+
+	return weather.WeatherID
+}
+
+func (m *MemoryStore) FetchWeatherHistory(userID int) ([]models.WeatherResponse, error) {
+	weatherHistory := m.userHistory(userID)
+	for i := range weatherHistory {
+		weatherHistory[i].Raw = nil
+	}
+	return weatherHistory, nil
+}
+
+// userHistory returns copies of the live history records of userID.
+func (m *MemoryStore) userHistory(userID int) []models.WeatherResponse {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var weatherHistory []models.WeatherResponse
+	for _, weather := range m.history {
+		if weather.WeatherID != 0 && weather.UserID == strconv.Itoa(userID) {
+			weatherHistory = append(weatherHistory, weather)
+		}
+	}
+	return weatherHistory
+}
+
+func (m *MemoryStore) FetchWeatherHistoryPage(userID int, query models.HistoryQuery) (*models.HistoryPage, error) {
+	if _, ok := historySortColumns[query.Sort]; !ok {
+		return nil, errors.New("invalid sort")
+	}
+
+	var cursor *historyCursor
+	if query.Cursor != "" {
+		var err error
+		cursor, err = decodeHistoryCursor(query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var matches []models.WeatherResponse
+	for _, weather := range m.userHistory(userID) {
+		if !query.IncludeRaw {
+			weather.Raw = nil
+		}
+		if matchesHistoryQuery(weather, query) {
+			matches = append(matches, weather)
+		}
+	}
+
+	desc := query.Order != "asc"
+	sort.SliceStable(matches, func(i, j int) bool {
+		c := compareHistory(matches[i], historySortValue(matches[j], query.Sort), matches[j].WeatherID, query.Sort)
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+
+	page := &models.HistoryPage{Items: []models.WeatherResponse{}, TotalCount: len(matches), Limit: query.Limit}
+	for _, weather := range matches {
+		if cursor != nil {
+			c := compareHistory(weather, cursor.Value, cursor.ID, query.Sort)
+			if (desc && c >= 0) || (!desc && c <= 0) {
+				continue
+			}
+		}
+		if len(page.Items) == query.Limit {
+			last := page.Items[len(page.Items)-1]
+			page.NextCursor = encodeHistoryCursor(query, historySortValue(last, query.Sort), last.WeatherID)
+			break
+		}
+		page.Items = append(page.Items, weather)
+	}
+
+	return page, nil
+}
+
+func hasCondition(weather models.WeatherResponse, main string) bool {
+	for _, condition := range weather.Weathers {
+		if strings.EqualFold(condition.Main, main) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesHistoryQuery(weather models.WeatherResponse, query models.HistoryQuery) bool {
+	if query.City != "" && !strings.EqualFold(weather.Name, query.City) {
+		return false
+	}
+	if query.Country != "" && !strings.EqualFold(weather.Sys.Country, query.Country) {
+		return false
+	}
+	if query.Condition != "" && !hasCondition(weather, query.Condition) {
+		return false
+	}
+	if query.From != nil && weather.CreatedAt.Before(*query.From) {
+		return false
+	}
+	if query.To != nil && !weather.CreatedAt.Before(*query.To) {
+		return false
+	}
+	if query.MinTemp != nil && weather.Main.Temp < *query.MinTemp {
+		return false
+	}
+	if query.MaxTemp != nil && weather.Main.Temp > *query.MaxTemp {
+		return false
+	}
+	return true
+}
+
+func historySortValue(weather models.WeatherResponse, sort string) interface{} {
+	switch sort {
+	case "temp":
+		return weather.Main.Temp
+	case "city":
+		return weather.Name
+	}
+	return nil
+}
+
+// compareHistory orders weather against a (sort value, id) pair the same way
+// the SQL keyset query does.
+func compareHistory(weather models.WeatherResponse, value interface{}, id int, sort string) int {
+	switch sort {
+	case "temp":
+		other := value.(float64)
+		if weather.Main.Temp < other {
+			return -1
+		} else if weather.Main.Temp > other {
+			return 1
+		}
+	case "city":
+		if c := strings.Compare(weather.Name, value.(string)); c != 0 {
+			return c
+		}
+	}
+
+	if weather.WeatherID < id {
+		return -1
+	} else if weather.WeatherID > id {
+		return 1
+	}
+	return 0
+}
+
+func (m *MemoryStore) GetWeatherByID(id int, userID int) (*models.WeatherResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, weather := range m.history {
+		if weather.WeatherID == id && weather.UserID == strconv.Itoa(userID) {
+			return &weather, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) UpdateWeather(weather *models.WeatherResponse, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.history {
+		if existing.WeatherID == weather.WeatherID && existing.UserID == strconv.Itoa(userID) {
+			updated := *weather
+			updated.Weathers = append([]models.Weather{}, weather.Weathers...)
+			updated.UserID = existing.UserID
+			updated.CreatedAt = existing.CreatedAt
+			m.history[i] = updated
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *MemoryStore) DeleteWeather(id int, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, weather := range m.history {
+		if weather.WeatherID == id && weather.UserID == strconv.Itoa(userID) {
+			// Keep the slot so ids stay stable, like an auto increment column.
+			m.history[i] = models.WeatherResponse{}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) BulkDeleteWeathers(userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	affectedRows := 0
+	for i, weather := range m.history {
+		if weather.WeatherID != 0 && weather.UserID == strconv.Itoa(userID) {
+			m.history[i] = models.WeatherResponse{}
+			affectedRows++
+		}
+	}
+	return affectedRows, nil
+}
+
+func (m *MemoryStore) InsertForecastHistory(forecast models.ForecastResponse, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	forecast.ForecastID = len(m.forecast) + 1
+	forecast.CreatedAt = m.now().UTC()
+	m.forecast = append(m.forecast, forecast)
+
+	return forecast.ForecastID, nil
+}
+
+func (m *MemoryStore) CreateFavorite(favorite models.FavoriteLocation, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	favorite.ID = len(m.favorites) + 1
+	favorite.Position = 0
+	favorite.Location = copyLocation(favorite.Location)
+	favorite.CreatedAt = m.now().UTC()
+	for _, existing := range m.favorites {
+		if existing.favorite.ID != 0 && existing.userID == userID && existing.favorite.Position >= favorite.Position {
+			favorite.Position = existing.favorite.Position + 1
+		}
+	}
+	m.favorites = append(m.favorites, memoryFavorite{userID: userID, favorite: favorite})
+
+	return favorite.ID, nil
+}
+
+func (m *MemoryStore) FetchFavorites(userID int) ([]models.FavoriteLocation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var favorites []models.FavoriteLocation
+	for _, existing := range m.favorites {
+		if existing.favorite.ID != 0 && existing.userID == userID {
+			favorite := existing.favorite
+			favorite.Location = copyLocation(favorite.Location)
+			favorites = append(favorites, favorite)
+		}
+	}
+	sort.SliceStable(favorites, func(i, j int) bool {
+		return favorites[i].Position < favorites[j].Position
+	})
+
+	return favorites, nil
+}
+
+func (m *MemoryStore) GetFavoriteByID(id int, userID int) (*models.FavoriteLocation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.favorites {
+		if existing.favorite.ID == id && existing.userID == userID {
+			favorite := existing.favorite
+			favorite.Location = copyLocation(favorite.Location)
+			return &favorite, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) UpdateFavorite(favorite *models.FavoriteLocation, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.favorites {
+		if existing.favorite.ID == favorite.ID && favorite.ID != 0 && existing.userID == userID {
+			m.favorites[i].favorite.Label = favorite.Label
+			m.favorites[i].favorite.Location = copyLocation(favorite.Location)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *MemoryStore) DeleteFavorite(id int, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.favorites {
+		if existing.favorite.ID == id && id != 0 && existing.userID == userID {
+			m.favorites[i] = memoryFavorite{}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) ReorderFavorites(userID int, ids []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for position, id := range ids {
+		for i, existing := range m.favorites {
+			if existing.favorite.ID == id && id != 0 && existing.userID == userID {
+				m.favorites[i].favorite.Position = position
+			}
+		}
+	}
+	return nil
+}
+
+// copyLocation returns loc with its coordinates copied, so that the caller
+// and the store do not share them.
+func copyLocation(loc models.Location) models.Location {
+	if loc.Lat != nil {
+		lat := *loc.Lat
+		loc.Lat = &lat
+	}
+	if loc.Lon != nil {
+		lon := *loc.Lon
+		loc.Lon = &lon
+	}
+	return loc
+}
+
+func (m *MemoryStore) CreateAlertRule(rule models.AlertRule, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rule = copyAlertRule(rule)
+	rule.ID = len(m.alerts) + 1
+	rule.UserID = userID
+	rule.Active = false
+	rule.NotificationCount = 0
+	rule.LastNotifiedAt = nil
+	rule.CreatedAt = m.now().UTC()
+	m.alerts = append(m.alerts, rule)
+
+	return rule.ID, nil
+}
+
+func (m *MemoryStore) FetchAlertRules(userID int) ([]models.AlertRule, error) {
+	return m.filterAlertRules(func(rule models.AlertRule) bool {
+		return rule.UserID == userID
+	}), nil
+}
+
+func (m *MemoryStore) FetchEnabledAlertRules() ([]models.AlertRule, error) {
+	return m.filterAlertRules(func(rule models.AlertRule) bool {
+		return rule.Enabled
+	}), nil
+}
+
+func (m *MemoryStore) filterAlertRules(match func(models.AlertRule) bool) []models.AlertRule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var rules []models.AlertRule
+	for _, rule := range m.alerts {
+		if rule.ID != 0 && match(rule) {
+			rules = append(rules, copyAlertRule(rule))
+		}
+	}
+	return rules
+}
+
+func (m *MemoryStore) GetAlertRuleByID(id int, userID int) (*models.AlertRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, rule := range m.alerts {
+		if rule.ID == id && id != 0 && rule.UserID == userID {
+			rule = copyAlertRule(rule)
+			return &rule, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) UpdateAlertRule(rule *models.AlertRule, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.alerts {
+		if existing.ID == rule.ID && rule.ID != 0 && existing.UserID == userID {
+			updated := copyAlertRule(*rule)
+			updated.UserID = existing.UserID
+			updated.Active = false
+			updated.NotificationCount = existing.NotificationCount
+			updated.LastNotifiedAt = existing.LastNotifiedAt
+			updated.CreatedAt = existing.CreatedAt
+			m.alerts[i] = updated
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *MemoryStore) DeleteAlertRule(id int, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, rule := range m.alerts {
+		if rule.ID == id && id != 0 && rule.UserID == userID {
+			m.alerts[i] = models.AlertRule{}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) SetAlertRuleActive(id int, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.alerts {
+		if m.alerts[i].ID == id && id != 0 {
+			m.alerts[i].Active = active
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) ClaimAlertNotification(id int, count int, now time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.alerts {
+		if m.alerts[i].ID == id && id != 0 && m.alerts[i].NotificationCount == count {
+			notifiedAt := now.UTC()
+			m.alerts[i].Active = true
+			m.alerts[i].NotificationCount++
+			m.alerts[i].LastNotifiedAt = &notifiedAt
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) ReleaseAlertNotification(id int, count int, lastNotifiedAt *time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.alerts {
+		if m.alerts[i].ID == id && id != 0 && m.alerts[i].NotificationCount == count+1 {
+			m.alerts[i].Active = false
+			m.alerts[i].NotificationCount = count
+			m.alerts[i].LastNotifiedAt = nil
+			if lastNotifiedAt != nil {
+				notifiedAt := lastNotifiedAt.UTC()
+				m.alerts[i].LastNotifiedAt = &notifiedAt
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) CreateAlertEmailAddress(address models.AlertEmailAddress) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.emails {
+		if existing.UserID == address.UserID && existing.Address == address.Address {
+			return 0, errors.New("alert email address already exists")
+		}
+	}
+
+	address.ID = len(m.emails) + 1
+	address.SentAt = address.SentAt.UTC()
+	address.ConfirmedAt = nil
+	address.CreatedAt = m.now().UTC()
+	m.emails = append(m.emails, address)
+
+	return address.ID, nil
+}
+
+func (m *MemoryStore) GetAlertEmailAddress(userID int, address string) (*models.AlertEmailAddress, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.emails {
+		if existing.UserID == userID && existing.Address == address {
+			if existing.ConfirmedAt != nil {
+				confirmedAt := *existing.ConfirmedAt
+				existing.ConfirmedAt = &confirmedAt
+			}
+			return &existing, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) ResendAlertEmailAddress(id int, tokenHash string, sentAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > len(m.emails) || m.emails[id-1].ConfirmedAt != nil {
+		return nil
+	}
+	m.emails[id-1].TokenHash = tokenHash
+	m.emails[id-1].SentAt = sentAt.UTC()
+	return nil
+}
+
+func (m *MemoryStore) ConfirmAlertEmailAddress(tokenHash string, since, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, address := range m.emails {
+		if address.TokenHash == tokenHash && address.ConfirmedAt == nil && !address.SentAt.Before(since) {
+			confirmedAt := now.UTC()
+			m.emails[i].ConfirmedAt = &confirmedAt
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// copyAlertRule returns rule with its pointers copied, so that the caller and
+// the store do not share them.
+func copyAlertRule(rule models.AlertRule) models.AlertRule {
+	rule.Location = copyLocation(rule.Location)
+	if rule.TempBelow != nil {
+		tempBelow := *rule.TempBelow
+		rule.TempBelow = &tempBelow
+	}
+	if rule.TempAbove != nil {
+		tempAbove := *rule.TempAbove
+		rule.TempAbove = &tempAbove
+	}
+	if rule.LastNotifiedAt != nil {
+		lastNotifiedAt := *rule.LastNotifiedAt
+		rule.LastNotifiedAt = &lastNotifiedAt
+	}
+	return rule
+}
+
+func (m *MemoryStore) CreateWebhook(hook models.Webhook, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hook.ID = len(m.webhooks) + 1
+	hook.UserID = userID
+	hook.Events = append([]string{}, hook.Events...)
+	hook.CreatedAt = m.now().UTC()
+	m.webhooks = append(m.webhooks, hook)
+
+	return hook.ID, nil
+}
+
+func (m *MemoryStore) FetchWebhooks(userID int) ([]models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var hooks []models.Webhook
+	for _, hook := range m.webhooks {
+		if hook.ID != 0 && hook.UserID == userID {
+			hook.Events = append([]string{}, hook.Events...)
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+func (m *MemoryStore) GetWebhookByID(id int, userID int) (*models.Webhook, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, hook := range m.webhooks {
+		if hook.ID == id && id != 0 && hook.UserID == userID {
+			hook.Events = append([]string{}, hook.Events...)
+			return &hook, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) DeleteWebhook(id int, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, hook := range m.webhooks {
+		if hook.ID == id && id != 0 && hook.UserID == userID {
+			m.webhooks[i] = models.Webhook{}
+			for j := range m.deliveries {
+				if m.deliveries[j].WebhookID == id {
+					m.deliveries[j] = models.WebhookDelivery{}
+				}
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) CreateWebhookDelivery(delivery models.WebhookDelivery) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delivery.ID = len(m.deliveries) + 1
+	delivery.Payload = append(json.RawMessage{}, delivery.Payload...)
+	delivery.Attempts = 0
+	delivery.ResponseStatus = 0
+	delivery.Error = ""
+	delivery.CreatedAt = m.now().UTC()
+	delivery.DeliveredAt = nil
+	delivery = copyWebhookDelivery(delivery)
+	m.deliveries = append(m.deliveries, delivery)
+
+	return delivery.ID, nil
+}
+
+func (m *MemoryStore) UpdateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if delivery.ID <= 0 || delivery.ID > len(m.deliveries) || m.deliveries[delivery.ID-1].ID == 0 {
+		return nil
+	}
+
+	stored := &m.deliveries[delivery.ID-1]
+	stored.Status = delivery.Status
+	stored.Attempts = delivery.Attempts
+	stored.ResponseStatus = delivery.ResponseStatus
+	stored.Error = delivery.Error
+	stored.DeliveredAt = nil
+	if delivery.DeliveredAt != nil {
+		deliveredAt := delivery.DeliveredAt.UTC()
+		stored.DeliveredAt = &deliveredAt
+	}
+	stored.NextAttemptAt = nil
+	if delivery.NextAttemptAt != nil {
+		nextAttemptAt := delivery.NextAttemptAt.UTC()
+		stored.NextAttemptAt = &nextAttemptAt
+	}
+	return nil
+}
+
+func (m *MemoryStore) FetchDueWebhookDeliveries(now time.Time, skip []int, limit int) ([]models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	skipped := make(map[int]bool)
+	for _, id := range skip {
+		skipped[id] = true
+	}
+
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range m.deliveries {
+		if delivery.ID != 0 && delivery.Status == "pending" && delivery.NextAttemptAt != nil && !delivery.NextAttemptAt.After(now) && !skipped[delivery.WebhookID] {
+			deliveries = append(deliveries, copyWebhookDelivery(delivery))
+		}
+	}
+
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttemptAt.Before(*deliveries[j].NextAttemptAt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (m *MemoryStore) ClaimWebhookDelivery(id int, attempts int, next time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id <= 0 || id > len(m.deliveries) {
+		return false, nil
+	}
+	stored := &m.deliveries[id-1]
+	if stored.ID == 0 || stored.Status != "pending" || stored.Attempts != attempts {
+		return false, nil
+	}
+
+	nextAttemptAt := next.UTC()
+	stored.Attempts++
+	stored.NextAttemptAt = &nextAttemptAt
+	return true, nil
+}
+
+func (m *MemoryStore) FetchWebhookDeliveries(webhookID int, userID int, limit int) ([]models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deliveries []models.WebhookDelivery
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		delivery := m.deliveries[i]
+		if delivery.ID != 0 && delivery.WebhookID == webhookID && delivery.UserID == userID {
+			deliveries = append(deliveries, copyWebhookDelivery(delivery))
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *MemoryStore) GetWebhookDeliveryByID(id int, userID int) (*models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id <= 0 || id > len(m.deliveries) {
+		return nil, sql.ErrNoRows
+	}
+	delivery := m.deliveries[id-1]
+	if delivery.ID == 0 || delivery.UserID != userID {
+		return nil, sql.ErrNoRows
+	}
+	delivery = copyWebhookDelivery(delivery)
+	return &delivery, nil
+}
+
+// copyWebhookDelivery returns delivery with its payload and times copied, so
+// that the caller and the store do not share them.
+func copyWebhookDelivery(delivery models.WebhookDelivery) models.WebhookDelivery {
+	delivery.Payload = append(json.RawMessage{}, delivery.Payload...)
+	if delivery.DeliveredAt != nil {
+		deliveredAt := *delivery.DeliveredAt
+		delivery.DeliveredAt = &deliveredAt
+	}
+	if delivery.NextAttemptAt != nil {
+		nextAttemptAt := delivery.NextAttemptAt.UTC()
+		delivery.NextAttemptAt = &nextAttemptAt
+	}
+	return delivery
+}
+
+func (m *MemoryStore) CreateSchedule(schedule models.PollSchedule, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	schedule = copySchedule(schedule)
+	schedule.ID = len(m.schedules) + 1
+	schedule.UserID = userID
+	schedule.RunCount = 0
+	schedule.NextRunAt = schedule.NextRunAt.UTC()
+	schedule.LastRunAt = nil
+	schedule.LastError = ""
+	schedule.CreatedAt = m.now().UTC()
+	m.schedules = append(m.schedules, schedule)
+
+	return schedule.ID, nil
+}
+
+func (m *MemoryStore) FetchSchedules(userID int) ([]models.PollSchedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var schedules []models.PollSchedule
+	for _, schedule := range m.schedules {
+		if schedule.ID != 0 && schedule.UserID == userID {
+			schedules = append(schedules, copySchedule(schedule))
+		}
+	}
+	return schedules, nil
+}
+
+func (m *MemoryStore) FetchDueSchedules(now time.Time, limit int) ([]models.PollSchedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var schedules []models.PollSchedule
+	for _, schedule := range m.schedules {
+		if schedule.ID != 0 && schedule.Enabled && !schedule.NextRunAt.After(now) {
+			schedules = append(schedules, copySchedule(schedule))
+		}
+	}
+
+	sort.SliceStable(schedules, func(i, j int) bool {
+		return schedules[i].NextRunAt.Before(schedules[j].NextRunAt)
+	})
+	if len(schedules) > limit {
+		schedules = schedules[:limit]
+	}
+	return schedules, nil
+}
+
+func (m *MemoryStore) GetScheduleByID(id int, userID int) (*models.PollSchedule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, schedule := range m.schedules {
+		if schedule.ID == id && id != 0 && schedule.UserID == userID {
+			schedule = copySchedule(schedule)
+			return &schedule, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) UpdateSchedule(schedule *models.PollSchedule, userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, existing := range m.schedules {
+		if existing.ID == schedule.ID && schedule.ID != 0 && existing.UserID == userID {
+			updated := copySchedule(*schedule)
+			updated.UserID = existing.UserID
+			updated.RunCount = existing.RunCount
+			updated.NextRunAt = schedule.NextRunAt.UTC()
+			updated.LastRunAt = existing.LastRunAt
+			updated.LastError = existing.LastError
+			updated.CreatedAt = existing.CreatedAt
+			m.schedules[i] = updated
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *MemoryStore) DeleteSchedule(id int, userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, schedule := range m.schedules {
+		if schedule.ID == id && id != 0 && schedule.UserID == userID {
+			m.schedules[i] = models.PollSchedule{}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) ClaimSchedule(id int, count int, now time.Time, next time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.schedules {
+		if m.schedules[i].ID == id && id != 0 && m.schedules[i].RunCount == count {
+			lastRunAt := now.UTC()
+			m.schedules[i].RunCount++
+			m.schedules[i].LastRunAt = &lastRunAt
+			m.schedules[i].NextRunAt = next.UTC()
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStore) SetScheduleError(id int, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.schedules {
+		if m.schedules[i].ID == id && id != 0 {
+			m.schedules[i].LastError = lastError
+		}
+	}
+	return nil
+}
+
+// copySchedule returns schedule with its pointers copied, so that the caller
+// and the store do not share them.
+func copySchedule(schedule models.PollSchedule) models.PollSchedule {
+	schedule.Location = copyLocation(schedule.Location)
+	if schedule.LastRunAt != nil {
+		lastRunAt := *schedule.LastRunAt
+		schedule.LastRunAt = &lastRunAt
+	}
+	return schedule
+}
+
+func (m *MemoryStore) CreateSession(session models.Session) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.sessions {
+		if existing.TokenHash == session.TokenHash {
+			return 0, errors.New("duplicate session token")
+		}
+	}
+
+	session.ID = len(m.sessions) + 1
+	session.CreatedAt = m.now().UTC()
+	m.sessions = append(m.sessions, session)
+
+	return session.ID, nil
+}
+
+func (m *MemoryStore) GetSessionByTokenHash(tokenHash string) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, session := range m.sessions {
+		if session.TokenHash == tokenHash {
+			return &session, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) GetSessionByID(id int, userID int) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, session := range m.sessions {
+		if session.ID == id && session.UserID == userID {
+			return &session, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *MemoryStore) RotateSession(id int, oldTokenHash, newTokenHash string, lastUsedAt, expiresAt time.Time, accessTokenID string, accessExpiresAt time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, session := range m.sessions {
+		if session.ID == id && session.TokenHash == oldTokenHash && session.RevokedAt == nil {
+			m.sessions[i].TokenHash = newTokenHash
+			m.sessions[i].LastUsedAt = lastUsedAt
+			m.sessions[i].ExpiresAt = expiresAt
+			m.sessions[i].AccessTokenID = accessTokenID
+			m.sessions[i].AccessExpiresAt = accessExpiresAt
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) FetchActiveSessions(userID int, now time.Time) ([]models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sessions []models.Session
+	for _, session := range m.sessions {
+		if session.UserID == userID && session.RevokedAt == nil && session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+func (m *MemoryStore) RevokeSession(id int, userID int, now time.Time) (int, error) {
+	return m.revokeSession(func(session models.Session) bool {
+		return session.ID == id && session.UserID == userID
+	}, now)
+}
+
+func (m *MemoryStore) RevokeSessionByTokenHash(tokenHash string, userID int, now time.Time) (int, error) {
+	return m.revokeSession(func(session models.Session) bool {
+		return session.TokenHash == tokenHash && session.UserID == userID
+	}, now)
+}
+
+func (m *MemoryStore) revokeSession(match func(models.Session) bool, now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, session := range m.sessions {
+		if match(session) && session.RevokedAt == nil {
+			revokedAt := now.UTC()
+			m.sessions[i].RevokedAt = &revokedAt
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (m *MemoryStore) RecordLoginAttempt(attempt models.LoginAttempt) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt.ID = len(m.logins) + 1
+	attempt.CreatedAt = attempt.CreatedAt.UTC()
+	m.logins = append(m.logins, attempt)
+
+	return attempt.ID, nil
+}
+
+func (m *MemoryStore) UpdateLoginAttempt(id int, event string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > len(m.logins) || m.logins[id-1].ID == 0 {
+		return sql.ErrNoRows
+	}
+	m.logins[id-1].Event = event
+	return nil
+}
+
+func (m *MemoryStore) FetchLoginAttempts(username string, ip string, since time.Time) ([]models.LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var attempts []models.LoginAttempt
+	for _, attempt := range m.logins {
+		if attempt.ID != 0 && (attempt.Username == username || attempt.IP == ip) && !attempt.CreatedAt.Before(since) {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+// PurgeLoginAttempts empties the slots of the purged attempts, which keep
+// their place so that attempts can still be found by id.
+func (m *MemoryStore) PurgeLoginAttempts(before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for i, attempt := range m.logins {
+		if attempt.ID != 0 && attempt.CreatedAt.Before(before) {
+			m.logins[i] = models.LoginAttempt{}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *MemoryStore) RevokeToken(jti string, userID int, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revoked[jti] = expiresAt
+	return nil
+}
+
+func (m *MemoryStore) FetchRevokedTokens(now time.Time) (map[string]time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	revoked := make(map[string]time.Time)
+	for jti, expiresAt := range m.revoked {
+		if expiresAt.After(now) {
+			revoked[jti] = expiresAt
+		}
+	}
+	return revoked, nil
+}
+
+func (m *MemoryStore) PurgeExpiredRevokedTokens(now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	purged := 0
+	for jti, expiresAt := range m.revoked {
+		if !expiresAt.After(now) {
+			delete(m.revoked, jti)
+			purged++
+		}
+	}
+	return purged, nil
+}
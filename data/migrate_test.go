@@ -0,0 +1,152 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrationsExistForEveryDialect(t *testing.T) {
+	mysql, err := Migrations(&DB{Dialect: MySQL})
+	require.NoError(t, err)
+	require.NotEmpty(t, mysql)
+
+	// Every dialect must reach the same schema version through the same steps
+	for _, dialect := range []Dialect{SQLite, Postgres} {
+		migrations, err := Migrations(&DB{Dialect: dialect})
+		require.NoError(t, err)
+		require.Len(t, migrations, len(mysql), dialect)
+
+		for i, migration := range migrations {
+			assert.Equal(t, mysql[i].Version, migration.Version, dialect)
+			assert.Equal(t, mysql[i].Name, migration.Name, dialect)
+			assert.NotEmpty(t, migration.Down, dialect)
+		}
+	}
+}
+
+func TestMigrateUpAndDown(t *testing.T) {
+	db, err := InitSQLite(filepath.Join(t.TempDir(), "weather.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrations, err := Migrations(db)
+	require.NoError(t, err)
+
+	statuses, err := FetchMigrationStatus(db)
+	require.NoError(t, err)
+	require.Len(t, statuses, len(migrations))
+	assert.Nil(t, statuses[0].AppliedAt)
+
+	applied, err := MigrateUp(db)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrations))
+
+	// Running again is a no-op
+	applied, err = MigrateUp(db)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	statuses, err = FetchMigrationStatus(db)
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.NotNil(t, status.AppliedAt, status.Name)
+	}
+
+	store := NewSQLStore(db)
+	_, err = store.CreateUser("user@example.com", "hash", testBirthDate)
+	require.NoError(t, err)
+
+	for range migrations {
+		_, err := MigrateDown(db)
+		require.NoError(t, err)
+	}
+	_, err = MigrateDown(db)
+	assert.ErrorIs(t, err, ErrNoMigration)
+
+	_, err = store.GetUserByUsername("user@example.com")
+	assert.Error(t, err)
+
+	applied, err = MigrateUp(db)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrations))
+}
+
+func TestConditionsMigrationKeepsExistingConditions(t *testing.T) {
+	db, err := InitSQLite(filepath.Join(t.TempDir(), "weather.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrations, err := Migrations(db)
+	require.NoError(t, err)
+	require.Equal(t, "weather_history_conditions", migrations[1].Name)
+
+	// A lookup recorded with the initial schema, which had a single condition
+	_, err = appliedMigrations(db)
+	require.NoError(t, err)
+	err = runMigration(db, migrations[0].Up, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migrations[0].Version, migrations[0].Name)
+	require.NoError(t, err)
+	userID, err := CreateUser(db, "user@example.com", "hash", testBirthDate)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO weather_history (city_name, user_id, coord_lon, coord_lat, weather_id, weather_main, weather_description, weather_icon, base, temp, feels_like, temp_min, temp_max, pressure, humidity, visibility, wind_speed, wind_deg, clouds_all, dt, sys_type, sys_id, sys_country, sys_sunrise, sys_sunset, timezone)
+		VALUES ('Pune', ?, 0, 0, 500, 'Rain', 'light rain', '10d', '', 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 'IN', 0, 0, 0)`, userID)
+	require.NoError(t, err)
+
+	_, err = MigrateUp(db)
+	require.NoError(t, err)
+
+	history, err := FetchWeatherHistory(db, userID)
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, []models.Weather{{ID: 500, Main: "Rain", Description: "light rain", Icon: "10d"}}, history[0].Weathers)
+}
+
+func TestHistoryIndexesMigration(t *testing.T) {
+	db, err := InitSQLite(filepath.Join(t.TempDir(), "weather.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	historyIndexes := func() int {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'index' AND name LIKE 'idx_weather_history_user_%'").Scan(&count)
+		require.NoError(t, err)
+		return count
+	}
+
+	_, err = MigrateUp(db)
+	require.NoError(t, err)
+	assert.Equal(t, 4, historyIndexes())
+
+	// Rolling back the migration that added them drops them again
+	for {
+		migration, err := MigrateDown(db)
+		require.NoError(t, err)
+		if migration.Name == "weather_history_indexes" {
+			break
+		}
+	}
+	assert.Equal(t, 0, historyIndexes())
+
+	_, err = MigrateUp(db)
+	require.NoError(t, err)
+	assert.Equal(t, 4, historyIndexes())
+}
+
+func TestSplitStatements(t *testing.T) {
+	script := `-- comment
+CREATE TABLE a (
+  id INTEGER
+);
+
+CREATE INDEX idx_a ON a (id);
+DROP TABLE b`
+
+	assert.Equal(t, []string{
+		"CREATE TABLE a (\n  id INTEGER\n);",
+		"CREATE INDEX idx_a ON a (id);",
+		"DROP TABLE b",
+	}, splitStatements(script))
+}
@@ -0,0 +1,87 @@
+-- Baseline schema. Tables are only created when missing, so databases created
+-- before migrations were introduced are adopted as they are.
+
+CREATE TABLE IF NOT EXISTS users (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    username VARCHAR(255) UNIQUE NOT NULL,
+    password VARCHAR(255) NOT NULL,
+    date_of_birth DATE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS weather_history (
+  id INT NOT NULL AUTO_INCREMENT,
+  city_name VARCHAR(255) NOT NULL,
+  user_id INT NOT NULL,
+  coord_lon FLOAT NOT NULL,
+  coord_lat FLOAT NOT NULL,
+  weather_id INT NOT NULL,
+  weather_main VARCHAR(255) NOT NULL,
+  weather_description VARCHAR(255) NOT NULL,
+  weather_icon VARCHAR(255) NOT NULL,
+  base VARCHAR(255) NOT NULL,
+  temp FLOAT NOT NULL,
+  feels_like FLOAT NOT NULL,
+  temp_min FLOAT NOT NULL,
+  temp_max FLOAT NOT NULL,
+  pressure INT NOT NULL,
+  humidity INT NOT NULL,
+  visibility INT NOT NULL,
+  wind_speed FLOAT NOT NULL,
+  wind_deg INT NOT NULL,
+  clouds_all INT NOT NULL,
+  dt INT NOT NULL,
+  sys_type INT NOT NULL,
+  sys_id INT NOT NULL,
+  sys_country VARCHAR(255) NOT NULL,
+  sys_sunrise INT NOT NULL,
+  sys_sunset INT NOT NULL,
+  timezone INT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (id),
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS weather_cache (
+  cache_key VARCHAR(255) NOT NULL,
+  payload MEDIUMTEXT NOT NULL,
+  expires_at DATETIME NOT NULL,
+  PRIMARY KEY (cache_key),
+  INDEX idx_weather_cache_expires_at (expires_at)
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS forecast_history (
+  id INT NOT NULL AUTO_INCREMENT,
+  user_id INT NOT NULL,
+  city_name VARCHAR(255) NOT NULL,
+  sys_country VARCHAR(255) NOT NULL,
+  payload MEDIUMTEXT NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (id),
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS sessions (
+  id INT NOT NULL AUTO_INCREMENT,
+  user_id INT NOT NULL,
+  token_hash CHAR(64) NOT NULL,
+  device VARCHAR(255) NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  last_used_at DATETIME NOT NULL,
+  expires_at DATETIME NOT NULL,
+  revoked_at DATETIME NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY uq_sessions_token_hash (token_hash),
+  INDEX idx_sessions_user_id (user_id),
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB;
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti VARCHAR(64) NOT NULL,
+  user_id INT NOT NULL,
+  expires_at DATETIME NOT NULL,
+  revoked_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (jti),
+  INDEX idx_revoked_tokens_expires_at (expires_at)
+) ENGINE=InnoDB;
@@ -0,0 +1,11 @@
+ALTER TABLE weather_history
+  ADD COLUMN weather_id INT NOT NULL DEFAULT 0 AFTER coord_lat,
+  ADD COLUMN weather_main VARCHAR(255) NOT NULL DEFAULT '' AFTER weather_id,
+  ADD COLUMN weather_description VARCHAR(255) NOT NULL DEFAULT '' AFTER weather_main,
+  ADD COLUMN weather_icon VARCHAR(255) NOT NULL DEFAULT '' AFTER weather_description;
+
+UPDATE weather_history h
+JOIN weather_history_conditions c ON c.weather_history_id = h.id AND c.position = 0
+SET h.weather_id = c.condition_id, h.weather_main = c.main, h.weather_description = c.description, h.weather_icon = c.icon;
+
+DROP TABLE weather_history_conditions;
@@ -0,0 +1,25 @@
+-- Keep every condition of a lookup instead of only the first, in a child
+-- table, and move the existing conditions there.
+
+CREATE TABLE weather_history_conditions (
+  id INT NOT NULL AUTO_INCREMENT,
+  weather_history_id INT NOT NULL,
+  position INT NOT NULL,
+  condition_id INT NOT NULL,
+  main VARCHAR(255) NOT NULL,
+  description VARCHAR(255) NOT NULL,
+  icon VARCHAR(255) NOT NULL,
+  PRIMARY KEY (id),
+  UNIQUE KEY uq_weather_history_conditions_position (weather_history_id, position),
+  INDEX idx_weather_history_conditions_main (main),
+  FOREIGN KEY (weather_history_id) REFERENCES weather_history (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB;
+
+INSERT INTO weather_history_conditions (weather_history_id, position, condition_id, main, description, icon)
+SELECT id, 0, weather_id, weather_main, weather_description, weather_icon FROM weather_history;
+
+ALTER TABLE weather_history
+  DROP COLUMN weather_id,
+  DROP COLUMN weather_main,
+  DROP COLUMN weather_description,
+  DROP COLUMN weather_icon;
@@ -0,0 +1,4 @@
+-- The unit system a user's responses are converted to when a request does
+-- not ask for one. Lookups themselves are always stored in standard units.
+
+ALTER TABLE users ADD COLUMN units VARCHAR(16) NOT NULL DEFAULT 'standard';
@@ -0,0 +1,19 @@
+-- Locations users saved under their own labels, in the order they arranged
+-- them. Exactly one of city, lat/lon, city_id or zip is set.
+
+CREATE TABLE favorite_locations (
+  id INT NOT NULL AUTO_INCREMENT,
+  user_id INT NOT NULL,
+  label VARCHAR(100) NOT NULL,
+  position INT NOT NULL DEFAULT 0,
+  city VARCHAR(100) NOT NULL DEFAULT '',
+  lat DOUBLE NULL,
+  lon DOUBLE NULL,
+  city_id INT NOT NULL DEFAULT 0,
+  zip VARCHAR(16) NOT NULL DEFAULT '',
+  country VARCHAR(2) NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (id),
+  INDEX idx_favorite_locations_user (user_id, position),
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB;
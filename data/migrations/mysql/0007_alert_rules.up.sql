@@ -0,0 +1,32 @@
+-- Rules notifying their owner when the weather at a location crosses a
+-- temperature threshold or reaches a condition. active and notification_count
+-- track the last evaluation, so a rule notifies once per episode and
+-- instances evaluating the same rule do not notify twice.
+
+CREATE TABLE alert_rules (
+  id INT NOT NULL AUTO_INCREMENT,
+  user_id INT NOT NULL,
+  name VARCHAR(100) NOT NULL,
+  city VARCHAR(100) NOT NULL DEFAULT '',
+  lat DOUBLE NULL,
+  lon DOUBLE NULL,
+  city_id INT NOT NULL DEFAULT 0,
+  zip VARCHAR(16) NOT NULL DEFAULT '',
+  country VARCHAR(2) NOT NULL DEFAULT '',
+  temp_below DOUBLE NULL,
+  temp_above DOUBLE NULL,
+  weather_condition VARCHAR(64) NOT NULL DEFAULT '',
+  units VARCHAR(16) NOT NULL DEFAULT 'standard',
+  channel VARCHAR(16) NOT NULL,
+  target VARCHAR(255) NOT NULL,
+  cooldown_minutes INT NOT NULL DEFAULT 60,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  active BOOLEAN NOT NULL DEFAULT FALSE,
+  notification_count INT NOT NULL DEFAULT 0,
+  last_notified_at DATETIME NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (id),
+  INDEX idx_alert_rules_user (user_id),
+  INDEX idx_alert_rules_enabled (enabled),
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB;
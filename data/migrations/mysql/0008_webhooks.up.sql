@@ -0,0 +1,32 @@
+-- Webhooks users registered to be told about events on their account, and
+-- a log of every delivery made to them, kept for inspection and redelivery.
+
+CREATE TABLE webhooks (
+  id INT NOT NULL AUTO_INCREMENT,
+  user_id INT NOT NULL,
+  url VARCHAR(255) NOT NULL,
+  secret VARCHAR(64) NOT NULL,
+  events VARCHAR(255) NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (id),
+  INDEX idx_webhooks_user (user_id),
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB;
+
+CREATE TABLE webhook_deliveries (
+  id INT NOT NULL AUTO_INCREMENT,
+  webhook_id INT NOT NULL,
+  user_id INT NOT NULL,
+  event VARCHAR(32) NOT NULL,
+  payload MEDIUMTEXT NOT NULL,
+  status VARCHAR(16) NOT NULL DEFAULT 'pending',
+  attempts INT NOT NULL DEFAULT 0,
+  response_status INT NOT NULL DEFAULT 0,
+  error VARCHAR(255) NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  delivered_at DATETIME NULL,
+  PRIMARY KEY (id),
+  INDEX idx_webhook_deliveries_webhook (webhook_id),
+  FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB;
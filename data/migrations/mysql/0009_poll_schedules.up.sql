@@ -0,0 +1,26 @@
+-- Locations polled in the background, recording a snapshot in the history of
+-- the owning user every interval_minutes. next_run_at survives restarts, and
+-- run_count changes with every run so that of several instances only one
+-- runs a schedule when it is due.
+
+CREATE TABLE poll_schedules (
+  id INT NOT NULL AUTO_INCREMENT,
+  user_id INT NOT NULL,
+  city VARCHAR(100) NOT NULL DEFAULT '',
+  lat DOUBLE NULL,
+  lon DOUBLE NULL,
+  city_id INT NOT NULL DEFAULT 0,
+  zip VARCHAR(16) NOT NULL DEFAULT '',
+  country VARCHAR(2) NOT NULL DEFAULT '',
+  interval_minutes INT NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  run_count INT NOT NULL DEFAULT 0,
+  next_run_at DATETIME NOT NULL,
+  last_run_at DATETIME NULL,
+  last_error VARCHAR(255) NOT NULL DEFAULT '',
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (id),
+  INDEX idx_poll_schedules_user (user_id),
+  INDEX idx_poll_schedules_next_run (enabled, next_run_at),
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB;
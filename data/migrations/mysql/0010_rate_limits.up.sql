@@ -0,0 +1,13 @@
+-- Token buckets of the rate limiter shared by API instances, keyed by route
+-- and client. updated_ms is in Unix milliseconds, finer than the timestamp
+-- columns of every dialect, and version changes with every update so that
+-- concurrent requests cannot both take the last token.
+
+CREATE TABLE rate_limits (
+  bucket_key VARCHAR(255) NOT NULL,
+  tokens DOUBLE NOT NULL,
+  updated_ms BIGINT NOT NULL,
+  version INT NOT NULL DEFAULT 1,
+  PRIMARY KEY (bucket_key),
+  INDEX idx_rate_limits_updated (updated_ms)
+) ENGINE=InnoDB;
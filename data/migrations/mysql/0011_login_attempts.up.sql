@@ -0,0 +1,14 @@
+-- Login history used to throttle failed logins: successes and failures by
+-- username and client IP, lockouts and unlocks by admins. created_at is set
+-- by the API so that all instances count attempts on the same clock.
+
+CREATE TABLE login_attempts (
+  id INT NOT NULL AUTO_INCREMENT,
+  username VARCHAR(255) NOT NULL,
+  ip VARCHAR(45) NOT NULL DEFAULT '',
+  event VARCHAR(16) NOT NULL,
+  created_at DATETIME NOT NULL,
+  PRIMARY KEY (id),
+  INDEX idx_login_attempts_username (username, created_at),
+  INDEX idx_login_attempts_ip (ip, created_at)
+) ENGINE=InnoDB;
@@ -0,0 +1,6 @@
+-- idx_weather_history_user_id is kept, as the foreign key on user_id needs an
+-- index and the baseline rolls it back with weather_history.
+
+DROP INDEX idx_weather_history_user_created_at ON weather_history;
+DROP INDEX idx_weather_history_user_city ON weather_history;
+DROP INDEX idx_weather_history_user_temp ON weather_history;
@@ -0,0 +1,36 @@
+-- Indexes for paging and filtering the history of a user. They are created
+-- apart from the table, since the baseline adopts existing tables as they
+-- are. MySQL has no CREATE INDEX IF NOT EXISTS, so each index is only
+-- created when information_schema does not list it yet.
+
+SET @stmt = IF(
+  (SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = 'weather_history' AND index_name = 'idx_weather_history_user_id') = 0,
+  'CREATE INDEX idx_weather_history_user_id ON weather_history (user_id, id)',
+  'DO 0');
+PREPARE create_index FROM @stmt;
+EXECUTE create_index;
+DEALLOCATE PREPARE create_index;
+
+SET @stmt = IF(
+  (SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = 'weather_history' AND index_name = 'idx_weather_history_user_created_at') = 0,
+  'CREATE INDEX idx_weather_history_user_created_at ON weather_history (user_id, created_at)',
+  'DO 0');
+PREPARE create_index FROM @stmt;
+EXECUTE create_index;
+DEALLOCATE PREPARE create_index;
+
+SET @stmt = IF(
+  (SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = 'weather_history' AND index_name = 'idx_weather_history_user_city') = 0,
+  'CREATE INDEX idx_weather_history_user_city ON weather_history (user_id, city_name)',
+  'DO 0');
+PREPARE create_index FROM @stmt;
+EXECUTE create_index;
+DEALLOCATE PREPARE create_index;
+
+SET @stmt = IF(
+  (SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = DATABASE() AND table_name = 'weather_history' AND index_name = 'idx_weather_history_user_temp') = 0,
+  'CREATE INDEX idx_weather_history_user_temp ON weather_history (user_id, temp)',
+  'DO 0');
+PREPARE create_index FROM @stmt;
+EXECUTE create_index;
+DEALLOCATE PREPARE create_index;
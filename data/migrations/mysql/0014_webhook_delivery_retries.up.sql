@@ -0,0 +1,7 @@
+-- When a pending webhook delivery is next attempted. Retries are driven from
+-- the database, so they survive restarts, and deliveries left pending by
+-- earlier versions are attempted right away.
+
+ALTER TABLE webhook_deliveries ADD COLUMN next_attempt_at DATETIME NULL;
+UPDATE webhook_deliveries SET next_attempt_at = UTC_TIMESTAMP() WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_next_attempt ON webhook_deliveries (status, next_attempt_at);
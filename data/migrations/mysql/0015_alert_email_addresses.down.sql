@@ -0,0 +1 @@
+DROP TABLE alert_email_addresses;
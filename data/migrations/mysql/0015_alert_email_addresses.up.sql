@@ -0,0 +1,19 @@
+-- Email addresses users want alert notifications sent to. Nothing but the
+-- confirmation is mailed to an address until it was confirmed with the token
+-- sent there, whose hash is kept, so that alerts cannot be used to mail
+-- people who did not ask for them. Rules emailing addresses that were never
+-- confirmed stay silent until they are saved again and confirmed.
+
+CREATE TABLE alert_email_addresses (
+  id INT NOT NULL AUTO_INCREMENT,
+  user_id INT NOT NULL,
+  address VARCHAR(255) NOT NULL,
+  token_hash VARCHAR(64) NOT NULL,
+  sent_at DATETIME NOT NULL,
+  confirmed_at DATETIME NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (id),
+  UNIQUE KEY uq_alert_email_addresses_user_address (user_id, address),
+  UNIQUE KEY uq_alert_email_addresses_token (token_hash),
+  FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB;
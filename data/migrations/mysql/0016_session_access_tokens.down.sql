@@ -0,0 +1,2 @@
+ALTER TABLE sessions DROP COLUMN access_expires_at;
+ALTER TABLE sessions DROP COLUMN access_token_id;
@@ -0,0 +1,80 @@
+-- Baseline schema. Tables are only created when missing, so databases created
+-- before migrations were introduced are adopted as they are.
+
+CREATE EXTENSION IF NOT EXISTS citext;
+
+CREATE TABLE IF NOT EXISTS users (
+  id SERIAL PRIMARY KEY,
+  username CITEXT NOT NULL UNIQUE,
+  password VARCHAR(255) NOT NULL,
+  date_of_birth DATE NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS weather_history (
+  id SERIAL PRIMARY KEY,
+  city_name CITEXT NOT NULL,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  coord_lon DOUBLE PRECISION NOT NULL,
+  coord_lat DOUBLE PRECISION NOT NULL,
+  weather_id INTEGER NOT NULL,
+  weather_main CITEXT NOT NULL,
+  weather_description VARCHAR(255) NOT NULL,
+  weather_icon VARCHAR(255) NOT NULL,
+  base VARCHAR(255) NOT NULL,
+  temp DOUBLE PRECISION NOT NULL,
+  feels_like DOUBLE PRECISION NOT NULL,
+  temp_min DOUBLE PRECISION NOT NULL,
+  temp_max DOUBLE PRECISION NOT NULL,
+  pressure INTEGER NOT NULL,
+  humidity INTEGER NOT NULL,
+  visibility INTEGER NOT NULL,
+  wind_speed DOUBLE PRECISION NOT NULL,
+  wind_deg INTEGER NOT NULL,
+  clouds_all INTEGER NOT NULL,
+  dt INTEGER NOT NULL,
+  sys_type INTEGER NOT NULL,
+  sys_id INTEGER NOT NULL,
+  sys_country CITEXT NOT NULL,
+  sys_sunrise INTEGER NOT NULL,
+  sys_sunset INTEGER NOT NULL,
+  timezone INTEGER NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS weather_cache (
+  cache_key VARCHAR(255) NOT NULL PRIMARY KEY,
+  payload BYTEA NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_weather_cache_expires_at ON weather_cache (expires_at);
+
+CREATE TABLE IF NOT EXISTS forecast_history (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  city_name VARCHAR(255) NOT NULL,
+  sys_country VARCHAR(255) NOT NULL,
+  payload BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  device VARCHAR(255) NOT NULL,
+  ip VARCHAR(45) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_used_at TIMESTAMPTZ NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  revoked_at TIMESTAMPTZ NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti VARCHAR(64) NOT NULL PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  expires_at TIMESTAMPTZ NOT NULL,
+  revoked_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
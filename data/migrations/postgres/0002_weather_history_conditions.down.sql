@@ -0,0 +1,12 @@
+ALTER TABLE weather_history
+  ADD COLUMN weather_id INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN weather_main CITEXT NOT NULL DEFAULT '',
+  ADD COLUMN weather_description VARCHAR(255) NOT NULL DEFAULT '',
+  ADD COLUMN weather_icon VARCHAR(255) NOT NULL DEFAULT '';
+
+UPDATE weather_history
+SET weather_id = c.condition_id, weather_main = c.main, weather_description = c.description, weather_icon = c.icon
+FROM weather_history_conditions c
+WHERE c.weather_history_id = weather_history.id AND c.position = 0;
+
+DROP TABLE weather_history_conditions;
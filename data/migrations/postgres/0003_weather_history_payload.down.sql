@@ -0,0 +1,4 @@
+ALTER TABLE weather_history
+  DROP COLUMN provider,
+  DROP COLUMN provider_response_ms,
+  DROP COLUMN raw_payload;
@@ -0,0 +1,10 @@
+-- Keep the upstream response as received, and which provider sent it, so
+-- fields that are not flattened into columns can be recovered later.
+
+ALTER TABLE weather_history
+  ADD COLUMN provider VARCHAR(64) NOT NULL DEFAULT '',
+  ADD COLUMN provider_response_ms INTEGER NOT NULL DEFAULT 0,
+  ADD COLUMN raw_payload JSON NULL;
+
+-- Every lookup recorded so far came from OpenWeatherMap
+UPDATE weather_history SET provider = 'openweathermap';
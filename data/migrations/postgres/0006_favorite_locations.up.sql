@@ -0,0 +1,17 @@
+-- Locations users saved under their own labels, in the order they arranged
+-- them. Exactly one of city, lat/lon, city_id or zip is set.
+
+CREATE TABLE favorite_locations (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  label VARCHAR(100) NOT NULL,
+  position INTEGER NOT NULL DEFAULT 0,
+  city VARCHAR(100) NOT NULL DEFAULT '',
+  lat DOUBLE PRECISION NULL,
+  lon DOUBLE PRECISION NULL,
+  city_id INTEGER NOT NULL DEFAULT 0,
+  zip VARCHAR(16) NOT NULL DEFAULT '',
+  country VARCHAR(2) NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_favorite_locations_user ON favorite_locations (user_id, position);
@@ -0,0 +1,30 @@
+-- Rules notifying their owner when the weather at a location crosses a
+-- temperature threshold or reaches a condition. active and notification_count
+-- track the last evaluation, so a rule notifies once per episode and
+-- instances evaluating the same rule do not notify twice.
+
+CREATE TABLE alert_rules (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  name VARCHAR(100) NOT NULL,
+  city VARCHAR(100) NOT NULL DEFAULT '',
+  lat DOUBLE PRECISION NULL,
+  lon DOUBLE PRECISION NULL,
+  city_id INTEGER NOT NULL DEFAULT 0,
+  zip VARCHAR(16) NOT NULL DEFAULT '',
+  country VARCHAR(2) NOT NULL DEFAULT '',
+  temp_below DOUBLE PRECISION NULL,
+  temp_above DOUBLE PRECISION NULL,
+  weather_condition CITEXT NOT NULL DEFAULT '',
+  units VARCHAR(16) NOT NULL DEFAULT 'standard',
+  channel VARCHAR(16) NOT NULL,
+  target VARCHAR(255) NOT NULL,
+  cooldown_minutes INTEGER NOT NULL DEFAULT 60,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  active BOOLEAN NOT NULL DEFAULT FALSE,
+  notification_count INTEGER NOT NULL DEFAULT 0,
+  last_notified_at TIMESTAMPTZ NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_alert_rules_user ON alert_rules (user_id);
+CREATE INDEX idx_alert_rules_enabled ON alert_rules (enabled);
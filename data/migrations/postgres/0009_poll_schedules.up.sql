@@ -0,0 +1,24 @@
+-- Locations polled in the background, recording a snapshot in the history of
+-- the owning user every interval_minutes. next_run_at survives restarts, and
+-- run_count changes with every run so that of several instances only one
+-- runs a schedule when it is due.
+
+CREATE TABLE poll_schedules (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  city VARCHAR(100) NOT NULL DEFAULT '',
+  lat DOUBLE PRECISION NULL,
+  lon DOUBLE PRECISION NULL,
+  city_id INTEGER NOT NULL DEFAULT 0,
+  zip VARCHAR(16) NOT NULL DEFAULT '',
+  country VARCHAR(2) NOT NULL DEFAULT '',
+  interval_minutes INTEGER NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT TRUE,
+  run_count INTEGER NOT NULL DEFAULT 0,
+  next_run_at TIMESTAMPTZ NOT NULL,
+  last_run_at TIMESTAMPTZ NULL,
+  last_error VARCHAR(255) NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_poll_schedules_user ON poll_schedules (user_id);
+CREATE INDEX idx_poll_schedules_next_run ON poll_schedules (enabled, next_run_at);
@@ -0,0 +1,12 @@
+-- Token buckets of the rate limiter shared by API instances, keyed by route
+-- and client. updated_ms is in Unix milliseconds, finer than the timestamp
+-- columns of every dialect, and version changes with every update so that
+-- concurrent requests cannot both take the last token.
+
+CREATE TABLE rate_limits (
+  bucket_key VARCHAR(255) NOT NULL PRIMARY KEY,
+  tokens DOUBLE PRECISION NOT NULL,
+  updated_ms BIGINT NOT NULL,
+  version INTEGER NOT NULL DEFAULT 1
+);
+CREATE INDEX idx_rate_limits_updated ON rate_limits (updated_ms);
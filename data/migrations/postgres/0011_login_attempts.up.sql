@@ -0,0 +1,13 @@
+-- Login history used to throttle failed logins: successes and failures by
+-- username and client IP, lockouts and unlocks by admins. created_at is set
+-- by the API so that all instances count attempts on the same clock.
+
+CREATE TABLE login_attempts (
+  id SERIAL PRIMARY KEY,
+  username CITEXT NOT NULL,
+  ip VARCHAR(45) NOT NULL DEFAULT '',
+  event VARCHAR(16) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_login_attempts_username ON login_attempts (username, created_at);
+CREATE INDEX idx_login_attempts_ip ON login_attempts (ip, created_at);
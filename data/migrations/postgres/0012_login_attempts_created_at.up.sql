@@ -0,0 +1,4 @@
+-- Lets the login history older than the throttling window be purged
+-- without scanning the whole table.
+
+CREATE INDEX idx_login_attempts_created ON login_attempts (created_at);
@@ -0,0 +1,16 @@
+-- Email addresses users want alert notifications sent to. Nothing but the
+-- confirmation is mailed to an address until it was confirmed with the token
+-- sent there, whose hash is kept, so that alerts cannot be used to mail
+-- people who did not ask for them. Rules emailing addresses that were never
+-- confirmed stay silent until they are saved again and confirmed.
+
+CREATE TABLE alert_email_addresses (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  address VARCHAR(255) NOT NULL,
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  sent_at TIMESTAMPTZ NOT NULL,
+  confirmed_at TIMESTAMPTZ NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE (user_id, address)
+);
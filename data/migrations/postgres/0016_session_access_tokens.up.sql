@@ -0,0 +1,5 @@
+-- The access token last issued for a session, so that revoking the session
+-- revokes the token too instead of leaving it valid until it expires.
+
+ALTER TABLE sessions ADD COLUMN access_token_id VARCHAR(64) NULL;
+ALTER TABLE sessions ADD COLUMN access_expires_at TIMESTAMPTZ NULL;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS revoked_tokens;
+DROP TABLE IF EXISTS sessions;
+DROP TABLE IF EXISTS forecast_history;
+DROP TABLE IF EXISTS weather_cache;
+DROP TABLE IF EXISTS weather_history;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,78 @@
+-- Baseline schema. Tables are only created when missing, so databases created
+-- before migrations were introduced are adopted as they are.
+
+CREATE TABLE IF NOT EXISTS users (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+  password TEXT NOT NULL,
+  date_of_birth TEXT NOT NULL,
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS weather_history (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  city_name TEXT NOT NULL COLLATE NOCASE,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  coord_lon REAL NOT NULL,
+  coord_lat REAL NOT NULL,
+  weather_id INTEGER NOT NULL,
+  weather_main TEXT NOT NULL COLLATE NOCASE,
+  weather_description TEXT NOT NULL,
+  weather_icon TEXT NOT NULL,
+  base TEXT NOT NULL,
+  temp REAL NOT NULL,
+  feels_like REAL NOT NULL,
+  temp_min REAL NOT NULL,
+  temp_max REAL NOT NULL,
+  pressure INTEGER NOT NULL,
+  humidity INTEGER NOT NULL,
+  visibility INTEGER NOT NULL,
+  wind_speed REAL NOT NULL,
+  wind_deg INTEGER NOT NULL,
+  clouds_all INTEGER NOT NULL,
+  dt INTEGER NOT NULL,
+  sys_type INTEGER NOT NULL,
+  sys_id INTEGER NOT NULL,
+  sys_country TEXT NOT NULL COLLATE NOCASE,
+  sys_sunrise INTEGER NOT NULL,
+  sys_sunset INTEGER NOT NULL,
+  timezone INTEGER NOT NULL,
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS weather_cache (
+  cache_key TEXT NOT NULL PRIMARY KEY,
+  payload BLOB NOT NULL,
+  expires_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_weather_cache_expires_at ON weather_cache (expires_at);
+
+CREATE TABLE IF NOT EXISTS forecast_history (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  city_name TEXT NOT NULL,
+  sys_country TEXT NOT NULL,
+  payload BLOB NOT NULL,
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  token_hash TEXT NOT NULL UNIQUE,
+  device TEXT NOT NULL,
+  ip TEXT NOT NULL,
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_used_at TEXT NOT NULL,
+  expires_at TEXT NOT NULL,
+  revoked_at TEXT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+  jti TEXT NOT NULL PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  expires_at TEXT NOT NULL,
+  revoked_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
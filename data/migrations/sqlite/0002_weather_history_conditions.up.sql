@@ -0,0 +1,22 @@
+-- Keep every condition of a lookup instead of only the first, in a child
+-- table, and move the existing conditions there.
+
+CREATE TABLE weather_history_conditions (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  weather_history_id INTEGER NOT NULL REFERENCES weather_history (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  position INTEGER NOT NULL,
+  condition_id INTEGER NOT NULL,
+  main TEXT NOT NULL COLLATE NOCASE,
+  description TEXT NOT NULL,
+  icon TEXT NOT NULL,
+  UNIQUE (weather_history_id, position)
+);
+CREATE INDEX idx_weather_history_conditions_main ON weather_history_conditions (main);
+
+INSERT INTO weather_history_conditions (weather_history_id, position, condition_id, main, description, icon)
+SELECT id, 0, weather_id, weather_main, weather_description, weather_icon FROM weather_history;
+
+ALTER TABLE weather_history DROP COLUMN weather_id;
+ALTER TABLE weather_history DROP COLUMN weather_main;
+ALTER TABLE weather_history DROP COLUMN weather_description;
+ALTER TABLE weather_history DROP COLUMN weather_icon;
@@ -0,0 +1,3 @@
+ALTER TABLE weather_history DROP COLUMN provider;
+ALTER TABLE weather_history DROP COLUMN provider_response_ms;
+ALTER TABLE weather_history DROP COLUMN raw_payload;
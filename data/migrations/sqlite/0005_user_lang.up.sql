@@ -0,0 +1,4 @@
+-- The language a user's lookups are fetched in when a request does not ask
+-- for one. Empty leaves the choice to the provider.
+
+ALTER TABLE users ADD COLUMN lang TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,17 @@
+-- Locations users saved under their own labels, in the order they arranged
+-- them. Exactly one of city, lat/lon, city_id or zip is set.
+
+CREATE TABLE favorite_locations (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  label TEXT NOT NULL,
+  position INTEGER NOT NULL DEFAULT 0,
+  city TEXT NOT NULL DEFAULT '',
+  lat REAL NULL,
+  lon REAL NULL,
+  city_id INTEGER NOT NULL DEFAULT 0,
+  zip TEXT NOT NULL DEFAULT '',
+  country TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_favorite_locations_user ON favorite_locations (user_id, position);
@@ -0,0 +1,30 @@
+-- Rules notifying their owner when the weather at a location crosses a
+-- temperature threshold or reaches a condition. active and notification_count
+-- track the last evaluation, so a rule notifies once per episode and
+-- instances evaluating the same rule do not notify twice.
+
+CREATE TABLE alert_rules (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  name TEXT NOT NULL,
+  city TEXT NOT NULL DEFAULT '',
+  lat REAL NULL,
+  lon REAL NULL,
+  city_id INTEGER NOT NULL DEFAULT 0,
+  zip TEXT NOT NULL DEFAULT '',
+  country TEXT NOT NULL DEFAULT '',
+  temp_below REAL NULL,
+  temp_above REAL NULL,
+  weather_condition TEXT NOT NULL DEFAULT '' COLLATE NOCASE,
+  units TEXT NOT NULL DEFAULT 'standard',
+  channel TEXT NOT NULL,
+  target TEXT NOT NULL,
+  cooldown_minutes INTEGER NOT NULL DEFAULT 60,
+  enabled BOOLEAN NOT NULL DEFAULT 1,
+  active BOOLEAN NOT NULL DEFAULT 0,
+  notification_count INTEGER NOT NULL DEFAULT 0,
+  last_notified_at TEXT NULL,
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_alert_rules_user ON alert_rules (user_id);
+CREATE INDEX idx_alert_rules_enabled ON alert_rules (enabled);
@@ -0,0 +1,24 @@
+-- Locations polled in the background, recording a snapshot in the history of
+-- the owning user every interval_minutes. next_run_at survives restarts, and
+-- run_count changes with every run so that of several instances only one
+-- runs a schedule when it is due.
+
+CREATE TABLE poll_schedules (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  city TEXT NOT NULL DEFAULT '',
+  lat REAL NULL,
+  lon REAL NULL,
+  city_id INTEGER NOT NULL DEFAULT 0,
+  zip TEXT NOT NULL DEFAULT '',
+  country TEXT NOT NULL DEFAULT '',
+  interval_minutes INTEGER NOT NULL,
+  enabled BOOLEAN NOT NULL DEFAULT 1,
+  run_count INTEGER NOT NULL DEFAULT 0,
+  next_run_at TEXT NOT NULL,
+  last_run_at TEXT NULL,
+  last_error TEXT NOT NULL DEFAULT '',
+  created_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idx_poll_schedules_user ON poll_schedules (user_id);
+CREATE INDEX idx_poll_schedules_next_run ON poll_schedules (enabled, next_run_at);
@@ -0,0 +1,13 @@
+-- Login history used to throttle failed logins: successes and failures by
+-- username and client IP, lockouts and unlocks by admins. created_at is set
+-- by the API so that all instances count attempts on the same clock.
+
+CREATE TABLE login_attempts (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  username TEXT NOT NULL COLLATE NOCASE,
+  ip TEXT NOT NULL DEFAULT '',
+  event TEXT NOT NULL,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX idx_login_attempts_username ON login_attempts (username, created_at);
+CREATE INDEX idx_login_attempts_ip ON login_attempts (ip, created_at);
@@ -0,0 +1 @@
+DROP INDEX idx_login_attempts_created;
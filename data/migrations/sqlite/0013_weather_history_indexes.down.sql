@@ -0,0 +1,4 @@
+DROP INDEX idx_weather_history_user_id;
+DROP INDEX idx_weather_history_user_created_at;
+DROP INDEX idx_weather_history_user_city;
+DROP INDEX idx_weather_history_user_temp;
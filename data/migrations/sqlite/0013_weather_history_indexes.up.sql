@@ -0,0 +1,8 @@
+-- Indexes for paging and filtering the history of a user. They are created
+-- apart from the table, since the baseline adopts existing tables as they
+-- are.
+
+CREATE INDEX idx_weather_history_user_id ON weather_history (user_id, id);
+CREATE INDEX idx_weather_history_user_created_at ON weather_history (user_id, created_at);
+CREATE INDEX idx_weather_history_user_city ON weather_history (user_id, city_name);
+CREATE INDEX idx_weather_history_user_temp ON weather_history (user_id, temp);
@@ -0,0 +1,2 @@
+DROP INDEX idx_webhook_deliveries_next_attempt;
+ALTER TABLE webhook_deliveries DROP COLUMN next_attempt_at;
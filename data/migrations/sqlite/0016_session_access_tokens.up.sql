@@ -0,0 +1,5 @@
+-- The access token last issued for a session, so that revoking the session
+-- revokes the token too instead of leaving it valid until it expires.
+
+ALTER TABLE sessions ADD COLUMN access_token_id TEXT NULL;
+ALTER TABLE sessions ADD COLUMN access_expires_at TEXT NULL;
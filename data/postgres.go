@@ -0,0 +1,54 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	"github.com/lib/pq"
+)
+
+// InitPostgres connects to the Postgres server, creating dbName if it does
+// not exist yet. Its tables are created by MigrateUp. sslMode is passed to the driver as the
+// sslmode connection parameter.
+func InitPostgres(host, port, user, password, dbName, sslMode string) (*DB, error) {
+	dsn := func(name string) string {
+		u := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(user, password),
+			Host:     host + ":" + port,
+			Path:     "/" + name,
+			RawQuery: url.Values{"sslmode": {sslMode}}.Encode(),
+		}
+		return u.String()
+	}
+
+	// Databases can only be created from a connection to another database
+	server, err := sql.Open("postgres", dsn("postgres"))
+	if err != nil {
+		return nil, err
+	}
+	defer server.Close()
+
+	var exists bool
+	err = server.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", dbName).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if _, err := server.Exec("CREATE DATABASE " + pq.QuoteIdentifier(dbName)); err != nil {
+			return nil, err
+		}
+	}
+
+	Db, err := sql.Open("postgres", dsn(dbName))
+	if err != nil {
+		return nil, err
+	}
+	if err = Db.Ping(); err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Connected to database")
+	return &DB{DB: Db, Dialect: Postgres}, nil
+}
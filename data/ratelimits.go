@@ -0,0 +1,69 @@
+package data
+
+// GetRateLimitBucket returns the tokens left in the rate limit bucket under
+// key, when they were counted in Unix milliseconds, and the version of the
+// bucket. It returns sql.ErrNoRows when there is no such bucket.
+func GetRateLimitBucket(db *DB, key string) (float64, int64, int, error) {
+	stmt := "SELECT tokens, updated_ms, version FROM rate_limits WHERE bucket_key = ?"
+
+	var tokens float64
+	var updatedMS int64
+	var version int
+	err := db.QueryRow(stmt, key).Scan(&tokens, &updatedMS, &version)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return tokens, updatedMS, version, nil
+}
+
+// InsertRateLimitBucket stores a new rate limit bucket. It reports whether the
+// bucket was stored, leaving alone one created under the same key in the
+// meantime, which the caller has to read and update instead.
+func InsertRateLimitBucket(db *DB, key string, tokens float64, updatedMS int64) (bool, error) {
+	stmt := "INSERT INTO rate_limits (bucket_key, tokens, updated_ms, version) VALUES (?, ?, ?, 1)" + db.ignoreConflict("bucket_key")
+
+	result, err := db.Exec(stmt, key, tokens, updatedMS)
+	if err != nil {
+		return false, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affectedRows == 1, nil
+}
+
+// UpdateRateLimitBucket stores the tokens of a rate limit bucket, provided its
+// version is still version. It reports whether the bucket was updated, so that
+// concurrent requests cannot both take the last token.
+func UpdateRateLimitBucket(db *DB, key string, tokens float64, updatedMS int64, version int) (bool, error) {
+	stmt := "UPDATE rate_limits SET tokens = ?, updated_ms = ?, version = version + 1 WHERE bucket_key = ? AND version = ?"
+
+	result, err := db.Exec(stmt, tokens, updatedMS, key, version)
+	if err != nil {
+		return false, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affectedRows == 1, nil
+}
+
+// PurgeRateLimitBuckets deletes the rate limit buckets last used before
+// beforeMS, in Unix milliseconds.
+func PurgeRateLimitBuckets(db *DB, beforeMS int64) (int, error) {
+	result, err := db.Exec("DELETE FROM rate_limits WHERE updated_ms < ?", beforeMS)
+	if err != nil {
+		return 0, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affectedRows), nil
+}
@@ -0,0 +1,185 @@
+package data
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+const scheduleColumns = "id, user_id, city, lat, lon, city_id, zip, country, interval_minutes, enabled, run_count, next_run_at, last_run_at, last_error, created_at"
+
+func CreateSchedule(db *DB, schedule models.PollSchedule, userID int) (int, error) {
+	stmt := "INSERT INTO poll_schedules (user_id, city, lat, lon, city_id, zip, country, interval_minutes, enabled, next_run_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+
+	return db.insert(stmt,
+		userID,
+		schedule.City,
+		schedule.Lat,
+		schedule.Lon,
+		schedule.CityID,
+		schedule.Zip,
+		schedule.Country,
+		schedule.IntervalMinutes,
+		schedule.Enabled,
+		schedule.NextRunAt.UTC(),
+	)
+}
+
+func FetchSchedules(db *DB, userID int) ([]models.PollSchedule, error) {
+	stmt := "SELECT " + scheduleColumns + " FROM poll_schedules WHERE user_id = ? ORDER BY id"
+
+	return querySchedules(db, stmt, userID)
+}
+
+// FetchDueSchedules returns up to limit enabled schedules of every user that
+// are due at now, the longest overdue first.
+func FetchDueSchedules(db *DB, now time.Time, limit int) ([]models.PollSchedule, error) {
+	stmt := "SELECT " + scheduleColumns + " FROM poll_schedules WHERE enabled = ? AND next_run_at <= ? ORDER BY next_run_at, id LIMIT ?"
+
+	return querySchedules(db, stmt, true, now.UTC(), limit)
+}
+
+func GetScheduleByID(db *DB, id int, userID int) (*models.PollSchedule, error) {
+	stmt := "SELECT " + scheduleColumns + " FROM poll_schedules WHERE id = ? AND user_id = ?"
+
+	return scanSchedule(db.QueryRow(stmt, id, userID))
+}
+
+// UpdateSchedule changes the location, interval, enabled state and next run of
+// a schedule of userID, returning sql.ErrNoRows when it has no schedule with
+// that id.
+func UpdateSchedule(db *DB, schedule *models.PollSchedule, userID int) error {
+	stmt := "UPDATE poll_schedules SET city = ?, lat = ?, lon = ?, city_id = ?, zip = ?, country = ?, interval_minutes = ?, enabled = ?, next_run_at = ? WHERE id = ? AND user_id = ?"
+
+	result, err := db.Exec(stmt,
+		schedule.City,
+		schedule.Lat,
+		schedule.Lon,
+		schedule.CityID,
+		schedule.Zip,
+		schedule.Country,
+		schedule.IntervalMinutes,
+		schedule.Enabled,
+		schedule.NextRunAt.UTC(),
+		schedule.ID,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// MySQL reports no affected rows when nothing changed, so tell an
+	// unchanged schedule apart from a missing one.
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affectedRows == 0 {
+		_, err := GetScheduleByID(db, schedule.ID, userID)
+		return err
+	}
+
+	return nil
+}
+
+func DeleteSchedule(db *DB, id int, userID int) (int, error) {
+	stmt := "DELETE FROM poll_schedules WHERE id = ? AND user_id = ?"
+
+	result, err := db.Exec(stmt, id, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affectedRows), nil
+}
+
+// ClaimSchedule records a run of a schedule at now and moves its next run to
+// next, provided its run count is still count. It reports whether the claim
+// succeeded, so that of several schedulers only one runs the schedule.
+func ClaimSchedule(db *DB, id int, count int, now time.Time, next time.Time) (bool, error) {
+	stmt := "UPDATE poll_schedules SET run_count = run_count + 1, last_run_at = ?, next_run_at = ? WHERE id = ? AND run_count = ?"
+
+	result, err := db.Exec(stmt, now.UTC(), next.UTC(), id, count)
+	if err != nil {
+		return false, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affectedRows == 1, nil
+}
+
+// SetScheduleError records why the latest run of a schedule failed, or clears
+// it when lastError is empty.
+func SetScheduleError(db *DB, id int, lastError string) error {
+	_, err := db.Exec("UPDATE poll_schedules SET last_error = ? WHERE id = ?", lastError, id)
+	return err
+}
+
+func querySchedules(db *DB, stmt string, args ...interface{}) ([]models.PollSchedule, error) {
+	var schedules []models.PollSchedule
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+
+	return schedules, rows.Err()
+}
+
+func scanSchedule(row rowScanner) (*models.PollSchedule, error) {
+	schedule := &models.PollSchedule{}
+
+	var lat, lon sql.NullFloat64
+	var lastRunAt sql.NullString
+	var nextRunAt, createdAt string
+	err := row.Scan(
+		&schedule.ID,
+		&schedule.UserID,
+		&schedule.City,
+		&lat,
+		&lon,
+		&schedule.CityID,
+		&schedule.Zip,
+		&schedule.Country,
+		&schedule.IntervalMinutes,
+		&schedule.Enabled,
+		&schedule.RunCount,
+		&nextRunAt,
+		&lastRunAt,
+		&schedule.LastError,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lat.Valid && lon.Valid {
+		schedule.Lat, schedule.Lon = &lat.Float64, &lon.Float64
+	}
+	schedule.NextRunAt, _ = util.ParseTimestamp(nextRunAt)
+	if lastRunAt.Valid {
+		t, _ := util.ParseTimestamp(lastRunAt.String)
+		schedule.LastRunAt = &t
+	}
+	schedule.CreatedAt, _ = util.ParseTimestamp(createdAt)
+
+	return schedule, nil
+}
@@ -0,0 +1,279 @@
+package data
+
+import (
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+)
+
+// SQLStore is the Store backed by a database opened by InitDB, InitSQLite or
+// InitPostgres.
+type SQLStore struct {
+	db *DB
+}
+
+// NewSQLStore returns a Store using db.
+func NewSQLStore(db *DB) *SQLStore {
+	return &SQLStore{db: db}
+}
+
+// DB returns the underlying database handle.
+func (s *SQLStore) DB() *DB {
+	return s.db
+}
+
+func (s *SQLStore) CreateUser(username string, password string, birthDate time.Time) (int, error) {
+	return CreateUser(s.db, username, password, birthDate)
+}
+
+func (s *SQLStore) GetUserByUsername(username string) (*models.User, error) {
+	return GetUserByUsername(s.db, username)
+}
+
+func (s *SQLStore) GetUserByID(id int) (*models.User, error) {
+	return GetUserByID(s.db, id)
+}
+
+func (s *SQLStore) UpdateUserPreferences(userID int, prefs models.Preferences) error {
+	return UpdateUserPreferences(s.db, userID, prefs)
+}
+
+func (s *SQLStore) InsertWeatherHistory(weather models.WeatherResponse, userID int) (int, error) {
+	return InsertWeatherHistory(s.db, weather, userID)
+}
+
+func (s *SQLStore) InsertWeatherHistoryBatch(weathers []models.WeatherResponse, userID int) ([]int, error) {
+	return InsertWeatherHistoryBatch(s.db, weathers, userID)
+}
+
+func (s *SQLStore) FetchWeatherHistory(userID int) ([]models.WeatherResponse, error) {
+	return FetchWeatherHistory(s.db, userID)
+}
+
+func (s *SQLStore) FetchWeatherHistoryPage(userID int, query models.HistoryQuery) (*models.HistoryPage, error) {
+	return FetchWeatherHistoryPage(s.db, userID, query)
+}
+
+func (s *SQLStore) GetWeatherByID(id int, userID int) (*models.WeatherResponse, error) {
+	return GetWeatherByID(s.db, id, userID)
+}
+
+func (s *SQLStore) UpdateWeather(weather *models.WeatherResponse, userID int) error {
+	return UpdateWeather(s.db, weather, userID)
+}
+
+func (s *SQLStore) DeleteWeather(id int, userID int) (int, error) {
+	return DeleteWeather(s.db, id, userID)
+}
+
+func (s *SQLStore) BulkDeleteWeathers(userID int) (int, error) {
+	return BulkDeleteWeathers(s.db, userID)
+}
+
+func (s *SQLStore) InsertForecastHistory(forecast models.ForecastResponse, userID int) (int, error) {
+	return InsertForecastHistory(s.db, forecast, userID)
+}
+
+func (s *SQLStore) CreateFavorite(favorite models.FavoriteLocation, userID int) (int, error) {
+	return CreateFavorite(s.db, favorite, userID)
+}
+
+func (s *SQLStore) FetchFavorites(userID int) ([]models.FavoriteLocation, error) {
+	return FetchFavorites(s.db, userID)
+}
+
+func (s *SQLStore) GetFavoriteByID(id int, userID int) (*models.FavoriteLocation, error) {
+	return GetFavoriteByID(s.db, id, userID)
+}
+
+func (s *SQLStore) UpdateFavorite(favorite *models.FavoriteLocation, userID int) error {
+	return UpdateFavorite(s.db, favorite, userID)
+}
+
+func (s *SQLStore) DeleteFavorite(id int, userID int) (int, error) {
+	return DeleteFavorite(s.db, id, userID)
+}
+
+func (s *SQLStore) ReorderFavorites(userID int, ids []int) error {
+	return ReorderFavorites(s.db, userID, ids)
+}
+
+func (s *SQLStore) CreateAlertRule(rule models.AlertRule, userID int) (int, error) {
+	return CreateAlertRule(s.db, rule, userID)
+}
+
+func (s *SQLStore) FetchAlertRules(userID int) ([]models.AlertRule, error) {
+	return FetchAlertRules(s.db, userID)
+}
+
+func (s *SQLStore) FetchEnabledAlertRules() ([]models.AlertRule, error) {
+	return FetchEnabledAlertRules(s.db)
+}
+
+func (s *SQLStore) GetAlertRuleByID(id int, userID int) (*models.AlertRule, error) {
+	return GetAlertRuleByID(s.db, id, userID)
+}
+
+func (s *SQLStore) UpdateAlertRule(rule *models.AlertRule, userID int) error {
+	return UpdateAlertRule(s.db, rule, userID)
+}
+
+func (s *SQLStore) DeleteAlertRule(id int, userID int) (int, error) {
+	return DeleteAlertRule(s.db, id, userID)
+}
+
+func (s *SQLStore) SetAlertRuleActive(id int, active bool) error {
+	return SetAlertRuleActive(s.db, id, active)
+}
+
+func (s *SQLStore) ClaimAlertNotification(id int, count int, now time.Time) (bool, error) {
+	return ClaimAlertNotification(s.db, id, count, now)
+}
+
+func (s *SQLStore) ReleaseAlertNotification(id int, count int, lastNotifiedAt *time.Time) (bool, error) {
+	return ReleaseAlertNotification(s.db, id, count, lastNotifiedAt)
+}
+
+func (s *SQLStore) CreateAlertEmailAddress(address models.AlertEmailAddress) (int, error) {
+	return CreateAlertEmailAddress(s.db, address)
+}
+
+func (s *SQLStore) GetAlertEmailAddress(userID int, address string) (*models.AlertEmailAddress, error) {
+	return GetAlertEmailAddress(s.db, userID, address)
+}
+
+func (s *SQLStore) ResendAlertEmailAddress(id int, tokenHash string, sentAt time.Time) error {
+	return ResendAlertEmailAddress(s.db, id, tokenHash, sentAt)
+}
+
+func (s *SQLStore) ConfirmAlertEmailAddress(tokenHash string, since, now time.Time) (int, error) {
+	return ConfirmAlertEmailAddress(s.db, tokenHash, since, now)
+}
+
+func (s *SQLStore) CreateWebhook(hook models.Webhook, userID int) (int, error) {
+	return CreateWebhook(s.db, hook, userID)
+}
+
+func (s *SQLStore) FetchWebhooks(userID int) ([]models.Webhook, error) {
+	return FetchWebhooks(s.db, userID)
+}
+
+func (s *SQLStore) GetWebhookByID(id int, userID int) (*models.Webhook, error) {
+	return GetWebhookByID(s.db, id, userID)
+}
+
+func (s *SQLStore) DeleteWebhook(id int, userID int) (int, error) {
+	return DeleteWebhook(s.db, id, userID)
+}
+
+func (s *SQLStore) CreateWebhookDelivery(delivery models.WebhookDelivery) (int, error) {
+	return CreateWebhookDelivery(s.db, delivery)
+}
+
+func (s *SQLStore) UpdateWebhookDelivery(delivery *models.WebhookDelivery) error {
+	return UpdateWebhookDelivery(s.db, delivery)
+}
+
+func (s *SQLStore) FetchDueWebhookDeliveries(now time.Time, skip []int, limit int) ([]models.WebhookDelivery, error) {
+	return FetchDueWebhookDeliveries(s.db, now, skip, limit)
+}
+
+func (s *SQLStore) ClaimWebhookDelivery(id int, attempts int, next time.Time) (bool, error) {
+	return ClaimWebhookDelivery(s.db, id, attempts, next)
+}
+
+func (s *SQLStore) FetchWebhookDeliveries(webhookID int, userID int, limit int) ([]models.WebhookDelivery, error) {
+	return FetchWebhookDeliveries(s.db, webhookID, userID, limit)
+}
+
+func (s *SQLStore) GetWebhookDeliveryByID(id int, userID int) (*models.WebhookDelivery, error) {
+	return GetWebhookDeliveryByID(s.db, id, userID)
+}
+
+func (s *SQLStore) CreateSchedule(schedule models.PollSchedule, userID int) (int, error) {
+	return CreateSchedule(s.db, schedule, userID)
+}
+
+func (s *SQLStore) FetchSchedules(userID int) ([]models.PollSchedule, error) {
+	return FetchSchedules(s.db, userID)
+}
+
+func (s *SQLStore) FetchDueSchedules(now time.Time, limit int) ([]models.PollSchedule, error) {
+	return FetchDueSchedules(s.db, now, limit)
+}
+
+func (s *SQLStore) GetScheduleByID(id int, userID int) (*models.PollSchedule, error) {
+	return GetScheduleByID(s.db, id, userID)
+}
+
+func (s *SQLStore) UpdateSchedule(schedule *models.PollSchedule, userID int) error {
+	return UpdateSchedule(s.db, schedule, userID)
+}
+
+func (s *SQLStore) DeleteSchedule(id int, userID int) (int, error) {
+	return DeleteSchedule(s.db, id, userID)
+}
+
+func (s *SQLStore) ClaimSchedule(id int, count int, now time.Time, next time.Time) (bool, error) {
+	return ClaimSchedule(s.db, id, count, now, next)
+}
+
+func (s *SQLStore) SetScheduleError(id int, lastError string) error {
+	return SetScheduleError(s.db, id, lastError)
+}
+
+func (s *SQLStore) CreateSession(session models.Session) (int, error) {
+	return CreateSession(s.db, session)
+}
+
+func (s *SQLStore) GetSessionByTokenHash(tokenHash string) (*models.Session, error) {
+	return GetSessionByTokenHash(s.db, tokenHash)
+}
+
+func (s *SQLStore) GetSessionByID(id int, userID int) (*models.Session, error) {
+	return GetSessionByID(s.db, id, userID)
+}
+
+func (s *SQLStore) RotateSession(id int, oldTokenHash, newTokenHash string, lastUsedAt, expiresAt time.Time, accessTokenID string, accessExpiresAt time.Time) (int, error) {
+	return RotateSession(s.db, id, oldTokenHash, newTokenHash, lastUsedAt, expiresAt, accessTokenID, accessExpiresAt)
+}
+
+func (s *SQLStore) FetchActiveSessions(userID int, now time.Time) ([]models.Session, error) {
+	return FetchActiveSessions(s.db, userID, now)
+}
+
+func (s *SQLStore) RevokeSession(id int, userID int, now time.Time) (int, error) {
+	return RevokeSession(s.db, id, userID, now)
+}
+
+func (s *SQLStore) RevokeSessionByTokenHash(tokenHash string, userID int, now time.Time) (int, error) {
+	return RevokeSessionByTokenHash(s.db, tokenHash, userID, now)
+}
+
+func (s *SQLStore) RecordLoginAttempt(attempt models.LoginAttempt) (int, error) {
+	return RecordLoginAttempt(s.db, attempt)
+}
+
+func (s *SQLStore) UpdateLoginAttempt(id int, event string) error {
+	return UpdateLoginAttempt(s.db, id, event)
+}
+
+func (s *SQLStore) FetchLoginAttempts(username string, ip string, since time.Time) ([]models.LoginAttempt, error) {
+	return FetchLoginAttempts(s.db, username, ip, since)
+}
+
+func (s *SQLStore) PurgeLoginAttempts(before time.Time) (int, error) {
+	return PurgeLoginAttempts(s.db, before)
+}
+
+func (s *SQLStore) RevokeToken(jti string, userID int, expiresAt time.Time) error {
+	return RevokeToken(s.db, jti, userID, expiresAt)
+}
+
+func (s *SQLStore) FetchRevokedTokens(now time.Time) (map[string]time.Time, error) {
+	return FetchRevokedTokens(s.db, now)
+}
+
+func (s *SQLStore) PurgeExpiredRevokedTokens(now time.Time) (int, error) {
+	return PurgeExpiredRevokedTokens(s.db, now)
+}
@@ -0,0 +1,23 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// InitSQLite opens the SQLite database file at path, creating it if it does
+// not exist yet. Its tables are created by MigrateUp.
+func InitSQLite(path string) (*DB, error) {
+	Db, err := sql.Open("sqlite3", "file:"+path+"?_foreign_keys=on&_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	if err = Db.Ping(); err != nil {
+		return nil, err
+	}
+
+	fmt.Println("Connected to database")
+	return &DB{DB: Db, Dialect: SQLite}, nil
+}
@@ -0,0 +1,130 @@
+package data
+
+import (
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+)
+
+// Lookups that find nothing return sql.ErrNoRows, whatever the backend, so
+// callers can keep checking for it with errors.Is.
+
+// UserStore persists user accounts.
+type UserStore interface {
+	CreateUser(username string, password string, birthDate time.Time) (int, error)
+	GetUserByUsername(username string) (*models.User, error)
+	GetUserByID(id int) (*models.User, error)
+	UpdateUserPreferences(userID int, prefs models.Preferences) error
+}
+
+// HistoryStore persists weather and forecast lookups. Every read, update and
+// delete is scoped to the owning user.
+type HistoryStore interface {
+	InsertWeatherHistory(weather models.WeatherResponse, userID int) (int, error)
+	InsertWeatherHistoryBatch(weathers []models.WeatherResponse, userID int) ([]int, error)
+	FetchWeatherHistory(userID int) ([]models.WeatherResponse, error)
+	FetchWeatherHistoryPage(userID int, query models.HistoryQuery) (*models.HistoryPage, error)
+	GetWeatherByID(id int, userID int) (*models.WeatherResponse, error)
+	UpdateWeather(weather *models.WeatherResponse, userID int) error
+	DeleteWeather(id int, userID int) (int, error)
+	BulkDeleteWeathers(userID int) (int, error)
+	InsertForecastHistory(forecast models.ForecastResponse, userID int) (int, error)
+}
+
+// FavoriteStore persists the locations users saved. Every read, update and
+// delete is scoped to the owning user.
+type FavoriteStore interface {
+	CreateFavorite(favorite models.FavoriteLocation, userID int) (int, error)
+	FetchFavorites(userID int) ([]models.FavoriteLocation, error)
+	GetFavoriteByID(id int, userID int) (*models.FavoriteLocation, error)
+	UpdateFavorite(favorite *models.FavoriteLocation, userID int) error
+	DeleteFavorite(id int, userID int) (int, error)
+	ReorderFavorites(userID int, ids []int) error
+}
+
+// AlertStore persists alert rules, the state of their evaluation and the email
+// addresses users confirmed for them. Reads, updates and deletes made for
+// users are scoped to the owning user.
+type AlertStore interface {
+	CreateAlertRule(rule models.AlertRule, userID int) (int, error)
+	FetchAlertRules(userID int) ([]models.AlertRule, error)
+	FetchEnabledAlertRules() ([]models.AlertRule, error)
+	GetAlertRuleByID(id int, userID int) (*models.AlertRule, error)
+	UpdateAlertRule(rule *models.AlertRule, userID int) error
+	DeleteAlertRule(id int, userID int) (int, error)
+	SetAlertRuleActive(id int, active bool) error
+	ClaimAlertNotification(id int, count int, now time.Time) (bool, error)
+	ReleaseAlertNotification(id int, count int, lastNotifiedAt *time.Time) (bool, error)
+	CreateAlertEmailAddress(address models.AlertEmailAddress) (int, error)
+	GetAlertEmailAddress(userID int, address string) (*models.AlertEmailAddress, error)
+	ResendAlertEmailAddress(id int, tokenHash string, sentAt time.Time) error
+	ConfirmAlertEmailAddress(tokenHash string, since, now time.Time) (int, error)
+}
+
+// WebhookStore persists the webhooks users registered and the log of
+// deliveries made to them. Reads and deletes are scoped to the owning user.
+type WebhookStore interface {
+	CreateWebhook(hook models.Webhook, userID int) (int, error)
+	FetchWebhooks(userID int) ([]models.Webhook, error)
+	GetWebhookByID(id int, userID int) (*models.Webhook, error)
+	DeleteWebhook(id int, userID int) (int, error)
+	CreateWebhookDelivery(delivery models.WebhookDelivery) (int, error)
+	UpdateWebhookDelivery(delivery *models.WebhookDelivery) error
+	FetchDueWebhookDeliveries(now time.Time, skip []int, limit int) ([]models.WebhookDelivery, error)
+	ClaimWebhookDelivery(id int, attempts int, next time.Time) (bool, error)
+	FetchWebhookDeliveries(webhookID int, userID int, limit int) ([]models.WebhookDelivery, error)
+	GetWebhookDeliveryByID(id int, userID int) (*models.WebhookDelivery, error)
+}
+
+// ScheduleStore persists the locations polled in the background and the state
+// of their runs. Reads, updates and deletes made for users are scoped to the
+// owning user.
+type ScheduleStore interface {
+	CreateSchedule(schedule models.PollSchedule, userID int) (int, error)
+	FetchSchedules(userID int) ([]models.PollSchedule, error)
+	FetchDueSchedules(now time.Time, limit int) ([]models.PollSchedule, error)
+	GetScheduleByID(id int, userID int) (*models.PollSchedule, error)
+	UpdateSchedule(schedule *models.PollSchedule, userID int) error
+	DeleteSchedule(id int, userID int) (int, error)
+	ClaimSchedule(id int, count int, now time.Time, next time.Time) (bool, error)
+	SetScheduleError(id int, lastError string) error
+}
+
+// SessionStore persists refresh token sessions.
+type SessionStore interface {
+	CreateSession(session models.Session) (int, error)
+	GetSessionByTokenHash(tokenHash string) (*models.Session, error)
+	GetSessionByID(id int, userID int) (*models.Session, error)
+	RotateSession(id int, oldTokenHash, newTokenHash string, lastUsedAt, expiresAt time.Time, accessTokenID string, accessExpiresAt time.Time) (int, error)
+	FetchActiveSessions(userID int, now time.Time) ([]models.Session, error)
+	RevokeSession(id int, userID int, now time.Time) (int, error)
+	RevokeSessionByTokenHash(tokenHash string, userID int, now time.Time) (int, error)
+}
+
+// LoginAttemptStore persists the login history used to throttle failed logins.
+type LoginAttemptStore interface {
+	RecordLoginAttempt(attempt models.LoginAttempt) (int, error)
+	UpdateLoginAttempt(id int, event string) error
+	FetchLoginAttempts(username string, ip string, since time.Time) ([]models.LoginAttempt, error)
+	PurgeLoginAttempts(before time.Time) (int, error)
+}
+
+// RevocationStore persists the ids of access tokens revoked before they expired.
+type RevocationStore interface {
+	RevokeToken(jti string, userID int, expiresAt time.Time) error
+	FetchRevokedTokens(now time.Time) (map[string]time.Time, error)
+	PurgeExpiredRevokedTokens(now time.Time) (int, error)
+}
+
+// Store is the full storage layer used by the API.
+type Store interface {
+	UserStore
+	HistoryStore
+	FavoriteStore
+	AlertStore
+	WebhookStore
+	ScheduleStore
+	SessionStore
+	LoginAttemptStore
+	RevocationStore
+}
@@ -0,0 +1,243 @@
+package data
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+const webhookColumns = "id, user_id, url, secret, events, created_at"
+
+const webhookDeliveryColumns = "id, webhook_id, user_id, event, payload, status, attempts, response_status, error, created_at, delivered_at, next_attempt_at"
+
+// CreateWebhook saves a webhook for userID. The events it subscribes to are
+// stored comma separated.
+func CreateWebhook(db *DB, hook models.Webhook, userID int) (int, error) {
+	stmt := "INSERT INTO webhooks (user_id, url, secret, events) VALUES (?, ?, ?, ?)"
+
+	return db.insert(stmt, userID, hook.URL, hook.Secret, strings.Join(hook.Events, ","))
+}
+
+func FetchWebhooks(db *DB, userID int) ([]models.Webhook, error) {
+	var hooks []models.Webhook
+
+	stmt := "SELECT " + webhookColumns + " FROM webhooks WHERE user_id = ? ORDER BY id"
+
+	rows, err := db.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, *hook)
+	}
+
+	return hooks, rows.Err()
+}
+
+func GetWebhookByID(db *DB, id int, userID int) (*models.Webhook, error) {
+	stmt := "SELECT " + webhookColumns + " FROM webhooks WHERE id = ? AND user_id = ?"
+
+	return scanWebhook(db.QueryRow(stmt, id, userID))
+}
+
+// DeleteWebhook deletes a webhook of userID together with its deliveries.
+func DeleteWebhook(db *DB, id int, userID int) (int, error) {
+	stmt := "DELETE FROM webhooks WHERE id = ? AND user_id = ?"
+
+	result, err := db.Exec(stmt, id, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affectedRows), nil
+}
+
+func CreateWebhookDelivery(db *DB, delivery models.WebhookDelivery) (int, error) {
+	stmt := "INSERT INTO webhook_deliveries (webhook_id, user_id, event, payload, status, next_attempt_at) VALUES (?, ?, ?, ?, ?, ?)"
+
+	return db.insert(stmt,
+		delivery.WebhookID,
+		delivery.UserID,
+		delivery.Event,
+		string(delivery.Payload),
+		delivery.Status,
+		nullableTime(delivery.NextAttemptAt),
+	)
+}
+
+// UpdateWebhookDelivery records the outcome of the latest attempt at a delivery.
+func UpdateWebhookDelivery(db *DB, delivery *models.WebhookDelivery) error {
+	stmt := "UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, error = ?, delivered_at = ?, next_attempt_at = ? WHERE id = ?"
+
+	_, err := db.Exec(stmt,
+		delivery.Status,
+		delivery.Attempts,
+		delivery.ResponseStatus,
+		delivery.Error,
+		nullableTime(delivery.DeliveredAt),
+		nullableTime(delivery.NextAttemptAt),
+		delivery.ID,
+	)
+	return err
+}
+
+// FetchDueWebhookDeliveries returns up to limit pending deliveries of every
+// user whose next attempt is due at now, the longest overdue first, leaving
+// out those to the webhooks in skip.
+func FetchDueWebhookDeliveries(db *DB, now time.Time, skip []int, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	stmt := "SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= ?"
+	args := []interface{}{now.UTC()}
+	if len(skip) > 0 {
+		placeholders := make([]string, len(skip))
+		for i, id := range skip {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		stmt += " AND webhook_id NOT IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	stmt += " ORDER BY next_attempt_at, id LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+// ClaimWebhookDelivery counts an attempt at a pending delivery and moves its
+// next attempt to next, provided it has still made attempts attempts. It
+// reports whether the claim succeeded, so that of several dispatchers only
+// one makes the attempt, and one that stops midway is retried at next.
+func ClaimWebhookDelivery(db *DB, id int, attempts int, next time.Time) (bool, error) {
+	stmt := "UPDATE webhook_deliveries SET attempts = attempts + 1, next_attempt_at = ? WHERE id = ? AND status = 'pending' AND attempts = ?"
+
+	result, err := db.Exec(stmt, next.UTC(), id, attempts)
+	if err != nil {
+		return false, err
+	}
+
+	affectedRows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affectedRows == 1, nil
+}
+
+// FetchWebhookDeliveries returns the latest limit deliveries to a webhook of
+// userID, newest first.
+func FetchWebhookDeliveries(db *DB, webhookID int, userID int, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+
+	stmt := "SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries WHERE webhook_id = ? AND user_id = ? ORDER BY id DESC LIMIT ?"
+
+	rows, err := db.Query(stmt, webhookID, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func GetWebhookDeliveryByID(db *DB, id int, userID int) (*models.WebhookDelivery, error) {
+	stmt := "SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries WHERE id = ? AND user_id = ?"
+
+	return scanWebhookDelivery(db.QueryRow(stmt, id, userID))
+}
+
+func scanWebhook(row rowScanner) (*models.Webhook, error) {
+	hook := &models.Webhook{}
+
+	var events, createdAt string
+	err := row.Scan(
+		&hook.ID,
+		&hook.UserID,
+		&hook.URL,
+		&hook.Secret,
+		&events,
+		&createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	hook.Events = []string{}
+	if events != "" {
+		hook.Events = strings.Split(events, ",")
+	}
+	hook.CreatedAt, _ = util.ParseTimestamp(createdAt)
+
+	return hook, nil
+}
+
+func scanWebhookDelivery(row rowScanner) (*models.WebhookDelivery, error) {
+	delivery := &models.WebhookDelivery{}
+
+	var payload, createdAt string
+	var deliveredAt, nextAttemptAt sql.NullString
+	err := row.Scan(
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.UserID,
+		&delivery.Event,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.ResponseStatus,
+		&delivery.Error,
+		&createdAt,
+		&deliveredAt,
+		&nextAttemptAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Payload = json.RawMessage(payload)
+	delivery.CreatedAt, _ = util.ParseTimestamp(createdAt)
+	if deliveredAt.Valid {
+		t, _ := util.ParseTimestamp(deliveredAt.String)
+		delivery.DeliveredAt = &t
+	}
+	if nextAttemptAt.Valid {
+		t, _ := util.ParseTimestamp(nextAttemptAt.String)
+		delivery.NextAttemptAt = &t
+	}
+
+	return delivery, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/provider"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+// maxFavorites is how many locations a user can save.
+const maxFavorites = 50
+
+// favoriteFromBody reads a label and a location from the JSON request body,
+// writing a 400 response and returning false when either is invalid.
+func favoriteFromBody(w http.ResponseWriter, r *http.Request) (models.FavoriteLocation, bool) {
+	var body struct {
+		Label string `json:"label"`
+		models.Location
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid JSON provided.",
+			Data:    nil,
+		})
+		return models.FavoriteLocation{}, false
+	}
+
+	favorite := models.FavoriteLocation{
+		Label:    strings.TrimSpace(body.Label),
+		Location: body.Location,
+	}
+	favorite.City = strings.TrimSpace(favorite.City)
+	favorite.Zip = strings.TrimSpace(favorite.Zip)
+	favorite.Country = strings.TrimSpace(favorite.Country)
+
+	message := ""
+	switch {
+	case favorite.Label == "":
+		message = "Label is required."
+	case len(favorite.Label) > 100:
+		message = "Label is too long."
+	default:
+		if err := provider.ValidateLocation(favorite.Location); err != nil {
+			message = err.Error()
+		}
+	}
+	if message != "" {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: message,
+			Data:    nil,
+		})
+		return favorite, false
+	}
+
+	return favorite, true
+}
+
+// favoriteID reads the favoriteID query parameter, writing a 400 response and
+// returning false when it is not a valid id.
+func favoriteID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("favoriteID"))
+	if err != nil || id <= 0 {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid favoriteID.",
+			Data:    nil,
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// isPermutation reports whether ids lists the id of every favorite exactly once.
+func isPermutation(ids []int, favorites []models.FavoriteLocation) bool {
+	if len(ids) != len(favorites) {
+		return false
+	}
+
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+	}
+	for _, favorite := range favorites {
+		if !seen[favorite.ID] {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupError returns the message reported for a failed lookup of loc in a
+// response listing several lookups.
+func lookupError(w http.ResponseWriter, loc models.Location, err error) string {
+	if errors.Is(err, provider.ErrNotFound) {
+		return localize(w, notFoundMessage(loc))
+	}
+	log.Error(err)
+	return localize(w, "Failed to fetch weather.")
+}
@@ -8,16 +8,18 @@ import (
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/KunalDuran/weather-api/data"
 	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/provider"
 	"github.com/KunalDuran/weather-api/util"
 )
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) loginHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodPost {
 		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
@@ -31,6 +33,7 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	var user struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Device   string `json:"device"`
 	}
 	err := json.NewDecoder(r.Body).Decode(&user)
 	if err != nil {
@@ -60,9 +63,39 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userRecord, err := data.GetUserByUsername(db, user.Username)
+	username := strings.ToLower(user.Username)
+	ip := util.ClientIP(r)
+	now := time.Now()
+	attemptID, wait, locked, err := s.beginLogin(username, ip, now)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Internal server error.",
+			Data:    nil,
+		})
+		return
+	}
+	if wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(wait)))
+		message := "Too many failed logins, try again later."
+		if locked {
+			message = "Account locked after too many failed logins, try again later."
+		}
+		util.JSONResponse(w, http.StatusTooManyRequests, &models.Response{
+			Status:  "error",
+			Message: message,
+			Data:    nil,
+		})
+		return
+	}
+
+	userRecord, err := s.users.GetUserByUsername(user.Username)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			if err := s.finishLogin(attemptID, username, ip, loginFailed, now); err != nil {
+				log.Error(err)
+			}
 			util.JSONResponse(w, http.StatusUnauthorized, &models.Response{
 				Status:  "error",
 				Message: "Invalid credentials.",
@@ -70,6 +103,9 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 			})
 			return
 		} else {
+			if err := s.finishLogin(attemptID, username, ip, loginErrored, now); err != nil {
+				log.Error(err)
+			}
 			util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
 				Status:  "error",
 				Message: "Internal server error.",
@@ -80,6 +116,9 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(userRecord.Password), []byte(user.Password)); err != nil {
+		if err := s.finishLogin(attemptID, username, ip, loginFailed, now); err != nil {
+			log.Error(err)
+		}
 		util.JSONResponse(w, http.StatusUnauthorized, &models.Response{
 			Status:  "error",
 			Message: "Invalid credentials.",
@@ -88,28 +127,35 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := util.CreateToken(userRecord.ID, userRecord.Username)
+	if user.Device == "" {
+		user.Device = r.UserAgent()
+	}
+
+	if err := s.finishLogin(attemptID, username, ip, loginSucceeded, now); err != nil {
+		log.Error(err)
+	}
+
+	tokens, err := s.issueTokens(userRecord.ID, userRecord.Username, user.Device, ip)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		resp := &models.Response{
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
 			Status:  "error",
 			Message: "Failed to create token.",
 			Data:    nil,
-		}
-		json.NewEncoder(w).Encode(resp)
+		})
 		return
 	}
 
-	w.Header().Set("Authorization", "Bearer "+token)
+	w.Header().Set("Authorization", "Bearer "+tokens["token"].(string))
 	resp := &models.Response{
 		Status:  "success",
 		Message: "Logged in successfully.",
-		Data:    map[string]string{"token": token},
+		Data:    tokens,
 	}
 	util.JSONResponse(w, http.StatusOK, resp)
 }
 
-func registerHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) registerHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodPost {
 		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
@@ -124,6 +170,7 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		Username  string `json:"username"`
 		Password  string `json:"password"`
 		BirthDate string `json:"birth_date"`
+		Device    string `json:"device"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
@@ -171,7 +218,7 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	existingUser, err := data.GetUserByUsername(db, user.Username)
+	existingUser, err := s.users.GetUserByUsername(user.Username)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
 			Status:  "error",
@@ -208,7 +255,7 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := data.CreateUser(db, user.Username, string(hashedPassword), parseBirthDate)
+	id, err := s.users.CreateUser(user.Username, string(hashedPassword), parseBirthDate)
 	if err != nil {
 		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
 			Status:  "error",
@@ -218,8 +265,13 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := util.CreateToken(id, user.Username)
+	if user.Device == "" {
+		user.Device = r.UserAgent()
+	}
+
+	tokens, err := s.issueTokens(id, user.Username, user.Device, util.ClientIP(r))
 	if err != nil {
+		log.Error(err)
 		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
 			Status:  "error",
 			Message: "Failed to create token.",
@@ -228,112 +280,167 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Authorization", "Bearer "+token)
+	w.Header().Set("Authorization", "Bearer "+tokens["token"].(string))
 	resp := &models.Response{
 		Status:  "success",
 		Message: "Registered successfully.",
-		Data:    map[string]string{"token": token},
+		Data:    tokens,
 	}
 
 	util.JSONResponse(w, http.StatusOK, resp)
 
 }
 
-func weatherHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
 
-	city := r.URL.Query().Get("city")
-	if city == "" {
-		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+	if r.Method != http.MethodPost {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
 			Status:  "error",
-			Message: "City name is required.",
+			Message: "Method not allowed.",
 			Data:    nil,
 		})
 		return
 	}
 
-	url := fmt.Sprintf("https://api.openweathermap.org/data/2.5/weather?q=%s&appid=%s", city, API_KEY)
-
-	resp, err := util.WebRequest("GET", url, nil)
-	if err != nil {
-		log.Error(err)
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Refresh token is required.",
+			Data:    nil,
+		})
+		return
 	}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+	tokenHash := util.HashToken(body.RefreshToken)
+	session, err := s.sessions.GetSessionByTokenHash(tokenHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Internal server error.",
+			Data:    nil,
+		})
+		return
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		var errorResp models.StandardResponse
-		err = json.Unmarshal(body, &errorResp)
-		if err != nil {
-			log.Error(err)
-		}
+	now := time.Now()
+	if session == nil || session.RevokedAt != nil || !now.Before(session.ExpiresAt) {
+		util.JSONResponse(w, http.StatusUnauthorized, &models.Response{
+			Status:  "error",
+			Message: "Invalid or expired refresh token.",
+			Data:    nil,
+		})
+		return
+	}
 
-		util.JSONResponse(w, http.StatusOK, &models.Response{
+	userRecord, err := s.users.GetUserByID(session.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusUnauthorized, &models.Response{
 			Status:  "error",
-			Message: "City not found.",
+			Message: "Invalid or expired refresh token.",
 			Data:    nil,
 		})
 		return
 	}
 
-	var weatherResponse models.WeatherResponse
-	err = json.Unmarshal(body, &weatherResponse)
+	refreshToken, err := util.GenerateRefreshToken()
 	if err != nil {
 		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to create token.",
+			Data:    nil,
+		})
+		return
 	}
 
-	userID, err := util.GetUserIDFromToken(r.Header.Get("Authorization"))
+	// The access token is recorded with the session, so that revoking the
+	// session revokes it too
+	token, claims, err := util.IssueToken(userRecord.ID, userRecord.Username, s.roles(userRecord.ID)...)
 	if err != nil {
 		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to create token.",
+			Data:    nil,
+		})
+		return
 	}
 
-	insertedRowID, err := data.InsertWeatherHistory(db, weatherResponse, userID)
+	expiresAt := now.Add(s.refreshTokenTTL)
+	affectedRows, err := s.sessions.RotateSession(session.ID, tokenHash, util.HashToken(refreshToken), now, expiresAt, claims.ID, claims.ExpiresAt.Time)
 	if err != nil {
 		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Internal server error.",
+			Data:    nil,
+		})
+		return
 	}
 
-	weatherResponse.WeatherID = insertedRowID
+	// Another request rotated or revoked the session first.
+	if affectedRows == 0 {
+		util.JSONResponse(w, http.StatusUnauthorized, &models.Response{
+			Status:  "error",
+			Message: "Invalid or expired refresh token.",
+			Data:    nil,
+		})
+		return
+	}
 
+	w.Header().Set("Authorization", "Bearer "+token)
 	util.JSONResponse(w, http.StatusOK, &models.Response{
 		Status:  "success",
-		Message: "Weather fetched successfully",
-		Data:    weatherResponse,
+		Message: "Token refreshed successfully.",
+		Data: map[string]interface{}{
+			"token":              token,
+			"refresh_token":      refreshToken,
+			"refresh_expires_at": expiresAt.UTC(),
+			"session_id":         session.ID,
+		},
 	})
-
 }
 
-func getWeatherHistoryHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) sessionsHandler(w http.ResponseWriter, r *http.Request) {
 
-	userID, _ := util.GetUserIDFromToken(r.Header.Get("Authorization"))
-	weatherData, err := data.FetchWeatherHistory(db, userID)
-	if err != nil {
-		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+	if r.Method != http.MethodGet {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
 			Status:  "error",
-			Message: "Failed to fetch weather history.",
+			Message: "Method not allowed.",
 			Data:    nil,
 		})
 		return
 	}
 
-	if weatherData == nil {
-		util.JSONResponse(w, http.StatusOK, &models.Response{
-			Status:  "info",
-			Message: "No Search History Found.",
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := s.sessions.FetchActiveSessions(principal.UserID, time.Now())
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch sessions.",
 			Data:    nil,
 		})
 		return
 	}
+
 	util.JSONResponse(w, http.StatusOK, &models.Response{
 		Status:  "success",
-		Message: "Search history fetched successfully.",
-		Data:    weatherData,
+		Message: "Sessions fetched successfully.",
+		Data:    sessions,
 	})
 }
 
-func deleteWeatherHistoryHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method != http.MethodDelete {
 		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
@@ -344,23 +451,27 @@ func deleteWeatherHistoryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	weatherID := r.URL.Query().Get("weatherID")
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
 
-	weatherIDInt, err := strconv.Atoi(weatherID)
-	if err != nil || weatherIDInt <= 0 || weatherID == "" {
+	sessionID, err := strconv.Atoi(r.URL.Query().Get("sessionID"))
+	if err != nil || sessionID <= 0 {
 		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
 			Status:  "error",
-			Message: "Invalid weatherID.",
+			Message: "Invalid sessionID.",
 			Data:    nil,
 		})
 		return
 	}
 
-	affectedRows, err := data.DeleteWeather(db, weatherIDInt)
+	affectedRows, err := s.sessions.RevokeSession(sessionID, principal.UserID, time.Now())
 	if err != nil {
+		log.Error(err)
 		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
 			Status:  "error",
-			Message: "Failed to delete weather.",
+			Message: "Failed to revoke session.",
 			Data:    nil,
 		})
 		return
@@ -369,7 +480,17 @@ func deleteWeatherHistoryHandler(w http.ResponseWriter, r *http.Request) {
 	if affectedRows == 0 {
 		util.JSONResponse(w, http.StatusNotFound, &models.Response{
 			Status:  "error",
-			Message: "Weather not found with this ID.",
+			Message: "Session not found with this ID.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if err := s.revokeSessionToken(sessionID, principal.UserID); err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to revoke session.",
 			Data:    nil,
 		})
 		return
@@ -377,14 +498,14 @@ func deleteWeatherHistoryHandler(w http.ResponseWriter, r *http.Request) {
 
 	util.JSONResponse(w, http.StatusOK, &models.Response{
 		Status:  "success",
-		Message: "Successfully deleted weather",
+		Message: "Session revoked successfully.",
 		Data:    nil,
 	})
 }
 
-func bulkDeleteWeatherHistoryHandler(w http.ResponseWriter, r *http.Request) {
+func (s *server) logoutHandler(w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodDelete {
+	if r.Method != http.MethodPost {
 		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
 			Status:  "error",
 			Message: "Method not allowed.",
@@ -393,32 +514,1708 @@ func bulkDeleteWeatherHistoryHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID, _ := util.GetUserIDFromToken(r.Header.Get("Authorization"))
-	affectedRows, err := data.BulkDeleteWeathers(db, userID)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		resp := &models.Response{
+	// The refresh token is optional; when sent, its session is ended too.
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
 			Status:  "error",
-			Message: "Failed to delete weathers.",
+			Message: "Invalid JSON provided.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.revoked.Revoke(principal.TokenID, principal.UserID, principal.ExpiresAt); err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to log out.",
 			Data:    nil,
+		})
+		return
+	}
+
+	if body.RefreshToken != "" {
+		tokenHash := util.HashToken(body.RefreshToken)
+		affectedRows, err := s.sessions.RevokeSessionByTokenHash(tokenHash, principal.UserID, time.Now())
+		if err != nil {
+			log.Error(err)
 		}
-		json.NewEncoder(w).Encode(resp)
+		if affectedRows > 0 {
+			// The session may have issued a newer token than the one logging out
+			session, err := s.sessions.GetSessionByTokenHash(tokenHash)
+			if err == nil {
+				err = s.revokeAccessToken(session)
+			}
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Logged out successfully.",
+		Data:    nil,
+	})
+}
+
+// preferencesHandler returns the user's preferences on GET and changes them
+// on PUT. Fields left out of a PUT body keep their current value.
+func (s *server) preferencesHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPut {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
 		return
 	}
 
-	if affectedRows > 0 {
-		resp := &models.Response{
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := s.users.GetUserByID(principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch preferences.",
+			Data:    nil,
+		})
+		return
+	}
+
+	prefs := models.Preferences{Units: user.Units, Lang: user.Lang}
+
+	if r.Method == http.MethodGet {
+		util.JSONResponse(w, http.StatusOK, &models.Response{
 			Status:  "success",
-			Message: "Successfully deleted weathers.",
+			Message: "Preferences fetched successfully.",
+			Data:    prefs,
+		})
+		return
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&prefs); err != nil {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid JSON provided.",
 			Data:    nil,
-		}
-		util.JSONResponse(w, http.StatusOK, resp)
-	} else {
-		resp := &models.Response{
-			Status:  "info",
-			Message: "No history to delete.",
+		})
+		return
+	}
+
+	if !util.ValidUnits(prefs.Units) {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid units, use standard, metric or imperial.",
 			Data:    nil,
-		}
-		util.JSONResponse(w, http.StatusNoContent, resp)
+		})
+		return
+	}
+
+	if !validLang(prefs.Lang) {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid language code.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if err := s.users.UpdateUserPreferences(principal.UserID, prefs); err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to update preferences.",
+			Data:    nil,
+		})
+		return
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Preferences updated successfully.",
+		Data:    prefs,
+	})
+}
+
+func (s *server) weatherHandler(w http.ResponseWriter, r *http.Request) {
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
 	}
+
+	loc, ok := weatherLocation(w, r)
+	if !ok {
+		return
+	}
+
+	prefs := s.preferences(principal)
+
+	opts, ok := weatherOptions(w, r, prefs)
+	if !ok {
+		return
+	}
+
+	units, ok := requestUnits(w, r, prefs)
+	if !ok {
+		return
+	}
+
+	weatherResponse, cacheHit, err := s.fetchCurrentWeather(loc, opts)
+	if err != nil {
+		if errors.Is(err, provider.ErrNotFound) {
+			util.JSONResponse(w, http.StatusNotFound, &models.Response{
+				Status:  "error",
+				Message: notFoundMessage(loc),
+				Data:    nil,
+			})
+			return
+		}
+
+		log.Error(err)
+		util.JSONResponse(w, http.StatusBadGateway, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch weather.",
+			Data:    nil,
+		})
+		return
+	}
+
+	setCacheHeader(w, cacheHit)
+
+	insertedRowID, err := s.history.InsertWeatherHistory(*weatherResponse, principal.UserID)
+	if err != nil {
+		log.Error(err)
+	}
+
+	weatherResponse.WeatherID = insertedRowID
+	// The upstream payload is kept in the history, see /api/history?include=raw
+	weatherResponse.Raw = nil
+	if err == nil {
+		s.dispatcher.emit(principal.UserID, eventWeatherFetched, weatherResponse)
+	}
+	util.ConvertWeather(weatherResponse, units)
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Weather fetched successfully",
+		Data:    weatherResponse,
+	})
+
+}
+
+// batchWeatherHandler fetches the current weather at several locations in one
+// call. Each location gets its own result or error, and the lookups that
+// succeeded are recorded in the history together.
+func (s *server) batchWeatherHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Locations []models.Location `json:"locations"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid JSON provided.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if len(body.Locations) == 0 || len(body.Locations) > maxBatchLocations {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: fmt.Sprintf("Provide between 1 and %d locations.", maxBatchLocations),
+			Data:    nil,
+		})
+		return
+	}
+
+	prefs := s.preferences(principal)
+
+	opts, ok := weatherOptions(w, r, prefs)
+	if !ok {
+		return
+	}
+
+	units, ok := requestUnits(w, r, prefs)
+	if !ok {
+		return
+	}
+
+	batch := models.BatchWeatherResponse{Items: make([]models.BatchWeatherItem, len(body.Locations))}
+
+	// Invalid locations are reported without being looked up
+	var locs []models.Location
+	var positions []int
+	for i, loc := range body.Locations {
+		loc.City = strings.TrimSpace(loc.City)
+		loc.Zip = strings.TrimSpace(loc.Zip)
+		loc.Country = strings.TrimSpace(loc.Country)
+		batch.Items[i].Location = loc
+
+		if err := provider.ValidateLocation(loc); err != nil {
+			batch.Items[i].Error = localize(w, err.Error())
+			continue
+		}
+		locs = append(locs, loc)
+		positions = append(positions, i)
+	}
+
+	var fetched []models.WeatherResponse
+	var fetchedPositions []int
+	for j, result := range s.fetchCurrentWeatherAll(locs, opts) {
+		i := positions[j]
+		if result.err != nil {
+			batch.Items[i].Error = lookupError(w, locs[j], result.err)
+			continue
+		}
+		batch.Items[i].Weather = result.weather
+		fetched = append(fetched, *result.weather)
+		fetchedPositions = append(fetchedPositions, i)
+	}
+
+	if len(fetched) > 0 {
+		ids, err := s.history.InsertWeatherHistoryBatch(fetched, principal.UserID)
+		if err != nil {
+			// The lookups are all recorded or none is, so they all fail
+			log.Error(err)
+			for _, i := range fetchedPositions {
+				batch.Items[i].Weather = nil
+				batch.Items[i].Error = localize(w, "Failed to record weather.")
+			}
+		}
+		for k, id := range ids {
+			batch.Items[fetchedPositions[k]].Weather.WeatherID = id
+		}
+	}
+
+	for i := range batch.Items {
+		if batch.Items[i].Weather == nil {
+			batch.Failed++
+			continue
+		}
+		batch.Succeeded++
+		// The upstream payload is kept in the history, see /api/history?include=raw
+		batch.Items[i].Weather.Raw = nil
+		util.ConvertWeather(batch.Items[i].Weather, units)
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Batch weather fetched successfully.",
+		Data:    batch,
+	})
+}
+
+func (s *server) forecastHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	loc, ok := weatherLocation(w, r)
+	if !ok {
+		return
+	}
+
+	prefs := s.preferences(principal)
+
+	opts, ok := weatherOptions(w, r, prefs)
+	if !ok {
+		return
+	}
+
+	units, ok := requestUnits(w, r, prefs)
+	if !ok {
+		return
+	}
+
+	forecast, cacheHit, err := s.fetchForecast(loc, opts)
+	if err != nil {
+		if errors.Is(err, provider.ErrNotFound) {
+			util.JSONResponse(w, http.StatusNotFound, &models.Response{
+				Status:  "error",
+				Message: notFoundMessage(loc),
+				Data:    nil,
+			})
+			return
+		}
+
+		log.Error(err)
+		util.JSONResponse(w, http.StatusBadGateway, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch forecast.",
+			Data:    nil,
+		})
+		return
+	}
+
+	setCacheHeader(w, cacheHit)
+
+	if s.storeForecasts {
+		forecast.ForecastID, err = s.history.InsertForecastHistory(*forecast, principal.UserID)
+		if err != nil {
+			log.Error(err)
+		}
+	}
+
+	util.ConvertForecast(forecast, units)
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Forecast fetched successfully.",
+		Data:    forecast,
+	})
+}
+
+func (s *server) getWeatherHistoryHandler(w http.ResponseWriter, r *http.Request) {
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	query, err := parseHistoryQuery(r.URL.Query())
+	if err != nil {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: err.Error(),
+			Data:    nil,
+		})
+		return
+	}
+
+	units, ok := requestUnits(w, r, s.preferences(principal))
+	if !ok {
+		return
+	}
+
+	// History is stored in standard units, so temperature bounds given in
+	// another system are converted before filtering
+	if query.MinTemp != nil {
+		minTemp := util.TemperatureToKelvin(*query.MinTemp, units)
+		query.MinTemp = &minTemp
+	}
+	if query.MaxTemp != nil {
+		maxTemp := util.TemperatureToKelvin(*query.MaxTemp, units)
+		query.MaxTemp = &maxTemp
+	}
+
+	page, err := s.history.FetchWeatherHistoryPage(principal.UserID, query)
+	if errors.Is(err, data.ErrInvalidCursor) {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid cursor.",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch weather history.",
+			Data:    nil,
+		})
+		return
+	}
+
+	for i := range page.Items {
+		util.ConvertWeather(&page.Items[i], units)
+	}
+
+	if page.TotalCount == 0 {
+		util.JSONResponse(w, http.StatusOK, &models.Response{
+			Status:  "info",
+			Message: "No Search History Found.",
+			Data:    page,
+		})
+		return
+	}
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Search history fetched successfully.",
+		Data:    page,
+	})
+}
+
+func (s *server) deleteWeatherHistoryHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodDelete {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	weatherID := r.URL.Query().Get("weatherID")
+
+	weatherIDInt, err := strconv.Atoi(weatherID)
+	if err != nil || weatherIDInt <= 0 || weatherID == "" {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid weatherID.",
+			Data:    nil,
+		})
+		return
+	}
+
+	affectedRows, err := s.history.DeleteWeather(weatherIDInt, principal.UserID)
+	if err != nil {
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to delete weather.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if affectedRows == 0 {
+		util.JSONResponse(w, http.StatusNotFound, &models.Response{
+			Status:  "error",
+			Message: "Weather not found with this ID.",
+			Data:    nil,
+		})
+		return
+	}
+
+	s.dispatcher.emit(principal.UserID, eventHistoryDeleted, map[string]int{"weather_id": weatherIDInt})
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Successfully deleted weather",
+		Data:    nil,
+	})
+}
+
+func (s *server) bulkDeleteWeatherHistoryHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodDelete {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	affectedRows, err := s.history.BulkDeleteWeathers(principal.UserID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		resp := &models.Response{
+			Status:  "error",
+			Message: "Failed to delete weathers.",
+			Data:    nil,
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if affectedRows > 0 {
+		s.dispatcher.emit(principal.UserID, eventHistoryCleared, map[string]int{"deleted_count": affectedRows})
+		resp := &models.Response{
+			Status:  "success",
+			Message: "Successfully deleted weathers.",
+			Data:    nil,
+		}
+		util.JSONResponse(w, http.StatusOK, resp)
+	} else {
+		resp := &models.Response{
+			Status:  "info",
+			Message: "No history to delete.",
+			Data:    nil,
+		}
+		util.JSONResponse(w, http.StatusNoContent, resp)
+	}
+}
+
+// favoritesHandler lists the user's favorites on GET and saves a new one,
+// after the existing ones, on POST.
+func (s *server) favoritesHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	favorites, err := s.favorites.FetchFavorites(principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch favorites.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if favorites == nil {
+			favorites = []models.FavoriteLocation{}
+		}
+		util.JSONResponse(w, http.StatusOK, &models.Response{
+			Status:  "success",
+			Message: "Favorites fetched successfully.",
+			Data:    favorites,
+		})
+		return
+	}
+
+	favorite, ok := favoriteFromBody(w, r)
+	if !ok {
+		return
+	}
+
+	if len(favorites) >= maxFavorites {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Too many favorites, delete one first.",
+			Data:    nil,
+		})
+		return
+	}
+
+	favorite.ID, err = s.favorites.CreateFavorite(favorite, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to save favorite.",
+			Data:    nil,
+		})
+		return
+	}
+
+	created, err := s.favorites.GetFavoriteByID(favorite.ID, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		created = &favorite
+	}
+
+	util.JSONResponse(w, http.StatusCreated, &models.Response{
+		Status:  "success",
+		Message: "Favorite saved successfully.",
+		Data:    created,
+	})
+}
+
+func (s *server) updateFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPut {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := favoriteID(w, r)
+	if !ok {
+		return
+	}
+
+	favorite, ok := favoriteFromBody(w, r)
+	if !ok {
+		return
+	}
+	favorite.ID = id
+
+	err := s.favorites.UpdateFavorite(&favorite, principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		util.JSONResponse(w, http.StatusNotFound, &models.Response{
+			Status:  "error",
+			Message: "Favorite not found with this ID.",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to update favorite.",
+			Data:    nil,
+		})
+		return
+	}
+
+	updated, err := s.favorites.GetFavoriteByID(id, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		updated = &favorite
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Favorite updated successfully.",
+		Data:    updated,
+	})
+}
+
+func (s *server) deleteFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodDelete {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := favoriteID(w, r)
+	if !ok {
+		return
+	}
+
+	affectedRows, err := s.favorites.DeleteFavorite(id, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to delete favorite.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if affectedRows == 0 {
+		util.JSONResponse(w, http.StatusNotFound, &models.Response{
+			Status:  "error",
+			Message: "Favorite not found with this ID.",
+			Data:    nil,
+		})
+		return
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Favorite deleted successfully.",
+		Data:    nil,
+	})
+}
+
+// reorderFavoritesHandler arranges the user's favorites in the order of the
+// ids in the body, which must list every favorite exactly once.
+func (s *server) reorderFavoritesHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPut {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		IDs []int `json:"ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid JSON provided.",
+			Data:    nil,
+		})
+		return
+	}
+
+	favorites, err := s.favorites.FetchFavorites(principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch favorites.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if !isPermutation(body.IDs, favorites) {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "The ids must list every favorite exactly once.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if err := s.favorites.ReorderFavorites(principal.UserID, body.IDs); err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to reorder favorites.",
+			Data:    nil,
+		})
+		return
+	}
+
+	favorites, err = s.favorites.FetchFavorites(principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch favorites.",
+			Data:    nil,
+		})
+		return
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Favorites reordered successfully.",
+		Data:    favorites,
+	})
+}
+
+// favoritesWeatherHandler fetches the current weather at all of the user's
+// favorites at once. A favorite whose lookup fails carries the error instead
+// of failing the whole request. These lookups are not added to the history.
+func (s *server) favoritesWeatherHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	prefs := s.preferences(principal)
+
+	opts, ok := weatherOptions(w, r, prefs)
+	if !ok {
+		return
+	}
+
+	units, ok := requestUnits(w, r, prefs)
+	if !ok {
+		return
+	}
+
+	favorites, err := s.favorites.FetchFavorites(principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch favorites.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if len(favorites) == 0 {
+		util.JSONResponse(w, http.StatusOK, &models.Response{
+			Status:  "info",
+			Message: "No favorites found.",
+			Data:    []models.FavoriteWeather{},
+		})
+		return
+	}
+
+	locs := make([]models.Location, len(favorites))
+	for i, favorite := range favorites {
+		locs[i] = favorite.Location
+	}
+
+	results := make([]models.FavoriteWeather, len(favorites))
+	for i, result := range s.fetchCurrentWeatherAll(locs, opts) {
+		results[i].Favorite = favorites[i]
+		if result.err != nil {
+			results[i].Error = lookupError(w, locs[i], result.err)
+			continue
+		}
+
+		result.weather.Raw = nil
+		util.ConvertWeather(result.weather, units)
+		results[i].Weather = result.weather
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Favorites weather fetched successfully.",
+		Data:    results,
+	})
+}
+
+// alertsHandler lists the user's alert rules on GET and creates a new one on
+// POST.
+func (s *server) alertsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	rules, err := s.alerts.FetchAlertRules(principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch alert rules.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if rules == nil {
+			rules = []models.AlertRule{}
+		}
+		util.JSONResponse(w, http.StatusOK, &models.Response{
+			Status:  "success",
+			Message: "Alert rules fetched successfully.",
+			Data:    rules,
+		})
+		return
+	}
+
+	rule, ok := s.alertRuleFromBody(w, r, s.preferences(principal))
+	if !ok {
+		return
+	}
+
+	if len(rules) >= maxAlertRules {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Too many alert rules, delete one first.",
+			Data:    nil,
+		})
+		return
+	}
+
+	rule.ID, err = s.alerts.CreateAlertRule(rule, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to save alert rule.",
+			Data:    nil,
+		})
+		return
+	}
+
+	created, err := s.alerts.GetAlertRuleByID(rule.ID, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		created = &rule
+	}
+
+	util.JSONResponse(w, http.StatusCreated, &models.Response{
+		Status:  "success",
+		Message: s.alertSavedMessage(principal, rule, "Alert rule saved successfully."),
+		Data:    created,
+	})
+}
+
+// updateAlertHandler replaces the definition of an alert rule. The rule starts
+// over as not active, keeping its notification history for the cooldown.
+func (s *server) updateAlertHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPut {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := alertID(w, r)
+	if !ok {
+		return
+	}
+
+	rule, ok := s.alertRuleFromBody(w, r, s.preferences(principal))
+	if !ok {
+		return
+	}
+	rule.ID = id
+
+	err := s.alerts.UpdateAlertRule(&rule, principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		util.JSONResponse(w, http.StatusNotFound, &models.Response{
+			Status:  "error",
+			Message: "Alert rule not found with this ID.",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to update alert rule.",
+			Data:    nil,
+		})
+		return
+	}
+
+	updated, err := s.alerts.GetAlertRuleByID(id, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		updated = &rule
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: s.alertSavedMessage(principal, rule, "Alert rule updated successfully."),
+		Data:    updated,
+	})
+}
+
+// confirmAlertEmailHandler confirms an alert email address with the token
+// mailed to it. It takes no access token, since the address need not belong
+// to the user who set up the alert.
+func (s *server) confirmAlertEmailHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid JSON provided.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if body.Token == "" {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Confirmation token is required.",
+			Data:    nil,
+		})
+		return
+	}
+
+	now := time.Now()
+	confirmed, err := s.alerts.ConfirmAlertEmailAddress(util.HashToken(body.Token), now.Add(-confirmationExpiry), now)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to confirm email address.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if confirmed == 0 {
+		util.JSONResponse(w, http.StatusNotFound, &models.Response{
+			Status:  "error",
+			Message: "Invalid or expired confirmation token.",
+			Data:    nil,
+		})
+		return
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Email address confirmed successfully.",
+		Data:    nil,
+	})
+}
+
+func (s *server) deleteAlertHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodDelete {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := alertID(w, r)
+	if !ok {
+		return
+	}
+
+	affectedRows, err := s.alerts.DeleteAlertRule(id, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to delete alert rule.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if affectedRows == 0 {
+		util.JSONResponse(w, http.StatusNotFound, &models.Response{
+			Status:  "error",
+			Message: "Alert rule not found with this ID.",
+			Data:    nil,
+		})
+		return
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Alert rule deleted successfully.",
+		Data:    nil,
+	})
+}
+
+// webhooksHandler lists the user's webhooks on GET and registers a new one on
+// POST. The signing secret is only returned when the webhook is registered.
+func (s *server) webhooksHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	hooks, err := s.webhooks.FetchWebhooks(principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch webhooks.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if hooks == nil {
+			hooks = []models.Webhook{}
+		}
+		for i := range hooks {
+			hooks[i].Secret = ""
+		}
+		util.JSONResponse(w, http.StatusOK, &models.Response{
+			Status:  "success",
+			Message: "Webhooks fetched successfully.",
+			Data:    hooks,
+		})
+		return
+	}
+
+	hook, ok := webhookFromBody(w, r)
+	if !ok {
+		return
+	}
+
+	if len(hooks) >= maxWebhooks {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Too many webhooks, delete one first.",
+			Data:    nil,
+		})
+		return
+	}
+
+	hook.Secret, err = util.GenerateSecret()
+	if err == nil {
+		hook.ID, err = s.webhooks.CreateWebhook(hook, principal.UserID)
+	}
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to save webhook.",
+			Data:    nil,
+		})
+		return
+	}
+
+	created, err := s.webhooks.GetWebhookByID(hook.ID, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		created = &hook
+	}
+
+	util.JSONResponse(w, http.StatusCreated, &models.Response{
+		Status:  "success",
+		Message: "Webhook saved successfully.",
+		Data:    created,
+	})
+}
+
+func (s *server) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodDelete {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	affectedRows, err := s.webhooks.DeleteWebhook(id, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to delete webhook.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if affectedRows == 0 {
+		util.JSONResponse(w, http.StatusNotFound, &models.Response{
+			Status:  "error",
+			Message: "Webhook not found with this ID.",
+			Data:    nil,
+		})
+		return
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Webhook deleted successfully.",
+		Data:    nil,
+	})
+}
+
+// webhookDeliveriesHandler lists the latest deliveries to one of the user's
+// webhooks, newest first.
+func (s *server) webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := webhookID(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 1 || limit > 100 {
+			util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+				Status:  "error",
+				Message: "Invalid limit, use a number between 1 and 100.",
+				Data:    nil,
+			})
+			return
+		}
+	}
+
+	_, err := s.webhooks.GetWebhookByID(id, principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		util.JSONResponse(w, http.StatusNotFound, &models.Response{
+			Status:  "error",
+			Message: "Webhook not found with this ID.",
+			Data:    nil,
+		})
+		return
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err == nil {
+		deliveries, err = s.webhooks.FetchWebhookDeliveries(id, principal.UserID, limit)
+	}
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch webhook deliveries.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if deliveries == nil {
+		deliveries = []models.WebhookDelivery{}
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Webhook deliveries fetched successfully.",
+		Data:    deliveries,
+	})
+}
+
+// redeliverWebhookHandler sends the payload of an earlier delivery to its
+// webhook again, as a new delivery.
+func (s *server) redeliverWebhookHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := deliveryID(w, r)
+	if !ok {
+		return
+	}
+
+	delivery, err := s.webhooks.GetWebhookDeliveryByID(id, principal.UserID)
+	var hook *models.Webhook
+	if err == nil {
+		hook, err = s.webhooks.GetWebhookByID(delivery.WebhookID, principal.UserID)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		util.JSONResponse(w, http.StatusNotFound, &models.Response{
+			Status:  "error",
+			Message: "Delivery not found with this ID.",
+			Data:    nil,
+		})
+		return
+	}
+
+	var redelivery *models.WebhookDelivery
+	if err == nil {
+		redelivery, err = s.dispatcher.enqueue(*hook, delivery.Event, delivery.Payload)
+	}
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to redeliver webhook.",
+			Data:    nil,
+		})
+		return
+	}
+
+	util.JSONResponse(w, http.StatusAccepted, &models.Response{
+		Status:  "success",
+		Message: "Redelivery scheduled.",
+		Data:    redelivery,
+	})
+}
+
+// schedulesHandler lists the user's schedules on GET and creates a new one on
+// POST. A new schedule runs on the next pass of the scheduler.
+func (s *server) schedulesHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	schedules, err := s.schedules.FetchSchedules(principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to fetch schedules.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if schedules == nil {
+			schedules = []models.PollSchedule{}
+		}
+		localizeSchedules(w, schedules)
+		util.JSONResponse(w, http.StatusOK, &models.Response{
+			Status:  "success",
+			Message: "Schedules fetched successfully.",
+			Data:    schedules,
+		})
+		return
+	}
+
+	schedule, ok := scheduleFromBody(w, r)
+	if !ok {
+		return
+	}
+
+	if len(schedules) >= maxSchedules {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Too many schedules, delete one first.",
+			Data:    nil,
+		})
+		return
+	}
+
+	schedule.NextRunAt = time.Now().UTC()
+	schedule.ID, err = s.schedules.CreateSchedule(schedule, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to save schedule.",
+			Data:    nil,
+		})
+		return
+	}
+
+	created, err := s.schedules.GetScheduleByID(schedule.ID, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		created = &schedule
+	}
+
+	util.JSONResponse(w, http.StatusCreated, &models.Response{
+		Status:  "success",
+		Message: "Schedule saved successfully.",
+		Data:    created,
+	})
+}
+
+// updateScheduleHandler changes the location, interval or enabled state of a
+// schedule, which then runs on the next pass of the scheduler.
+func (s *server) updateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPut {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := scheduleID(w, r)
+	if !ok {
+		return
+	}
+
+	schedule, ok := scheduleFromBody(w, r)
+	if !ok {
+		return
+	}
+	schedule.ID = id
+	schedule.NextRunAt = time.Now().UTC()
+
+	err := s.schedules.UpdateSchedule(&schedule, principal.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		util.JSONResponse(w, http.StatusNotFound, &models.Response{
+			Status:  "error",
+			Message: "Schedule not found with this ID.",
+			Data:    nil,
+		})
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to update schedule.",
+			Data:    nil,
+		})
+		return
+	}
+
+	updated, err := s.schedules.GetScheduleByID(id, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		updated = &schedule
+	}
+	if updated.LastError != "" {
+		updated.LastError = localize(w, updated.LastError)
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Schedule updated successfully.",
+		Data:    updated,
+	})
+}
+
+func (s *server) deleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodDelete {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	id, ok := scheduleID(w, r)
+	if !ok {
+		return
+	}
+
+	affectedRows, err := s.schedules.DeleteSchedule(id, principal.UserID)
+	if err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to delete schedule.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if affectedRows == 0 {
+		util.JSONResponse(w, http.StatusNotFound, &models.Response{
+			Status:  "error",
+			Message: "Schedule not found with this ID.",
+			Data:    nil,
+		})
+		return
+	}
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Schedule deleted successfully.",
+		Data:    nil,
+	})
+}
+
+func (s *server) unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		util.JSONResponse(w, http.StatusMethodNotAllowed, &models.Response{
+			Status:  "error",
+			Message: "Method not allowed.",
+			Data:    nil,
+		})
+		return
+	}
+
+	principal, ok := requirePrincipal(w, r)
+	if !ok {
+		return
+	}
+
+	if !principal.HasRole(roleAdmin) {
+		util.JSONResponse(w, http.StatusForbidden, &models.Response{
+			Status:  "error",
+			Message: "Admin role required.",
+			Data:    nil,
+		})
+		return
+	}
+
+	var body struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid JSON provided.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if body.Username == "" {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Username is required.",
+			Data:    nil,
+		})
+		return
+	}
+
+	if _, err := s.users.GetUserByUsername(body.Username); err != nil {
+		if err == sql.ErrNoRows {
+			util.JSONResponse(w, http.StatusNotFound, &models.Response{
+				Status:  "error",
+				Message: "User not found.",
+				Data:    nil,
+			})
+			return
+		}
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Internal server error.",
+			Data:    nil,
+		})
+		return
+	}
+
+	// The unlock event resets the failures counted against the username, per
+	// IP and for the whole account; those counted against client IPs still
+	// expire on their own.
+	unlock := models.LoginAttempt{
+		Username:  strings.ToLower(body.Username),
+		IP:        util.ClientIP(r),
+		Event:     loginUnlocked,
+		CreatedAt: time.Now(),
+	}
+	if _, err := s.logins.RecordLoginAttempt(unlock); err != nil {
+		log.Error(err)
+		util.JSONResponse(w, http.StatusInternalServerError, &models.Response{
+			Status:  "error",
+			Message: "Failed to unlock account.",
+			Data:    nil,
+		})
+		return
+	}
+	log.Infof("%s unlocked the account %s", principal.Username, body.Username)
+
+	util.JSONResponse(w, http.StatusOK, &models.Response{
+		Status:  "success",
+		Message: "Account unlocked successfully.",
+		Data:    nil,
+	})
 }
@@ -0,0 +1,1325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/KunalDuran/weather-api/cache"
+	"github.com/KunalDuran/weather-api/data"
+	"github.com/KunalDuran/weather-api/i18n"
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/notify"
+	"github.com/KunalDuran/weather-api/provider"
+	"github.com/KunalDuran/weather-api/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProvider answers every lookup with canned conditions for the requested
+// city, and reports cities listed in missing as unknown. While rateLimited is
+// set every lookup fails as if the upstream quota was used up.
+type fakeProvider struct {
+	mu          sync.Mutex
+	calls       int
+	missing     map[string]bool
+	lang        string
+	rateLimited bool
+}
+
+func (f *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *fakeProvider) weather(name string) (*models.WeatherResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls++
+	if f.rateLimited {
+		return nil, provider.ErrRateLimited
+	}
+	if f.missing[strings.ToLower(name)] {
+		return nil, provider.ErrNotFound
+	}
+	weather := &models.WeatherResponse{Name: name}
+	weather.Weathers = []models.Weather{{ID: 800, Main: "Clear", Description: "clear sky", Icon: "01d"}}
+	weather.Main.Temp = 300
+	weather.Sys.Country = "IN"
+	weather.Raw = json.RawMessage(`{"name":"` + name + `","rain":{"1h":0.5}}`)
+	return weather, nil
+}
+
+func (f *fakeProvider) forecast(name string) (*models.ForecastResponse, error) {
+	f.calls++
+	if f.missing[strings.ToLower(name)] {
+		return nil, provider.ErrNotFound
+	}
+	forecast := &models.ForecastResponse{}
+	forecast.City.Name = name
+	return forecast, nil
+}
+
+func (f *fakeProvider) CurrentByCity(city string, opts provider.Options) (*models.WeatherResponse, error) {
+	f.mu.Lock()
+	f.lang = opts.Lang
+	f.mu.Unlock()
+	return f.weather(city)
+}
+
+func (f *fakeProvider) CurrentByCoordinates(lat, lon float64, opts provider.Options) (*models.WeatherResponse, error) {
+	return f.weather("Coordinates")
+}
+
+func (f *fakeProvider) CurrentByCityID(id int, opts provider.Options) (*models.WeatherResponse, error) {
+	return f.weather("City ID")
+}
+
+func (f *fakeProvider) CurrentByZip(zip, country string, opts provider.Options) (*models.WeatherResponse, error) {
+	return f.weather("Zip")
+}
+
+func (f *fakeProvider) ForecastByCity(city string, opts provider.Options) (*models.ForecastResponse, error) {
+	return f.forecast(city)
+}
+
+func (f *fakeProvider) ForecastByCoordinates(lat, lon float64, opts provider.Options) (*models.ForecastResponse, error) {
+	return f.forecast("Coordinates")
+}
+
+func (f *fakeProvider) ForecastByCityID(id int, opts provider.Options) (*models.ForecastResponse, error) {
+	return f.forecast("City ID")
+}
+
+func (f *fakeProvider) ForecastByZip(zip, country string, opts provider.Options) (*models.ForecastResponse, error) {
+	return f.forecast("Zip")
+}
+
+type testServer struct {
+	*server
+	store    *data.MemoryStore
+	provider *fakeProvider
+	handler  http.Handler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	store := data.NewMemoryStore()
+	p := &fakeProvider{missing: map[string]bool{"atlantis": true}}
+	srv := newServer(store, p)
+	// Test webhook receivers listen on loopback, which the default client
+	// refuses to connect to.
+	srv.dispatcher.client = &http.Client{Timeout: 10 * time.Second}
+	return &testServer{server: srv, store: store, provider: p, handler: srv.routes()}
+}
+
+// deliverWebhooks attempts the due webhook deliveries in place of the worker,
+// which tests do not start, moving the clock on far enough for every retry.
+func (ts *testServer) deliverWebhooks(t *testing.T) {
+	now := time.Now()
+	for i := 0; i < ts.dispatcher.maxAttempts; i++ {
+		// Deliveries to a webhook are sent one at a time
+		for {
+			require.NoError(t, ts.dispatcher.deliverDue(now))
+			ts.dispatcher.wait()
+			due, err := ts.store.FetchDueWebhookDeliveries(now, nil, 1)
+			require.NoError(t, err)
+			if len(due) == 0 {
+				break
+			}
+		}
+		now = now.Add(time.Minute)
+	}
+}
+
+// do sends a request with an optional JSON body and bearer token and decodes the response envelope.
+func (ts *testServer) do(t *testing.T, method, path, token string, body interface{}) (*httptest.ResponseRecorder, models.Response) {
+	var reader bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&reader).Encode(body))
+	}
+
+	req := httptest.NewRequest(method, path, &reader)
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+
+	var resp models.Response
+	if rec.Body.Len() > 0 {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	}
+	return rec, resp
+}
+
+// register creates an account and returns its login response data.
+func (ts *testServer) register(t *testing.T, username string) map[string]interface{} {
+	rec, resp := ts.do(t, http.MethodPost, "/api/register", "", map[string]string{
+		"username":   username,
+		"password":   "Password12",
+		"birth_date": "1990-05-15",
+	})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	return resp.Data.(map[string]interface{})
+}
+
+func (ts *testServer) token(t *testing.T, username string) string {
+	return ts.register(t, username)["token"].(string)
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	ts := newTestServer(t)
+	ts.register(t, "user@example.com")
+
+	rec, resp := ts.do(t, http.MethodPost, "/api/register", "", map[string]string{
+		"username":   "user@example.com",
+		"password":   "Password12",
+		"birth_date": "1990-05-15",
+	})
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, "Username already exists.", resp.Message)
+
+	rec, _ = ts.do(t, http.MethodPost, "/api/login", "", map[string]string{"username": "user@example.com", "password": "Wrong1234"})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec, resp = ts.do(t, http.MethodPost, "/api/login", "", map[string]string{"username": "user@example.com", "password": "Password12"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	tokens := resp.Data.(map[string]interface{})
+	assert.NotEmpty(t, tokens["token"])
+	assert.NotEmpty(t, tokens["refresh_token"])
+}
+
+func TestProtectedEndpointsRequireToken(t *testing.T) {
+	ts := newTestServer(t)
+
+	rec, _ := ts.do(t, http.MethodGet, "/api/history", "", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec, _ = ts.do(t, http.MethodGet, "/api/history", "not-a-token", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestWeatherIsCachedAndRecorded(t *testing.T) {
+	ts := newTestServer(t)
+	ts.cache = cache.NewLRU(10)
+	token := ts.token(t, "user@example.com")
+
+	rec, resp := ts.do(t, http.MethodGet, "/api/weather?city=Pune", token, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "Pune", resp.Data.(map[string]interface{})["name"])
+
+	rec, _ = ts.do(t, http.MethodGet, "/api/weather?city=pune", token, nil)
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, 1, ts.provider.calls)
+
+	rec, resp = ts.do(t, http.MethodGet, "/api/weather?city=Atlantis", token, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "City not found.", resp.Message)
+
+	// The forecast reports unknown locations the same way
+	rec, resp = ts.do(t, http.MethodGet, "/api/forecast?city=Atlantis", token, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "City not found.", resp.Message)
+
+	rec, _ = ts.do(t, http.MethodGet, "/api/weather?lat=91&lon=0", token, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, resp = ts.do(t, http.MethodGet, "/api/history", token, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	page := resp.Data.(map[string]interface{})
+	assert.Equal(t, float64(2), page["total_count"])
+}
+
+func TestHistoryIncludesRawPayloadOnRequest(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.token(t, "user@example.com")
+
+	_, resp := ts.do(t, http.MethodGet, "/api/weather?city=Pune", token, nil)
+	weather := resp.Data.(map[string]interface{})
+	assert.NotContains(t, weather, "raw")
+	assert.Equal(t, "fake", weather["provider"])
+
+	_, resp = ts.do(t, http.MethodGet, "/api/history", token, nil)
+	item := resp.Data.(map[string]interface{})["items"].([]interface{})[0].(map[string]interface{})
+	assert.NotContains(t, item, "raw")
+	assert.Equal(t, "fake", item["provider"])
+
+	_, resp = ts.do(t, http.MethodGet, "/api/history?include=raw", token, nil)
+	item = resp.Data.(map[string]interface{})["items"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"name": "Pune", "rain": map[string]interface{}{"1h": 0.5}}, item["raw"])
+
+	rec, _ := ts.do(t, http.MethodGet, "/api/history?include=secrets", token, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestUnitsFollowQueryAndPreference(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.token(t, "user@example.com")
+
+	_, resp := ts.do(t, http.MethodGet, "/api/weather?city=Pune", token, nil)
+	weather := resp.Data.(map[string]interface{})
+	assert.Equal(t, 300.0, weather["main"].(map[string]interface{})["temp"])
+	assert.Equal(t, "K", weather["units"].(map[string]interface{})["temperature"])
+
+	_, resp = ts.do(t, http.MethodGet, "/api/weather?city=Pune&units=metric", token, nil)
+	weather = resp.Data.(map[string]interface{})
+	assert.Equal(t, 26.85, weather["main"].(map[string]interface{})["temp"])
+	assert.Equal(t, "°C", weather["units"].(map[string]interface{})["temperature"])
+
+	rec, _ := ts.do(t, http.MethodGet, "/api/weather?city=Pune&units=kelvin", token, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, resp = ts.do(t, http.MethodPut, "/api/preferences", token, map[string]string{"units": "imperial"})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	_, resp = ts.do(t, http.MethodGet, "/api/preferences", token, nil)
+	assert.Equal(t, "imperial", resp.Data.(map[string]interface{})["units"])
+
+	// History is stored in standard units and converted like fresh lookups,
+	// including the temperature filters
+	_, resp = ts.do(t, http.MethodGet, "/api/history?min_temp=80", token, nil)
+	page := resp.Data.(map[string]interface{})
+	assert.Equal(t, float64(2), page["total_count"])
+	item := page["items"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, 80.33, item["main"].(map[string]interface{})["temp"])
+	assert.Equal(t, "mph", item["units"].(map[string]interface{})["wind_speed"])
+
+	_, resp = ts.do(t, http.MethodGet, "/api/history?units=metric&min_temp=27", token, nil)
+	assert.Equal(t, float64(0), resp.Data.(map[string]interface{})["total_count"])
+
+	rec, _ = ts.do(t, http.MethodPut, "/api/preferences", token, map[string]string{"units": "kelvin"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestLanguageOfMessagesAndLookups(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.token(t, "user@example.com")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/weather?city=Pune", nil)
+	req.Header.Set("Accept-Language", "de-DE,de;q=0.9,en;q=0.8")
+	rec := httptest.NewRecorder()
+	ts.handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "de", rec.Header().Get("Content-Language"))
+	assert.Contains(t, rec.Body.String(), "Kein Token angegeben")
+
+	_, resp := ts.do(t, http.MethodGet, "/api/weather?city=Pune&lang=es", token, nil)
+	assert.Equal(t, "Clima obtenido correctamente", resp.Message)
+	assert.Equal(t, "es", ts.provider.lang)
+
+	// Languages without a catalog still reach the provider
+	_, resp = ts.do(t, http.MethodGet, "/api/weather?city=Pune&lang=zh_cn", token, nil)
+	assert.Equal(t, "Weather fetched successfully", resp.Message)
+	assert.Equal(t, "zh_cn", ts.provider.lang)
+
+	rec, _ = ts.do(t, http.MethodGet, "/api/weather?city=Pune&lang=klingon", token, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, resp = ts.do(t, http.MethodPut, "/api/preferences", token, map[string]string{"lang": "hi"})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	assert.Equal(t, "standard", resp.Data.(map[string]interface{})["units"])
+
+	_, resp = ts.do(t, http.MethodGet, "/api/weather?city=Pune", token, nil)
+	assert.Equal(t, "hi", ts.provider.lang)
+	assert.Equal(t, "Weather fetched successfully", resp.Message)
+}
+
+func TestHistoryPagination(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.token(t, "user@example.com")
+
+	for _, city := range []string{"Pune", "Delhi", "Mumbai"} {
+		rec, _ := ts.do(t, http.MethodGet, "/api/weather?city="+city, token, nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	var cities []string
+	path := "/api/history?limit=2&sort=city&order=asc"
+	for path != "" {
+		rec, resp := ts.do(t, http.MethodGet, path, token, nil)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		page := resp.Data.(map[string]interface{})
+		assert.Equal(t, float64(3), page["total_count"])
+		for _, item := range page["items"].([]interface{}) {
+			cities = append(cities, item.(map[string]interface{})["name"].(string))
+		}
+
+		path = ""
+		if cursor, ok := page["next_cursor"].(string); ok {
+			path = "/api/history?limit=2&sort=city&order=asc&cursor=" + cursor
+		}
+	}
+	assert.Equal(t, []string{"Delhi", "Mumbai", "Pune"}, cities)
+
+	rec, _ := ts.do(t, http.MethodGet, "/api/history?cursor=garbage", token, nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// A cursor only continues the query it was issued for
+	_, resp := ts.do(t, http.MethodGet, "/api/history?limit=2&sort=city&order=asc&country=IN", token, nil)
+	cursor := resp.Data.(map[string]interface{})["next_cursor"].(string)
+	for _, path := range []string{
+		"/api/history?limit=2&sort=city&order=desc&country=IN&cursor=",
+		"/api/history?limit=2&sort=temp&order=asc&country=IN&cursor=",
+		"/api/history?limit=2&sort=city&order=asc&cursor=",
+		"/api/history?limit=2&sort=city&order=asc&country=IN&min_temp=0&cursor=",
+	} {
+		rec, _ = ts.do(t, http.MethodGet, path+cursor, token, nil)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, path)
+	}
+	rec, _ = ts.do(t, http.MethodGet, "/api/history?limit=5&sort=city&order=asc&country=IN&cursor="+cursor, token, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestUsersCannotDeleteEachOthersHistory(t *testing.T) {
+	ts := newTestServer(t)
+	owner := ts.token(t, "owner@example.com")
+	intruder := ts.token(t, "intruder@example.com")
+
+	_, resp := ts.do(t, http.MethodGet, "/api/weather?city=Pune", owner, nil)
+	weatherID := int(resp.Data.(map[string]interface{})["weather_id"].(float64))
+	path := "/api/history/delete?weatherID=" + strconv.Itoa(weatherID)
+
+	rec, _ := ts.do(t, http.MethodDelete, path, intruder, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec, _ = ts.do(t, http.MethodDelete, "/api/history/bulkdelete", intruder, nil)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	_, resp = ts.do(t, http.MethodGet, "/api/history", intruder, nil)
+	assert.Equal(t, float64(0), resp.Data.(map[string]interface{})["total_count"])
+
+	rec, _ = ts.do(t, http.MethodDelete, path, owner, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ts := newTestServer(t)
+	tokens := ts.register(t, "user@example.com")
+	refreshToken := tokens["refresh_token"].(string)
+
+	rec, resp := ts.do(t, http.MethodPost, "/api/token/refresh", "", map[string]string{"refresh_token": refreshToken})
+	require.Equal(t, http.StatusOK, rec.Code)
+	rotated := resp.Data.(map[string]interface{})
+	assert.NotEqual(t, refreshToken, rotated["refresh_token"])
+
+	// The old refresh token cannot be used twice
+	rec, _ = ts.do(t, http.MethodPost, "/api/token/refresh", "", map[string]string{"refresh_token": refreshToken})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	token := rotated["token"].(string)
+	rec, resp = ts.do(t, http.MethodPost, "/api/login", "", map[string]string{"username": "user@example.com", "password": "Password12", "device": "laptop"})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	laptop := resp.Data.(map[string]interface{})["token"].(string)
+
+	_, resp = ts.do(t, http.MethodGet, "/api/sessions", token, nil)
+	sessions := resp.Data.([]interface{})
+	require.Len(t, sessions, 2)
+
+	// Revoking a session from another device ends its access token as well
+	sessionID := int(rotated["session_id"].(float64))
+	rec, _ = ts.do(t, http.MethodDelete, "/api/sessions/revoke?sessionID="+strconv.Itoa(sessionID), laptop, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec, _ = ts.do(t, http.MethodPost, "/api/token/refresh", "", map[string]string{"refresh_token": rotated["refresh_token"].(string)})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec, resp = ts.do(t, http.MethodGet, "/api/sessions", token, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Token has been revoked", resp.Message)
+
+	_, resp = ts.do(t, http.MethodGet, "/api/sessions", laptop, nil)
+	assert.Len(t, resp.Data, 1)
+}
+
+func TestLogoutRevokesToken(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.token(t, "user@example.com")
+
+	rec, _ := ts.do(t, http.MethodGet, "/api/history", token, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec, _ = ts.do(t, http.MethodPost, "/api/logout", token, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec, resp := ts.do(t, http.MethodGet, "/api/history", token, nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Token has been revoked", resp.Message)
+}
+
+func TestFavorites(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.token(t, "user@example.com")
+	other := ts.token(t, "other@example.com")
+
+	rec, resp := ts.do(t, http.MethodPost, "/api/favorites", token, map[string]interface{}{"label": "Home", "city": "Pune"})
+	require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+	homeID := int(resp.Data.(map[string]interface{})["id"].(float64))
+
+	rec, resp = ts.do(t, http.MethodPost, "/api/favorites", token, map[string]interface{}{"label": "Lost", "city": "Atlantis"})
+	require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+	lostID := int(resp.Data.(map[string]interface{})["id"].(float64))
+
+	rec, _ = ts.do(t, http.MethodPost, "/api/favorites", token, map[string]interface{}{"city": "Pune"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec, _ = ts.do(t, http.MethodPost, "/api/favorites", token, map[string]interface{}{"label": "Both", "city": "Pune", "zip": "411001"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, _ = ts.do(t, http.MethodPut, "/api/favorites/reorder", token, map[string]interface{}{"ids": []int{lostID}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec, resp = ts.do(t, http.MethodPut, "/api/favorites/reorder", token, map[string]interface{}{"ids": []int{lostID, homeID}})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+
+	rec, resp = ts.do(t, http.MethodPut, "/api/favorites/update?favoriteID="+strconv.Itoa(homeID), token, map[string]interface{}{"label": "Home town", "city": "Pune"})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	assert.Equal(t, "Home town", resp.Data.(map[string]interface{})["label"])
+
+	// Favorites of other users cannot be seen, changed or deleted
+	_, resp = ts.do(t, http.MethodGet, "/api/favorites", other, nil)
+	assert.Empty(t, resp.Data)
+	rec, _ = ts.do(t, http.MethodPut, "/api/favorites/update?favoriteID="+strconv.Itoa(homeID), other, map[string]interface{}{"label": "Mine", "city": "Pune"})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec, _ = ts.do(t, http.MethodDelete, "/api/favorites/delete?favoriteID="+strconv.Itoa(homeID), other, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec, resp = ts.do(t, http.MethodGet, "/api/favorites/weather?units=metric", token, nil)
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	results := resp.Data.([]interface{})
+	require.Len(t, results, 2)
+
+	lost := results[0].(map[string]interface{})
+	assert.Equal(t, "Lost", lost["favorite"].(map[string]interface{})["label"])
+	assert.Nil(t, lost["weather"])
+	assert.Equal(t, "City not found.", lost["error"])
+
+	home := results[1].(map[string]interface{})
+	assert.Equal(t, "Home town", home["favorite"].(map[string]interface{})["label"])
+	assert.Equal(t, "Pune", home["weather"].(map[string]interface{})["name"])
+	assert.Equal(t, 26.85, home["weather"].(map[string]interface{})["main"].(map[string]interface{})["temp"])
+	assert.NotContains(t, home, "error")
+
+	rec, _ = ts.do(t, http.MethodDelete, "/api/favorites/delete?favoriteID="+strconv.Itoa(lostID), token, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	_, resp = ts.do(t, http.MethodGet, "/api/favorites", token, nil)
+	assert.Len(t, resp.Data, 1)
+}
+
+func TestBatchWeather(t *testing.T) {
+	ts := newTestServer(t)
+	ts.lookupConcurrency = 2
+	token := ts.token(t, "user@example.com")
+
+	rec, resp := ts.do(t, http.MethodPost, "/api/weather/batch?units=metric", token, map[string]interface{}{
+		"locations": []map[string]interface{}{
+			{"city": "Pune"},
+			{"city": "Atlantis"},
+			{"lat": 91, "lon": 0},
+			{"city": "Delhi"},
+		},
+	})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+
+	batch := resp.Data.(map[string]interface{})
+	assert.Equal(t, float64(2), batch["succeeded"])
+	assert.Equal(t, float64(2), batch["failed"])
+
+	items := batch["items"].([]interface{})
+	require.Len(t, items, 4)
+	pune := items[0].(map[string]interface{})["weather"].(map[string]interface{})
+	assert.Equal(t, "Pune", pune["name"])
+	assert.Equal(t, 26.85, pune["main"].(map[string]interface{})["temp"])
+	assert.NotZero(t, pune["weather_id"])
+	assert.Equal(t, "City not found.", items[1].(map[string]interface{})["error"])
+	assert.Equal(t, "Latitude must be between -90 and 90.", items[2].(map[string]interface{})["error"])
+	assert.Nil(t, items[2].(map[string]interface{})["weather"])
+	assert.Equal(t, "Delhi", items[3].(map[string]interface{})["weather"].(map[string]interface{})["name"])
+
+	// Only the successful lookups are recorded
+	_, resp = ts.do(t, http.MethodGet, "/api/history", token, nil)
+	assert.Equal(t, float64(2), resp.Data.(map[string]interface{})["total_count"])
+
+	rec, _ = ts.do(t, http.MethodPost, "/api/weather/batch", token, map[string]interface{}{"locations": []interface{}{}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// The limit is part of the message, which has to be translated for it
+	locations := make([]map[string]interface{}, maxBatchLocations+1)
+	for i := range locations {
+		locations[i] = map[string]interface{}{"city": "Pune"}
+	}
+	message := fmt.Sprintf("Provide between 1 and %d locations.", maxBatchLocations)
+	rec, resp = ts.do(t, http.MethodPost, "/api/weather/batch?lang=de", token, map[string]interface{}{"locations": locations})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.NotEqual(t, message, resp.Message)
+	assert.Equal(t, i18n.Translate("de", message), resp.Message)
+
+	// Lookups that cannot be recorded are reported as failed
+	ts.history = failingHistory{ts.store}
+	rec, resp = ts.do(t, http.MethodPost, "/api/weather/batch", token, map[string]interface{}{
+		"locations": []map[string]interface{}{{"city": "Pune"}, {"city": "Atlantis"}},
+	})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	batch = resp.Data.(map[string]interface{})
+	assert.Equal(t, float64(0), batch["succeeded"])
+	assert.Equal(t, float64(2), batch["failed"])
+	items = batch["items"].([]interface{})
+	assert.Nil(t, items[0].(map[string]interface{})["weather"])
+	assert.Equal(t, "Failed to record weather.", items[0].(map[string]interface{})["error"])
+	assert.Equal(t, "City not found.", items[1].(map[string]interface{})["error"])
+}
+
+// failingHistory fails to record any weather.
+type failingHistory struct {
+	data.HistoryStore
+}
+
+func (failingHistory) InsertWeatherHistoryBatch(weathers []models.WeatherResponse, userID int) ([]int, error) {
+	return nil, errors.New("database is unavailable")
+}
+
+// fakeNotifier records the notifications and confirmations it is asked to
+// send.
+type fakeNotifier struct {
+	sent          []notify.Notification
+	confirmations map[string][]notify.Confirmation
+	// err, when set, fails every notification.
+	err error
+}
+
+func (f *fakeNotifier) Notify(target string, n notify.Notification) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+func (f *fakeNotifier) Confirm(target string, c notify.Confirmation) error {
+	if f.confirmations == nil {
+		f.confirmations = make(map[string][]notify.Confirmation)
+	}
+	f.confirmations[target] = append(f.confirmations[target], c)
+	return nil
+}
+
+func TestAlertRules(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.token(t, "user@example.com")
+	other := ts.token(t, "other@example.com")
+
+	rec, resp := ts.do(t, http.MethodPost, "/api/alerts", token, map[string]interface{}{
+		"name": "Hot", "city": "Pune", "temp_above": 30, "units": "metric",
+		"channel": "webhook", "target": "https://example.com/hook",
+	})
+	require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+	rule := resp.Data.(map[string]interface{})
+	id := strconv.Itoa(int(rule["id"].(float64)))
+	assert.Equal(t, float64(60), rule["cooldown_minutes"])
+	assert.Equal(t, true, rule["enabled"])
+
+	for _, body := range []map[string]interface{}{
+		{"name": "No criteria", "city": "Pune", "channel": "webhook", "target": "https://example.com/hook"},
+		{"name": "Bad URL", "city": "Pune", "condition": "Rain", "channel": "webhook", "target": "example.com"},
+		{"name": "No SMTP", "city": "Pune", "condition": "Rain", "channel": "email", "target": "user@example.com"},
+		{"name": "Cooldown", "city": "Pune", "condition": "Rain", "channel": "webhook", "target": "https://example.com/hook", "cooldown_minutes": 0},
+	} {
+		rec, _ = ts.do(t, http.MethodPost, "/api/alerts", token, body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body["name"])
+	}
+
+	rec, resp = ts.do(t, http.MethodPut, "/api/alerts/update?alertID="+id, token, map[string]interface{}{
+		"name": "Rain", "city": "Pune", "condition": "rain", "enabled": false,
+		"channel": "webhook", "target": "https://example.com/hook",
+	})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	assert.Equal(t, "rain", resp.Data.(map[string]interface{})["condition"])
+	assert.Equal(t, false, resp.Data.(map[string]interface{})["enabled"])
+
+	// Alert rules of other users cannot be seen, changed or deleted
+	_, resp = ts.do(t, http.MethodGet, "/api/alerts", other, nil)
+	assert.Empty(t, resp.Data)
+	rec, _ = ts.do(t, http.MethodPut, "/api/alerts/update?alertID="+id, other, map[string]interface{}{
+		"name": "Mine", "city": "Pune", "condition": "Rain", "channel": "webhook", "target": "https://example.com/hook",
+	})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec, _ = ts.do(t, http.MethodDelete, "/api/alerts/delete?alertID="+id, other, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec, _ = ts.do(t, http.MethodDelete, "/api/alerts/delete?alertID="+id, token, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	_, resp = ts.do(t, http.MethodGet, "/api/alerts", token, nil)
+	assert.Empty(t, resp.Data)
+}
+
+func TestAlertEvaluation(t *testing.T) {
+	ts := newTestServer(t)
+	notifier := &fakeNotifier{}
+	ts.notifiers[notify.ChannelWebhook] = notifier
+	token := ts.token(t, "user@example.com")
+
+	create := func(body map[string]interface{}) int {
+		body["city"] = "Pune"
+		body["channel"] = "webhook"
+		body["target"] = "https://example.com/hook"
+		rec, resp := ts.do(t, http.MethodPost, "/api/alerts", token, body)
+		require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+		return int(resp.Data.(map[string]interface{})["id"].(float64))
+	}
+	warm := create(map[string]interface{}{"name": "Warm", "temp_above": 25, "units": "metric"})
+	create(map[string]interface{}{"name": "Cold", "temp_below": 0, "units": "metric"})
+
+	// The fake provider reports 300 K, 26.85 °C, and clear skies
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, ts.evaluateAlerts(now))
+	require.Len(t, notifier.sent, 1)
+	assert.Equal(t, "Warm", notifier.sent[0].RuleName)
+	assert.Equal(t, []string{"Temperature 26.85 °C is above 25 °C"}, notifier.sent[0].Reasons)
+	assert.Equal(t, 26.85, notifier.sent[0].Weather.Main.Temp)
+	assert.Equal(t, 1, ts.provider.calls, "both rules watch Pune, which is fetched once")
+
+	// A rule that keeps matching notifies once
+	require.NoError(t, ts.evaluateAlerts(now.Add(2*time.Hour)))
+	assert.Len(t, notifier.sent, 1)
+
+	// A rule that matches again within its cooldown waits for it to end
+	require.NoError(t, ts.store.SetAlertRuleActive(warm, false))
+	require.NoError(t, ts.evaluateAlerts(now.Add(30*time.Minute)))
+	assert.Len(t, notifier.sent, 1)
+	require.NoError(t, ts.evaluateAlerts(now.Add(61*time.Minute)))
+	assert.Len(t, notifier.sent, 2)
+
+	rule, err := ts.store.GetAlertRuleByID(warm, 1)
+	require.NoError(t, err)
+	assert.True(t, rule.Active)
+	assert.Equal(t, 2, rule.NotificationCount)
+
+	// A notification that fails is retried on the next evaluation, not once
+	// the cooldown is over
+	require.NoError(t, ts.store.SetAlertRuleActive(warm, false))
+	notifier.err = errors.New("receiver is down")
+	require.NoError(t, ts.evaluateAlerts(now.Add(3*time.Hour)))
+	rule, err = ts.store.GetAlertRuleByID(warm, 1)
+	require.NoError(t, err)
+	assert.False(t, rule.Active)
+	assert.Equal(t, 2, rule.NotificationCount)
+	assert.Equal(t, now.Add(61*time.Minute), *rule.LastNotifiedAt)
+
+	notifier.err = nil
+	require.NoError(t, ts.evaluateAlerts(now.Add(3*time.Hour+5*time.Minute)))
+	assert.Len(t, notifier.sent, 3)
+}
+
+func TestAlertEmailConfirmation(t *testing.T) {
+	ts := newTestServer(t)
+	notifier := &fakeNotifier{}
+	ts.notifiers[notify.ChannelEmail] = notifier
+	token := ts.token(t, "user@example.com")
+
+	create := func(name string) string {
+		rec, resp := ts.do(t, http.MethodPost, "/api/alerts", token, map[string]interface{}{
+			"name": name, "city": "Pune", "temp_above": 25, "units": "metric",
+			"channel": "email", "target": "Team@example.com",
+		})
+		require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+		return resp.Message
+	}
+
+	// Nothing but one confirmation is mailed to an address until it is
+	// confirmed, however many rules target it
+	assert.Equal(t, "Alert rule saved, confirm the email address to receive its notifications.", create("Warm"))
+	assert.Equal(t, "Alert rule saved, confirm the email address to receive its notifications.", create("Hot"))
+	require.Len(t, notifier.confirmations["team@example.com"], 1)
+	confirmation := notifier.confirmations["team@example.com"][0]
+	assert.Equal(t, "user@example.com", confirmation.Username)
+	assert.Equal(t, "http://localhost:8080/api/alerts/confirm", confirmation.URL)
+
+	now := time.Now()
+	require.NoError(t, ts.evaluateAlerts(now))
+	assert.Empty(t, notifier.sent)
+
+	rec, resp := ts.do(t, http.MethodPost, "/api/alerts/confirm", "", map[string]string{"token": "wrong"})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "Invalid or expired confirmation token.", resp.Message)
+	rec, _ = ts.do(t, http.MethodPost, "/api/alerts/confirm", "", map[string]string{})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec, resp = ts.do(t, http.MethodPost, "/api/alerts/confirm", "", map[string]string{"token": confirmation.Token})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	assert.Equal(t, "Email address confirmed successfully.", resp.Message)
+	rec, _ = ts.do(t, http.MethodPost, "/api/alerts/confirm", "", map[string]string{"token": confirmation.Token})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	require.NoError(t, ts.evaluateAlerts(now.Add(time.Minute)))
+	assert.Len(t, notifier.sent, 2)
+
+	assert.Equal(t, "Alert rule saved successfully.", create("Warmer"))
+	assert.Len(t, notifier.confirmations["team@example.com"], 1)
+
+	// Another user has to have the address confirmed on their own
+	rec, resp = ts.do(t, http.MethodPost, "/api/alerts", ts.token(t, "other@example.com"), map[string]interface{}{
+		"name": "Warm", "city": "Pune", "temp_above": 25,
+		"channel": "email", "target": "team@example.com",
+	})
+	require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+	assert.Equal(t, "Alert rule saved, confirm the email address to receive its notifications.", resp.Message)
+	assert.Len(t, notifier.confirmations["team@example.com"], 2)
+}
+
+func TestAlertEvaluationBudget(t *testing.T) {
+	ts := newTestServer(t)
+	notifier := &fakeNotifier{}
+	ts.notifiers[notify.ChannelWebhook] = notifier
+	token := ts.token(t, "user@example.com")
+
+	for _, city := range []string{"Pune", "Delhi", "Mumbai"} {
+		rec, resp := ts.do(t, http.MethodPost, "/api/alerts", token, map[string]interface{}{
+			"name": city, "city": city, "temp_above": 25, "units": "metric",
+			"channel": "webhook", "target": "https://example.com/hook",
+		})
+		require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+	}
+
+	// Over budget, the locations take turns
+	ts.pollBudget = 2
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, ts.evaluateAlerts(now))
+	assert.Equal(t, 2, ts.provider.calls)
+	require.Len(t, notifier.sent, 2)
+	require.NoError(t, ts.evaluateAlerts(now.Add(5*time.Minute)))
+	assert.Equal(t, 4, ts.provider.calls)
+	require.Len(t, notifier.sent, 3)
+	assert.Equal(t, "Mumbai", notifier.sent[2].RuleName)
+
+	// Running out of upstream quota pauses evaluation, and polling with it
+	ts.provider.rateLimited = true
+	require.NoError(t, ts.evaluateAlerts(now.Add(10*time.Minute)))
+	assert.Equal(t, 6, ts.provider.calls)
+	require.NoError(t, ts.evaluateAlerts(now.Add(15*time.Minute)))
+	require.NoError(t, ts.pollSchedules(now.Add(15*time.Minute)))
+	assert.Equal(t, 6, ts.provider.calls)
+	assert.Len(t, notifier.sent, 3)
+}
+
+// webhookReceiver records the deliveries posted to it. Every delivery to
+// /flaky fails on its first attempt.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	requests map[string][]*http.Request
+	bodies   map[string][][]byte
+	attempts map[string]int
+}
+
+func (rr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	rr.requests[r.URL.Path] = append(rr.requests[r.URL.Path], r)
+	rr.bodies[r.URL.Path] = append(rr.bodies[r.URL.Path], body)
+
+	delivery := r.Header.Get("X-Webhook-Delivery")
+	rr.attempts[delivery]++
+	if r.URL.Path == "/flaky" && rr.attempts[delivery] == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+// events returns the events of the deliveries posted to path.
+func (rr *webhookReceiver) events(path string) []string {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	var events []string
+	for _, r := range rr.requests[path] {
+		events = append(events, r.Header.Get("X-Webhook-Event"))
+	}
+	return events
+}
+
+func TestWebhooks(t *testing.T) {
+	ts := newTestServer(t)
+	ts.dispatcher.backoff = time.Millisecond
+	token := ts.token(t, "user@example.com")
+	other := ts.token(t, "other@example.com")
+
+	receiver := &webhookReceiver{requests: map[string][]*http.Request{}, bodies: map[string][][]byte{}, attempts: map[string]int{}}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	rec, resp := ts.do(t, http.MethodPost, "/api/webhooks", token, map[string]interface{}{"url": srv.URL + "/fetched", "events": []string{"weather.fetched"}})
+	require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+	fetched := resp.Data.(map[string]interface{})
+	secret := fetched["secret"].(string)
+	assert.Len(t, secret, 64)
+
+	rec, resp = ts.do(t, http.MethodPost, "/api/webhooks", token, map[string]interface{}{"url": srv.URL + "/flaky"})
+	require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+	flakyID := strconv.Itoa(int(resp.Data.(map[string]interface{})["id"].(float64)))
+
+	rec, _ = ts.do(t, http.MethodPost, "/api/webhooks", token, map[string]interface{}{"url": srv.URL, "events": []string{"weather.deleted"}})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec, _ = ts.do(t, http.MethodPost, "/api/webhooks", token, map[string]interface{}{"url": "ftp://example.com"})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	_, resp = ts.do(t, http.MethodGet, "/api/webhooks", token, nil)
+	require.Len(t, resp.Data, 2)
+	assert.NotContains(t, resp.Data.([]interface{})[0], "secret")
+
+	_, resp = ts.do(t, http.MethodGet, "/api/weather?city=Pune", token, nil)
+	weatherID := strconv.Itoa(int(resp.Data.(map[string]interface{})["weather_id"].(float64)))
+	ts.do(t, http.MethodDelete, "/api/history/delete?weatherID="+weatherID, token, nil)
+	ts.do(t, http.MethodGet, "/api/weather?city=Delhi", other, nil)
+	ts.deliverWebhooks(t)
+
+	// Deliveries are signed with the secret of the webhook
+	require.Len(t, receiver.requests["/fetched"], 1)
+	req, body := receiver.requests["/fetched"][0], receiver.bodies["/fetched"][0]
+	assert.Equal(t, "weather.fetched", req.Header.Get("X-Webhook-Event"))
+	assert.Equal(t, "sha256="+signPayload(secret, req.Header.Get("X-Webhook-Timestamp"), body), req.Header.Get("X-Webhook-Signature"))
+
+	var event webhookEvent
+	require.NoError(t, json.Unmarshal(body, &event))
+	assert.Equal(t, "weather.fetched", event.Event)
+	assert.Equal(t, "Pune", event.Data.(map[string]interface{})["name"])
+
+	// The webhook subscribed to every event got both after a retry
+	assert.ElementsMatch(t, []string{"weather.fetched", "weather.fetched", "history.deleted", "history.deleted"}, receiver.events("/flaky"))
+
+	rec, resp = ts.do(t, http.MethodGet, "/api/webhooks/deliveries?webhookID="+flakyID, token, nil)
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	deliveries := resp.Data.([]interface{})
+	require.Len(t, deliveries, 2)
+	first := deliveries[1].(map[string]interface{})
+	assert.Equal(t, "weather.fetched", first["event"])
+	assert.Equal(t, "succeeded", first["status"])
+	assert.Equal(t, float64(2), first["attempts"])
+	assert.Equal(t, float64(200), first["response_status"])
+
+	// A delivery can be sent again, by its owner only
+	firstID := strconv.Itoa(int(first["id"].(float64)))
+	rec, _ = ts.do(t, http.MethodPost, "/api/webhooks/redeliver?deliveryID="+firstID, other, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec, resp = ts.do(t, http.MethodPost, "/api/webhooks/redeliver?deliveryID="+firstID, token, nil)
+	require.Equal(t, http.StatusAccepted, rec.Code, resp.Message)
+	ts.deliverWebhooks(t)
+
+	events := receiver.events("/flaky")
+	require.Len(t, events, 6)
+	assert.Equal(t, "weather.fetched", events[5])
+	assert.Equal(t, body, receiver.bodies["/flaky"][5])
+
+	rec, _ = ts.do(t, http.MethodDelete, "/api/webhooks/delete?webhookID="+flakyID, other, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec, _ = ts.do(t, http.MethodDelete, "/api/webhooks/delete?webhookID="+flakyID, token, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec, _ = ts.do(t, http.MethodGet, "/api/webhooks/deliveries?webhookID="+flakyID, token, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestWebhookDeliveriesSurviveRestarts(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.token(t, "user@example.com")
+
+	receiver := &webhookReceiver{requests: map[string][]*http.Request{}, bodies: map[string][][]byte{}, attempts: map[string]int{}}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	var hooks []*models.Webhook
+	for _, path := range []string{"/flaky", "/fetched"} {
+		rec, resp := ts.do(t, http.MethodPost, "/api/webhooks", token, map[string]interface{}{"url": srv.URL + path})
+		require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+		hook, err := ts.store.GetWebhookByID(int(resp.Data.(map[string]interface{})["id"].(float64)), 1)
+		require.NoError(t, err)
+		hooks = append(hooks, hook)
+	}
+	hook := hooks[0]
+
+	// The first attempt fails, and the retry is recorded in the store
+	delivery, err := ts.dispatcher.enqueue(*hook, eventHistoryCleared, []byte(`{}`))
+	require.NoError(t, err)
+	now := time.Now()
+	require.NoError(t, ts.dispatcher.deliverDue(now))
+	ts.dispatcher.wait()
+	stored, err := ts.store.GetWebhookDeliveryByID(delivery.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, deliveryPending, stored.Status)
+	assert.Equal(t, 1, stored.Attempts)
+	require.NotNil(t, stored.NextAttemptAt)
+	assert.True(t, stored.NextAttemptAt.After(now))
+
+	// The worker of a new dispatcher, as after a restart, sends new
+	// deliveries right away, but leaves the retry until it is due
+	restarted := newWebhookDispatcher(ts.store)
+	restarted.client = ts.dispatcher.client
+	go restarted.run(time.Hour)
+	_, err = restarted.enqueue(*hooks[1], eventHistoryCleared, []byte(`{}`))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return len(receiver.events("/fetched")) == 1
+	}, time.Second, 10*time.Millisecond)
+	restarted.stop()
+	assert.Len(t, receiver.events("/flaky"), 1)
+
+	restarted = newWebhookDispatcher(ts.store)
+	restarted.client = ts.dispatcher.client
+	require.NoError(t, restarted.deliverDue(*stored.NextAttemptAt))
+	restarted.wait()
+	stored, err = ts.store.GetWebhookDeliveryByID(delivery.ID, 1)
+	require.NoError(t, err)
+	assert.Equal(t, deliverySucceeded, stored.Status)
+	assert.Equal(t, 2, stored.Attempts)
+	assert.Nil(t, stored.NextAttemptAt)
+	assert.Len(t, receiver.events("/flaky"), 2)
+}
+
+func TestSlowWebhookDoesNotHoldUpOthers(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.token(t, "user@example.com")
+
+	receiver := &webhookReceiver{requests: map[string][]*http.Request{}, bodies: map[string][][]byte{}, attempts: map[string]int{}}
+	var slowCalls int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			atomic.AddInt32(&slowCalls, 1)
+			<-release
+		}
+		receiver.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	var hooks []*models.Webhook
+	for _, path := range []string{"/slow", "/fetched"} {
+		rec, resp := ts.do(t, http.MethodPost, "/api/webhooks", token, map[string]interface{}{"url": srv.URL + path})
+		require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+		hook, err := ts.store.GetWebhookByID(int(resp.Data.(map[string]interface{})["id"].(float64)), 1)
+		require.NoError(t, err)
+		hooks = append(hooks, hook)
+	}
+	for i := 0; i < 3; i++ {
+		_, err := ts.dispatcher.enqueue(*hooks[0], eventHistoryCleared, []byte(`{}`))
+		require.NoError(t, err)
+	}
+	_, err := ts.dispatcher.enqueue(*hooks[1], eventHistoryCleared, []byte(`{}`))
+	require.NoError(t, err)
+
+	// The hung receiver gets one delivery at a time, and the other webhook
+	// is sent its delivery meanwhile
+	require.NoError(t, ts.dispatcher.deliverDue(time.Now()))
+	require.Eventually(t, func() bool {
+		return len(receiver.events("/fetched")) == 1 && atomic.LoadInt32(&slowCalls) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, ts.dispatcher.deliverDue(time.Now()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&slowCalls))
+
+	close(release)
+	ts.dispatcher.wait()
+	ts.deliverWebhooks(t)
+	assert.Len(t, receiver.events("/slow"), 3)
+	assert.Len(t, receiver.events("/fetched"), 1)
+}
+
+func TestSchedules(t *testing.T) {
+	ts := newTestServer(t)
+	token := ts.token(t, "user@example.com")
+	other := ts.token(t, "other@example.com")
+
+	rec, resp := ts.do(t, http.MethodPost, "/api/schedules", token, map[string]interface{}{"city": "Pune", "interval_minutes": 30})
+	require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+	puneID := strconv.Itoa(int(resp.Data.(map[string]interface{})["id"].(float64)))
+	rec, resp = ts.do(t, http.MethodPost, "/api/schedules", token, map[string]interface{}{"city": "Atlantis", "interval_minutes": 10})
+	require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+	rec, resp = ts.do(t, http.MethodPost, "/api/schedules", other, map[string]interface{}{"city": "Pune", "interval_minutes": 60})
+	require.Equal(t, http.StatusCreated, rec.Code, resp.Message)
+
+	rec, _ = ts.do(t, http.MethodPost, "/api/schedules", token, map[string]interface{}{"city": "Pune", "interval_minutes": 5})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec, _ = ts.do(t, http.MethodPost, "/api/schedules", token, map[string]interface{}{"interval_minutes": 30})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// New schedules run on the next pass, and both users watching Pune share a lookup
+	now := time.Now().Add(time.Minute)
+	require.NoError(t, ts.pollSchedules(now))
+	assert.Equal(t, 2, ts.provider.calls)
+	_, resp = ts.do(t, http.MethodGet, "/api/history", token, nil)
+	assert.Equal(t, float64(1), resp.Data.(map[string]interface{})["total_count"])
+	_, resp = ts.do(t, http.MethodGet, "/api/history", other, nil)
+	assert.Equal(t, float64(1), resp.Data.(map[string]interface{})["total_count"])
+
+	_, resp = ts.do(t, http.MethodGet, "/api/schedules", token, nil)
+	schedules := resp.Data.([]interface{})
+	require.Len(t, schedules, 2)
+	assert.Equal(t, float64(1), schedules[0].(map[string]interface{})["run_count"])
+	assert.NotContains(t, schedules[0], "last_error")
+	assert.Equal(t, "City not found.", schedules[1].(map[string]interface{})["last_error"])
+
+	require.NoError(t, ts.pollSchedules(now.Add(5*time.Minute)))
+	assert.Equal(t, 2, ts.provider.calls)
+
+	// Over budget, the longest overdue location goes first and the rest wait
+	ts.pollBudget = 1
+	require.NoError(t, ts.pollSchedules(now.Add(31*time.Minute)))
+	assert.Equal(t, 3, ts.provider.calls)
+	_, resp = ts.do(t, http.MethodGet, "/api/history", token, nil)
+	assert.Equal(t, float64(1), resp.Data.(map[string]interface{})["total_count"])
+	require.NoError(t, ts.pollSchedules(now.Add(32*time.Minute)))
+	assert.Equal(t, 4, ts.provider.calls)
+	_, resp = ts.do(t, http.MethodGet, "/api/history", token, nil)
+	assert.Equal(t, float64(2), resp.Data.(map[string]interface{})["total_count"])
+
+	// Running out of upstream quota pauses polling
+	ts.pollBudget = 30
+	ts.provider.rateLimited = true
+	require.NoError(t, ts.pollSchedules(now.Add(2*time.Hour)))
+	assert.Equal(t, 5, ts.provider.calls)
+	require.NoError(t, ts.pollSchedules(now.Add(2*time.Hour+time.Minute)))
+	assert.Equal(t, 5, ts.provider.calls)
+
+	_, resp = ts.do(t, http.MethodGet, "/api/schedules", token, nil)
+	assert.Equal(t, "Upstream rate limit exceeded.", resp.Data.([]interface{})[1].(map[string]interface{})["last_error"])
+
+	rec, resp = ts.do(t, http.MethodPut, "/api/schedules/update?scheduleID="+puneID, token, map[string]interface{}{"city": "Pune", "interval_minutes": 60, "enabled": false})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	assert.Equal(t, false, resp.Data.(map[string]interface{})["enabled"])
+
+	// Schedules of other users cannot be changed or deleted
+	rec, _ = ts.do(t, http.MethodPut, "/api/schedules/update?scheduleID="+puneID, other, map[string]interface{}{"city": "Pune", "interval_minutes": 60})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec, _ = ts.do(t, http.MethodDelete, "/api/schedules/delete?scheduleID="+puneID, other, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec, _ = ts.do(t, http.MethodDelete, "/api/schedules/delete?scheduleID="+puneID, token, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRateLimiting(t *testing.T) {
+	ts := newTestServer(t)
+	ts.rateLimits["/api/weather"] = ratelimit.Per(2, time.Minute)
+	ts.rateLimits["/api/login"] = ratelimit.Per(1, time.Minute)
+
+	alice := ts.token(t, "alice@example.com")
+	bob := ts.token(t, "bob@example.com")
+
+	for i := 1; i >= 0; i-- {
+		rec, _ := ts.do(t, http.MethodGet, "/api/weather?city=Pune", alice, nil)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, strconv.Itoa(i), rec.Header().Get("X-RateLimit-Remaining"))
+	}
+
+	rec, resp := ts.do(t, http.MethodGet, "/api/weather?city=Pune", alice, nil)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "Too many requests, try again later.", resp.Message)
+	assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "60", rec.Header().Get("X-RateLimit-Reset"))
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+
+	// Authenticated clients are limited per user, and each route on its own
+	rec, _ = ts.do(t, http.MethodGet, "/api/weather?city=Pune", bob, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec, _ = ts.do(t, http.MethodGet, "/api/history", alice, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "120", rec.Header().Get("X-RateLimit-Limit"))
+
+	// Others are limited per IP address
+	credentials := map[string]string{"username": "alice@example.com", "password": "Password12"}
+	rec, _ = ts.do(t, http.MethodPost, "/api/login", "", credentials)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec, _ = ts.do(t, http.MethodPost, "/api/login", "", credentials)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	// Requests with invalid tokens are limited before they are authenticated
+	for i := 0; i < 2; i++ {
+		rec, _ = ts.do(t, http.MethodGet, "/api/weather?city=Pune", "Bearer invalid", nil)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+	rec, _ = ts.do(t, http.MethodGet, "/api/weather?city=Pune", "Bearer invalid", nil)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	ts.rateLimiter = nil
+	rec, _ = ts.do(t, http.MethodPost, "/api/login", "", credentials)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("X-RateLimit-Limit"))
+}
+
+func TestLoginThrottling(t *testing.T) {
+	ts := newTestServer(t)
+	ts.rateLimiter = nil
+	ts.loginPolicy = loginPolicy{Window: 15 * time.Minute, FreeFailures: 1, Delay: time.Hour, MaxDelay: time.Hour, UserLockout: 3, IPLockout: 100, AccountWindow: 24 * time.Hour, AccountLockout: 100}
+	ts.register(t, "alice@example.com")
+	bob := ts.token(t, "bob@example.com")
+	ts.register(t, "admin@example.com")
+	adminUser, err := ts.store.GetUserByUsername("admin@example.com")
+	require.NoError(t, err)
+	ts.admins[adminUser.ID] = true
+
+	loginFrom := func(remoteAddr, username, password string) (*httptest.ResponseRecorder, models.Response) {
+		body, err := json.Marshal(map[string]string{"username": username, "password": password})
+		require.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, "/api/login", bytes.NewReader(body))
+		req.RemoteAddr = remoteAddr
+
+		rec := httptest.NewRecorder()
+		ts.handler.ServeHTTP(rec, req)
+
+		var resp models.Response
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		return rec, resp
+	}
+	login := func(username, password string) (*httptest.ResponseRecorder, models.Response) {
+		return loginFrom("192.0.2.1:1234", username, password)
+	}
+
+	// Roles are granted when tokens are issued
+	rec, resp := login("admin@example.com", "Password12")
+	require.Equal(t, http.StatusOK, rec.Code)
+	admin := resp.Data.(map[string]interface{})["token"].(string)
+
+	for i := 0; i < 2; i++ {
+		rec, resp := login("Alice@example.com", "Wrong1234")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "Invalid credentials.", resp.Message)
+	}
+
+	// Past the free failures every attempt waits, even with the right password
+	rec, resp = login("alice@example.com", "Password12")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "Too many failed logins, try again later.", resp.Message)
+	// Checking a password takes a while, more so under the race detector
+	retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 3600, retryAfter, 5)
+
+	ts.loginPolicy.Delay, ts.loginPolicy.MaxDelay = 0, 0
+	rec, _ = login("alice@example.com", "Wrong1234")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec, resp = login("alice@example.com", "Password12")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "Account locked after too many failed logins, try again later.", resp.Message)
+	retryAfter, err = strconv.Atoi(rec.Header().Get("Retry-After"))
+	require.NoError(t, err)
+	assert.InDelta(t, 900, retryAfter, 5)
+
+	attempts, err := ts.store.FetchLoginAttempts("alice@example.com", "", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	var events []string
+	for _, attempt := range attempts {
+		events = append(events, attempt.Event)
+	}
+	assert.Equal(t, []string{loginFailed, loginFailed, loginThrottled, loginFailed, loginLocked, loginThrottled}, events)
+
+	// The lockout is per username and IP: other accounts can still log in
+	// from this IP, and the owner from anywhere else
+	rec, _ = login("bob@example.com", "Password12")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec, _ = loginFrom("198.51.100.7:1234", "alice@example.com", "Password12")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec, _ = login("alice@example.com", "Password12")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+
+	rec, resp = ts.do(t, http.MethodPost, "/api/admin/unlock", bob, map[string]string{"username": "alice@example.com"})
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Equal(t, "Admin role required.", resp.Message)
+
+	rec, _ = ts.do(t, http.MethodPost, "/api/admin/unlock", admin, map[string]string{"username": "nobody@example.com"})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec, resp = ts.do(t, http.MethodPost, "/api/admin/unlock", admin, map[string]string{"username": "alice@example.com"})
+	assert.Equal(t, http.StatusOK, rec.Code, resp.Message)
+
+	rec, _ = login("alice@example.com", "Password12")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// Failures from one IP across usernames lock the IP out, here the three
+	// against alice and one against carol
+	ts.loginPolicy.IPLockout = 4
+	rec, _ = login("carol@example.com", "Wrong1234")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	rec, resp = login("bob@example.com", "Password12")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "Too many failed logins, try again later.", resp.Message)
+}
+
+func TestAccountLockout(t *testing.T) {
+	ts := newTestServer(t)
+	ts.loginPolicy = loginPolicy{Window: 15 * time.Minute, FreeFailures: 1, Delay: 0, MaxDelay: 0, UserLockout: 3, IPLockout: 100, AccountWindow: 24 * time.Hour, AccountLockout: 5}
+	ts.register(t, "alice@example.com")
+	ts.register(t, "admin@example.com")
+	adminUser, err := ts.store.GetUserByUsername("admin@example.com")
+	require.NoError(t, err)
+	ts.admins[adminUser.ID] = true
+	rec, resp := ts.do(t, http.MethodPost, "/api/login", "", map[string]string{"username": "admin@example.com", "password": "Password12"})
+	require.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	admin := resp.Data.(map[string]interface{})["token"].(string)
+	now := time.Now()
+
+	login := func(ip, event string) (time.Duration, bool) {
+		id, wait, locked, err := ts.beginLogin("alice@example.com", ip, now)
+		require.NoError(t, err)
+		if wait == 0 {
+			require.NoError(t, ts.finishLogin(id, "alice@example.com", ip, event, now))
+		}
+		return wait, locked
+	}
+
+	// Rotating IPs stays under the lockout per IP but not under the one of
+	// the account, which then holds for every IP
+	for i := 0; i < 5; i++ {
+		wait, _ := login("192.0.2."+strconv.Itoa(i), loginFailed)
+		assert.Zero(t, wait)
+	}
+	wait, locked := login("198.51.100.7", loginSucceeded)
+	assert.True(t, locked)
+	assert.Equal(t, 24*time.Hour, wait)
+
+	// Unlocking resets the failures of the account
+	rec, resp = ts.do(t, http.MethodPost, "/api/admin/unlock", admin, map[string]string{"username": "alice@example.com"})
+	assert.Equal(t, http.StatusOK, rec.Code, resp.Message)
+	wait, locked = login("192.0.2.9", loginFailed)
+	assert.Zero(t, wait)
+	assert.False(t, locked)
+	wait, _ = login("198.51.100.7", loginSucceeded)
+	assert.Zero(t, wait)
+
+	// So does a successful login, without which the failure before it would
+	// have counted towards these
+	for i := 0; i < 5; i++ {
+		wait, _ := login("203.0.113."+strconv.Itoa(i), loginFailed)
+		assert.Zero(t, wait)
+	}
+	wait, _ = login("203.0.113.9", loginFailed)
+	assert.Equal(t, 24*time.Hour, wait)
+}
+
+func TestConcurrentLoginsAreThrottled(t *testing.T) {
+	ts := newTestServer(t)
+	ts.loginPolicy = loginPolicy{Window: 15 * time.Minute, FreeFailures: 1, Delay: time.Hour, MaxDelay: time.Hour, UserLockout: 3, IPLockout: 100, AccountWindow: 24 * time.Hour, AccountLockout: 100}
+	now := time.Now()
+
+	// Logins begun before any of them has failed count as failures, so only
+	// FreeFailures of them get to check a password
+	first, wait, _, err := ts.beginLogin("alice@example.com", "192.0.2.1", now)
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	_, wait, _, err = ts.beginLogin("alice@example.com", "192.0.2.1", now)
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+	_, wait, _, err = ts.beginLogin("alice@example.com", "192.0.2.1", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, wait)
+
+	// A success from the same IP lets the next login through again
+	require.NoError(t, ts.finishLogin(first, "alice@example.com", "192.0.2.1", loginSucceeded, now))
+	_, wait, _, err = ts.beginLogin("alice@example.com", "192.0.2.1", now)
+	require.NoError(t, err)
+	assert.Zero(t, wait)
+}
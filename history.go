@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+)
+
+const (
+	defaultHistoryLimit = 20
+	maxHistoryLimit     = 100
+)
+
+// parseHistoryQuery reads the pagination, filter and sort parameters of
+// GET /api/history. The returned error message is suitable for the client.
+func parseHistoryQuery(values url.Values) (models.HistoryQuery, error) {
+	query := models.HistoryQuery{
+		City:      strings.TrimSpace(values.Get("city")),
+		Country:   strings.ToUpper(strings.TrimSpace(values.Get("country"))),
+		Condition: strings.TrimSpace(values.Get("condition")),
+		Sort:      values.Get("sort"),
+		Order:     strings.ToLower(values.Get("order")),
+		Limit:     defaultHistoryLimit,
+		Cursor:    values.Get("cursor"),
+	}
+
+	if query.Sort == "" {
+		query.Sort = "created_at"
+	}
+	if query.Sort != "created_at" && query.Sort != "temp" && query.Sort != "city" {
+		return query, errors.New("Invalid sort, use created_at, temp or city.")
+	}
+
+	if query.Order == "" {
+		query.Order = "desc"
+	}
+	if query.Order != "asc" && query.Order != "desc" {
+		return query, errors.New("Invalid order, use asc or desc.")
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxHistoryLimit {
+			return query, errors.New("Invalid limit, use a number between 1 and 100.")
+		}
+		query.Limit = n
+	}
+
+	if from := values.Get("from"); from != "" {
+		t, err := parseHistoryTime(from, false)
+		if err != nil {
+			return query, errors.New("Invalid from date, use YYYY-MM-DD or RFC 3339.")
+		}
+		query.From = &t
+	}
+
+	if to := values.Get("to"); to != "" {
+		t, err := parseHistoryTime(to, true)
+		if err != nil {
+			return query, errors.New("Invalid to date, use YYYY-MM-DD or RFC 3339.")
+		}
+		query.To = &t
+	}
+
+	if query.From != nil && query.To != nil && !query.From.Before(*query.To) {
+		return query, errors.New("The from date must be before the to date.")
+	}
+
+	if minTemp := values.Get("min_temp"); minTemp != "" {
+		t, err := strconv.ParseFloat(minTemp, 64)
+		if err != nil {
+			return query, errors.New("Invalid min_temp.")
+		}
+		query.MinTemp = &t
+	}
+
+	if maxTemp := values.Get("max_temp"); maxTemp != "" {
+		t, err := strconv.ParseFloat(maxTemp, 64)
+		if err != nil {
+			return query, errors.New("Invalid max_temp.")
+		}
+		query.MaxTemp = &t
+	}
+
+	if query.MinTemp != nil && query.MaxTemp != nil && *query.MinTemp > *query.MaxTemp {
+		return query, errors.New("The min_temp must not be above the max_temp.")
+	}
+
+	for _, include := range strings.Split(values.Get("include"), ",") {
+		switch strings.TrimSpace(include) {
+		case "":
+		case "raw":
+			query.IncludeRaw = true
+		default:
+			return query, errors.New("Invalid include, use raw.")
+		}
+	}
+
+	return query, nil
+}
+
+// parseHistoryTime accepts a date or an RFC 3339 timestamp. A bare date used
+// as the end of a range includes the whole day.
+func parseHistoryTime(value string, endOfRange bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfRange {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
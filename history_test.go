@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHistoryQueryDefaults(t *testing.T) {
+	query, err := parseHistoryQuery(url.Values{})
+	assert.NoError(t, err)
+	assert.Equal(t, "created_at", query.Sort)
+	assert.Equal(t, "desc", query.Order)
+	assert.Equal(t, defaultHistoryLimit, query.Limit)
+	assert.Nil(t, query.From)
+	assert.Nil(t, query.MinTemp)
+	assert.False(t, query.IncludeRaw)
+}
+
+func TestParseHistoryQueryFilters(t *testing.T) {
+	query, err := parseHistoryQuery(url.Values{
+		"city":      {"Pune"},
+		"country":   {"in"},
+		"condition": {"Rain"},
+		"from":      {"2023-07-01"},
+		"to":        {"2023-07-31"},
+		"min_temp":  {"280.5"},
+		"max_temp":  {"300"},
+		"sort":      {"temp"},
+		"order":     {"asc"},
+		"limit":     {"50"},
+		"include":   {"raw"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Pune", query.City)
+	assert.Equal(t, "IN", query.Country)
+	assert.Equal(t, "Rain", query.Condition)
+	assert.Equal(t, time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC), *query.From)
+	// A date-only end of range covers the whole day
+	assert.Equal(t, time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC), *query.To)
+	assert.Equal(t, 280.5, *query.MinTemp)
+	assert.Equal(t, 300.0, *query.MaxTemp)
+	assert.Equal(t, "temp", query.Sort)
+	assert.Equal(t, "asc", query.Order)
+	assert.Equal(t, 50, query.Limit)
+	assert.True(t, query.IncludeRaw)
+}
+
+func TestParseHistoryQueryInvalid(t *testing.T) {
+	invalid := []url.Values{
+		{"sort": {"humidity"}},
+		{"order": {"sideways"}},
+		{"limit": {"0"}},
+		{"limit": {"1000"}},
+		{"from": {"yesterday"}},
+		{"from": {"2023-07-31"}, "to": {"2023-07-01"}},
+		{"min_temp": {"warm"}},
+		{"min_temp": {"300"}, "max_temp": {"280"}},
+		{"include": {"everything"}},
+	}
+
+	for _, values := range invalid {
+		_, err := parseHistoryQuery(values)
+		assert.Error(t, err, "query %v", values)
+	}
+}
@@ -0,0 +1,105 @@
+// Package i18n translates the messages of API responses.
+//
+// Messages are written in English throughout the code and double as their
+// own catalog keys, so a message missing from a catalog is still answered in
+// English. Each other language has a catalog in messages/<lang>.json mapping
+// the English message to its translation.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the language of the messages in the code.
+const Default = "en"
+
+//go:embed messages
+var messageFiles embed.FS
+
+// catalogs maps a language to its translations, keyed by English message.
+var catalogs = mustLoadCatalogs()
+
+func mustLoadCatalogs() map[string]map[string]string {
+	entries, err := messageFiles.ReadDir("messages")
+	if err != nil {
+		panic(err)
+	}
+
+	catalogs := map[string]map[string]string{Default: {}}
+	for _, entry := range entries {
+		lang := strings.TrimSuffix(entry.Name(), ".json")
+		payload, err := messageFiles.ReadFile(path.Join("messages", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+
+		var catalog map[string]string
+		if err := json.Unmarshal(payload, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: %s: %v", entry.Name(), err))
+		}
+		catalogs[lang] = catalog
+	}
+
+	return catalogs
+}
+
+// Languages returns the languages with a catalog, in alphabetical order.
+func Languages() []string {
+	var langs []string
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Translate returns message in lang, or message itself when there is no
+// translation for it.
+func Translate(lang, message string) string {
+	if translated, ok := catalogs[lang][message]; ok && translated != "" {
+		return translated
+	}
+	return message
+}
+
+// Match returns the supported language for a code such as "de", "es-MX" or
+// "pt_br", comparing only the primary language.
+func Match(code string) (string, bool) {
+	primary, _, _ := strings.Cut(strings.ReplaceAll(code, "_", "-"), "-")
+	primary = strings.ToLower(strings.TrimSpace(primary))
+	if _, ok := catalogs[primary]; ok {
+		return primary, true
+	}
+	return "", false
+}
+
+// Negotiate picks the supported language the client prefers most from an
+// Accept-Language header, falling back to Default.
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := Default, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			parsed, err := strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+
+		lang, ok := Match(tag)
+		if !ok || q <= bestQ {
+			continue
+		}
+		best, bestQ = lang, q
+	}
+	return best
+}
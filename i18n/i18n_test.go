@@ -0,0 +1,51 @@
+package i18n
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalogsTranslateTheSameMessages(t *testing.T) {
+	assert.Equal(t, []string{"de", "en", "es", "hi"}, Languages())
+
+	for _, lang := range Languages() {
+		if lang == Default {
+			continue
+		}
+		for message, translated := range catalogs["hi"] {
+			assert.NotEmpty(t, translated, "hi: %q", message)
+			assert.NotEmpty(t, catalogs[lang][message], "%s: %q", lang, message)
+		}
+		assert.Len(t, catalogs[lang], len(catalogs["hi"]), lang)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	assert.Equal(t, "Methode nicht erlaubt.", Translate("de", "Method not allowed."))
+	assert.Equal(t, "Method not allowed.", Translate("en", "Method not allowed."))
+	assert.Equal(t, "Method not allowed.", Translate("fr", "Method not allowed."))
+	assert.Equal(t, "Not in any catalog.", Translate("es", "Not in any catalog."))
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                          "en",
+		"de":                        "de",
+		"es-MX":                     "es",
+		"fr-FR, hi;q=0.8, en;q=0.5": "hi",
+		"en;q=0.4, de;q=0.9":        "de",
+		"de;q=0, es;q=0.1":          "es",
+		"*":                         "en",
+		"de;q=nope, es":             "es",
+	}
+	for header, want := range cases {
+		assert.Equal(t, want, Negotiate(header), header)
+	}
+
+	lang, ok := Match("ES_mx")
+	assert.True(t, ok)
+	assert.Equal(t, "es", lang)
+	_, ok = Match("zh_cn")
+	assert.False(t, ok)
+}
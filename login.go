@@ -0,0 +1,231 @@
+package main
+
+import (
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+)
+
+// Events of the login history. A login is recorded as pending before the
+// password is checked, and then becomes a success, a failure, or an error
+// when it could not be checked. Logins refused by the throttle are recorded
+// as throttled.
+const (
+	loginPending   = "pending"
+	loginSucceeded = "success"
+	loginFailed    = "failure"
+	loginErrored   = "error"
+	loginThrottled = "throttled"
+	loginLocked    = "lockout"
+	loginUnlocked  = "unlock"
+)
+
+// roleAdmin is the role of the users allowed to use the admin endpoints.
+const roleAdmin = "admin"
+
+// loginPolicy decides how failed logins are throttled. Failures are counted
+// per username and client IP, since the latest successful login from that IP
+// or unlock of the username, and per client IP alone, over the last Window.
+// Once a count passes FreeFailures, every further attempt has to wait Delay
+// after the latest failure, doubling with each failure up to MaxDelay.
+// Reaching UserLockout locks the username out for that IP, and IPLockout the
+// IP out altogether, until Window has passed since the latest failure. Since
+// usernames are only locked out per IP there, failures from others cannot
+// keep the owner of an account from logging in.
+//
+// Failures against a username from any IP are counted too, over the longer
+// AccountWindow and since its latest successful login or unlock, so that
+// rotating IPs does not give unlimited guesses. Reaching AccountLockout locks
+// the account out everywhere until an admin unlocks it or AccountWindow has
+// passed since the latest failure.
+type loginPolicy struct {
+	Window         time.Duration
+	FreeFailures   int
+	Delay          time.Duration
+	MaxDelay       time.Duration
+	UserLockout    int
+	IPLockout      int
+	AccountWindow  time.Duration
+	AccountLockout int
+}
+
+func defaultLoginPolicy() loginPolicy {
+	return loginPolicy{
+		Window:         15 * time.Minute,
+		FreeFailures:   3,
+		Delay:          time.Second,
+		MaxDelay:       30 * time.Second,
+		UserLockout:    10,
+		IPLockout:      30,
+		AccountWindow:  24 * time.Hour,
+		AccountLockout: 100,
+	}
+}
+
+// history returns how far back login attempts count towards a throttle.
+func (p loginPolicy) history() time.Duration {
+	if p.AccountWindow > p.Window {
+		return p.AccountWindow
+	}
+	return p.Window
+}
+
+// loginThrottle is when a username or IP may try to log in again.
+type loginThrottle struct {
+	retryAfter time.Duration
+	locked     bool
+}
+
+// throttle returns the throttle of a username or IP whose failed logins in
+// window are failures, the latest at last, with lockout failures locking it.
+func (p loginPolicy) throttle(failures int, last time.Time, lockout int, window time.Duration, now time.Time) loginThrottle {
+	if failures >= lockout {
+		return loginThrottle{retryAfter: last.Add(window).Sub(now), locked: true}
+	}
+	if failures <= p.FreeFailures {
+		return loginThrottle{}
+	}
+
+	delay := p.MaxDelay
+	if n := failures - p.FreeFailures - 1; n < 30 && p.Delay<<n < p.MaxDelay {
+		delay = p.Delay << n
+	}
+	return loginThrottle{retryAfter: last.Add(delay).Sub(now)}
+}
+
+// loginThrottles returns the throttles of username from ip, of the account of
+// username and of ip at now given their login history, counting the attempts
+// recorded before the one with id before. Pending attempts count as failures,
+// so that logins made at the same time cannot all pass the throttle before any
+// has failed.
+func (p loginPolicy) loginThrottles(attempts []models.LoginAttempt, username, ip string, before int, now time.Time) (user, account, client loginThrottle) {
+	var userFailures, accountFailures, ipFailures int
+	var userLast, accountLast, ipLast time.Time
+	windowStart := now.Add(-p.Window)
+	for _, attempt := range attempts {
+		if attempt.ID >= before {
+			break
+		}
+
+		failed := attempt.Event == loginFailed || attempt.Event == loginPending
+		if attempt.Username == username {
+			switch {
+			case attempt.Event == loginUnlocked:
+				userFailures, accountFailures = 0, 0
+			case attempt.Event == loginSucceeded:
+				accountFailures = 0
+			case failed:
+				accountFailures++
+				accountLast = attempt.CreatedAt
+			}
+		}
+		if attempt.CreatedAt.Before(windowStart) {
+			continue
+		}
+
+		ownAttempt := attempt.Username == username && attempt.IP == ip
+		switch {
+		case ownAttempt && attempt.Event == loginSucceeded:
+			userFailures = 0
+		case ownAttempt && failed:
+			userFailures++
+			userLast = attempt.CreatedAt
+		}
+		// Logging in to an account of their own does not let a client guess
+		// more passwords of others.
+		if attempt.IP == ip && failed {
+			ipFailures++
+			ipLast = attempt.CreatedAt
+		}
+	}
+
+	// The account is only throttled once locked, delays being left to the
+	// per IP counts
+	if accountFailures >= p.AccountLockout {
+		account = p.throttle(accountFailures, accountLast, p.AccountLockout, p.AccountWindow, now)
+	}
+	return p.throttle(userFailures, userLast, p.UserLockout, p.Window, now), account, p.throttle(ipFailures, ipLast, p.IPLockout, p.Window, now)
+}
+
+// beginLogin records a pending login of username, in lower case, from ip and
+// returns its id, how long the login has to wait given the attempts recorded
+// before it, and whether the username is locked out for ip or everywhere. A login that has
+// to wait is recorded as throttled. Recording the attempt before checking
+// makes concurrent logins see each other.
+func (s *server) beginLogin(username, ip string, now time.Time) (int, time.Duration, bool, error) {
+	id, err := s.logins.RecordLoginAttempt(models.LoginAttempt{Username: username, IP: ip, Event: loginPending, CreatedAt: now})
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	attempts, err := s.logins.FetchLoginAttempts(username, ip, now.Add(-s.loginPolicy.history()))
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	var wait time.Duration
+	var locked bool
+	user, account, client := s.loginPolicy.loginThrottles(attempts, username, ip, id, now)
+	for _, throttle := range []loginThrottle{user, account, client} {
+		if throttle.retryAfter > wait {
+			wait = throttle.retryAfter
+		}
+	}
+	if wait <= 0 {
+		return id, 0, false, nil
+	}
+
+	if err := s.logins.UpdateLoginAttempt(id, loginThrottled); err != nil {
+		return 0, 0, false, err
+	}
+	for _, throttle := range []loginThrottle{user, account} {
+		locked = locked || throttle.locked && throttle.retryAfter > 0
+	}
+	return id, wait, locked, nil
+}
+
+// finishLogin records the outcome of the login begun as id. A failure that
+// locks the username or ip out is followed by a lockout event.
+func (s *server) finishLogin(id int, username, ip, event string, now time.Time) error {
+	if err := s.logins.UpdateLoginAttempt(id, event); err != nil {
+		return err
+	}
+	if event != loginFailed {
+		return nil
+	}
+
+	attempts, err := s.logins.FetchLoginAttempts(username, ip, now.Add(-s.loginPolicy.history()))
+	if err != nil {
+		return err
+	}
+	user, account, client := s.loginPolicy.loginThrottles(attempts, username, ip, id+1, now)
+	switch {
+	case account.locked:
+		log.Warnf("Locking out the account %s after too many failures", username)
+	case user.locked || client.locked:
+		log.Warnf("Locking out login of %s from %s after too many failures", username, ip)
+	default:
+		return nil
+	}
+
+	_, err = s.logins.RecordLoginAttempt(models.LoginAttempt{Username: username, IP: ip, Event: loginLocked, CreatedAt: now})
+	return err
+}
+
+// purgeLoginAttempts deletes the login history older than both windows, which
+// no longer counts towards any throttle.
+func (s *server) purgeLoginAttempts(now time.Time) error {
+	_, err := s.logins.PurgeLoginAttempts(now.Add(-s.loginPolicy.history()))
+	return err
+}
+
+// sweepLoginAttempts calls purgeLoginAttempts every interval until the process
+// exits.
+func (s *server) sweepLoginAttempts(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := s.purgeLoginAttempts(time.Now()); err != nil {
+			log.Error(err)
+		}
+	}
+}
@@ -1,20 +1,26 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/KunalDuran/weather-api/cache"
 	"github.com/KunalDuran/weather-api/data"
+	"github.com/KunalDuran/weather-api/notify"
+	"github.com/KunalDuran/weather-api/provider"
+	"github.com/KunalDuran/weather-api/ratelimit"
+	"github.com/KunalDuran/weather-api/util"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
 )
 
-var db *sql.DB
-var API_KEY string
-
 func main() {
 
 	err := godotenv.Load(".env")
@@ -22,21 +28,190 @@ func main() {
 		log.Fatalf("Error loading .env file: %s", err)
 	}
 
-	dbUser := os.Getenv("DB_USER")
-	dbPass := os.Getenv("DB_PASS")
-	API_KEY = os.Getenv("API_KEY")
-
-	dbHost := os.Getenv("DB_HOST")
-	dbPort := os.Getenv("DB_PORT")
-	dbName := os.Getenv("DB_NAME")
+	apiKey := os.Getenv("API_KEY")
 
-	db, err = data.InitDB(dbHost, dbPort, dbUser, dbPass, dbName)
+	db, err := openDB()
 	if err != nil {
 		log.Warn(err)
 		fmt.Println("Error connecting to database")
 		return
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(db, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if os.Getenv("MIGRATE_ON_START") != "false" {
+		applied, err := data.MigrateUp(db)
+		if err != nil {
+			log.Fatalf("Error migrating database: %s", err)
+		}
+		for _, migration := range applied {
+			log.Printf("Applied migration %d %s", migration.Version, migration.Name)
+		}
+	}
+
+	keys, err := loadTokenKeys()
+	if err != nil {
+		log.Fatalf("Error loading token signing keys: %s", err)
+	}
+	util.SetKeySet(keys)
+
+	weatherProvider, err := provider.New(provider.Config{
+		Name:    os.Getenv("WEATHER_PROVIDER"),
+		APIKey:  apiKey,
+		BaseURL: os.Getenv("WEATHER_PROVIDER_URL"),
+	})
+	if err != nil {
+		log.Fatalf("Error configuring weather provider: %s", err)
+	}
+
+	srv := newServer(data.NewSQLStore(db), weatherProvider)
+
+	if ttl := os.Getenv("CACHE_TTL"); ttl != "" {
+		srv.cacheTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("Invalid CACHE_TTL: %s", err)
+		}
+	}
+
+	switch os.Getenv("CACHE_BACKEND") {
+	case "", "memory":
+		size := 1000
+		if s := os.Getenv("CACHE_SIZE"); s != "" {
+			size, err = strconv.Atoi(s)
+			if err != nil {
+				log.Fatalf("Invalid CACHE_SIZE: %s", err)
+			}
+		}
+		srv.cache = cache.NewLRU(size)
+	case "database", "mysql":
+		srv.cache = cache.NewDatabase(db)
+	case "none":
+		srv.cache = cache.Nop{}
+	default:
+		log.Fatalf("Unknown CACHE_BACKEND %q", os.Getenv("CACHE_BACKEND"))
+	}
+
+	switch os.Getenv("RATE_LIMIT_BACKEND") {
+	case "", "memory":
+		srv.rateLimiter = ratelimit.NewMemory()
+	case "database", "mysql":
+		srv.rateLimiter = ratelimit.NewDatabase(db)
+	case "none":
+		srv.rateLimiter = nil
+	default:
+		log.Fatalf("Unknown RATE_LIMIT_BACKEND %q", os.Getenv("RATE_LIMIT_BACKEND"))
+	}
+
+	limits, err := ratelimit.ParseLimits(os.Getenv("RATE_LIMITS"))
+	if err != nil {
+		log.Fatalf("Invalid RATE_LIMITS: %s", err)
+	}
+	for route, limit := range limits {
+		srv.rateLimits[route] = limit
+	}
+
+	if n := os.Getenv("LOGIN_MAX_FAILURES"); n != "" {
+		srv.loginPolicy.UserLockout, err = strconv.Atoi(n)
+		if err != nil || srv.loginPolicy.UserLockout <= srv.loginPolicy.FreeFailures {
+			log.Fatalf("Invalid LOGIN_MAX_FAILURES %q", n)
+		}
+	}
+	if lockout := os.Getenv("LOGIN_LOCKOUT"); lockout != "" {
+		srv.loginPolicy.Window, err = time.ParseDuration(lockout)
+		if err != nil || srv.loginPolicy.Window <= 0 {
+			log.Fatalf("Invalid LOGIN_LOCKOUT %q", lockout)
+		}
+	}
+	if n := os.Getenv("LOGIN_ACCOUNT_MAX_FAILURES"); n != "" {
+		srv.loginPolicy.AccountLockout, err = strconv.Atoi(n)
+		if err != nil || srv.loginPolicy.AccountLockout <= srv.loginPolicy.FreeFailures {
+			log.Fatalf("Invalid LOGIN_ACCOUNT_MAX_FAILURES %q", n)
+		}
+	}
+	if lockout := os.Getenv("LOGIN_ACCOUNT_LOCKOUT"); lockout != "" {
+		srv.loginPolicy.AccountWindow, err = time.ParseDuration(lockout)
+		if err != nil || srv.loginPolicy.AccountWindow <= 0 {
+			log.Fatalf("Invalid LOGIN_ACCOUNT_LOCKOUT %q", lockout)
+		}
+	}
+
+	// Admins are bound to the ids of accounts that already exist, since a
+	// listed name nobody registered yet could be claimed by anyone.
+	for _, username := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		if username = strings.TrimSpace(username); username == "" {
+			continue
+		}
+		admin, err := srv.users.GetUserByUsername(username)
+		if err != nil {
+			log.Fatalf("ADMIN_USERS lists %q, which is not a registered user: %s", username, err)
+		}
+		srv.admins[admin.ID] = true
+	}
+
+	srv.storeForecasts = os.Getenv("STORE_FORECASTS") == "true"
+
+	if n := os.Getenv("LOOKUP_CONCURRENCY"); n != "" {
+		srv.lookupConcurrency, err = strconv.Atoi(n)
+		if err != nil || srv.lookupConcurrency < 1 {
+			log.Fatalf("Invalid LOOKUP_CONCURRENCY %q", n)
+		}
+	}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		srv.notifiers[notify.ChannelEmail] = notify.NewSMTP(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_FROM"))
+	}
+	if publicURL := os.Getenv("PUBLIC_URL"); publicURL != "" {
+		srv.publicURL = strings.TrimSuffix(publicURL, "/")
+	}
+
+	alertInterval := 5 * time.Minute
+	if interval := os.Getenv("ALERT_INTERVAL"); interval != "" {
+		alertInterval, err = time.ParseDuration(interval)
+		if err != nil || alertInterval <= 0 {
+			log.Fatalf("Invalid ALERT_INTERVAL %q", interval)
+		}
+	}
+
+	pollInterval := time.Minute
+	if interval := os.Getenv("POLL_INTERVAL"); interval != "" {
+		pollInterval, err = time.ParseDuration(interval)
+		if err != nil || pollInterval <= 0 {
+			log.Fatalf("Invalid POLL_INTERVAL %q", interval)
+		}
+	}
+
+	if n := os.Getenv("POLL_MAX_LOOKUPS"); n != "" {
+		srv.pollBudget, err = strconv.Atoi(n)
+		if err != nil || srv.pollBudget < 1 {
+			log.Fatalf("Invalid POLL_MAX_LOOKUPS %q", n)
+		}
+	}
+
+	if ttl := os.Getenv("REFRESH_TOKEN_TTL"); ttl != "" {
+		srv.refreshTokenTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("Invalid REFRESH_TOKEN_TTL: %s", err)
+		}
+	}
+
+	if err := srv.revoked.Sync(time.Now()); err != nil {
+		log.Fatalf("Error loading revoked tokens: %s", err)
+	}
+	go srv.revoked.sweep(time.Minute)
+	go srv.sweepLoginAttempts(time.Minute)
+	go srv.watchAlerts(alertInterval)
+	go srv.watchSchedules(pollInterval)
+	go srv.dispatcher.run(time.Second)
+
 	// to keep the connection alive
 	go func() {
 		for {
@@ -48,18 +223,96 @@ func main() {
 		}
 	}()
 
-	http.HandleFunc("/api/login", loginHandler)
-	http.HandleFunc("/api/register", registerHandler)
-	http.HandleFunc("/api/weather", AuthMiddleware(weatherHandler))
-	http.HandleFunc("/api/history", AuthMiddleware(getWeatherHistoryHandler))
-	http.HandleFunc("/api/history/delete", AuthMiddleware(deleteWeatherHistoryHandler))
-	http.HandleFunc("/api/history/bulkdelete", AuthMiddleware(bulkDeleteWeatherHistoryHandler))
+	httpServer := &http.Server{Addr: ":8080", Handler: srv.routes()}
+
+	// On SIGINT or SIGTERM, finish the requests and the webhook delivery in
+	// flight before exiting. Deliveries still pending are resumed on the next
+	// start.
+	shutdown := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+
+		log.Println("Shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Error(err)
+		}
+		srv.dispatcher.stop()
+		close(shutdown)
+	}()
 
 	log.Println("Server started on port 8080")
 
-	mux := http.DefaultServeMux
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdown
+}
 
-	loggedMux := loggingMiddleware(mux)
+// openDB connects to the database selected by DB_DRIVER.
+func openDB() (*data.DB, error) {
+	dbUser := os.Getenv("DB_USER")
+	dbPass := os.Getenv("DB_PASS")
+	dbHost := os.Getenv("DB_HOST")
+	dbPort := os.Getenv("DB_PORT")
+	dbName := os.Getenv("DB_NAME")
+
+	switch os.Getenv("DB_DRIVER") {
+	case "", "mysql":
+		return data.InitDB(dbHost, dbPort, dbUser, dbPass, dbName)
+	case "postgres":
+		sslMode := os.Getenv("DB_SSLMODE")
+		if sslMode == "" {
+			sslMode = "disable"
+		}
+		return data.InitPostgres(dbHost, dbPort, dbUser, dbPass, dbName, sslMode)
+	case "sqlite":
+		path := os.Getenv("DB_PATH")
+		if path == "" {
+			path = "weather.db"
+		}
+		return data.InitSQLite(path)
+	}
+	return nil, fmt.Errorf("unknown DB_DRIVER %q", os.Getenv("DB_DRIVER"))
+}
+
+// loadTokenKeys reads the access token signing configuration from the environment.
+func loadTokenKeys() (*util.KeySet, error) {
+	cfg := util.KeyConfig{
+		Algorithm:      os.Getenv("JWT_ALGORITHM"),
+		KeyID:          os.Getenv("JWT_KEY_ID"),
+		Secret:         os.Getenv("JWT_SECRET"),
+		PrivateKeyFile: os.Getenv("JWT_PRIVATE_KEY_FILE"),
+		Issuer:         os.Getenv("JWT_ISSUER"),
+		TTL:            24 * time.Hour,
+	}
+	if cfg.KeyID == "" {
+		cfg.KeyID = "default"
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = "weather-api"
+	}
+
+	if ttl := os.Getenv("JWT_ACCESS_TTL"); ttl != "" {
+		var err error
+		cfg.TTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	cfg.PreviousSecrets, err = util.ParseKeyList(os.Getenv("JWT_PREVIOUS_SECRETS"))
+	if err != nil {
+		return nil, err
+	}
+	cfg.PreviousPublicKeyFiles, err = util.ParseKeyList(os.Getenv("JWT_PREVIOUS_PUBLIC_KEYS"))
+	if err != nil {
+		return nil, err
+	}
 
-	log.Fatal(http.ListenAndServe(":8080", CorsMiddleware(loggedMux)))
+	return util.LoadKeySet(cfg)
 }
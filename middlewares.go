@@ -1,11 +1,14 @@
 package main
 
 import (
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/KunalDuran/weather-api/i18n"
 	"github.com/KunalDuran/weather-api/models"
 	"github.com/KunalDuran/weather-api/util"
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/sirupsen/logrus"
 )
 
@@ -21,7 +24,44 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// localizedWriter carries the language of the response messages, see
+// util.Localized.
+type localizedWriter struct {
+	http.ResponseWriter
+	lang string
+}
+
+func (w *localizedWriter) Language() string {
+	return w.lang
+}
+
+// languageMiddleware picks the language of the response messages: the lang
+// query parameter when it names a language with a catalog, otherwise the best
+// match for the Accept-Language header.
+func languageMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang, ok := i18n.Match(r.URL.Query().Get("lang"))
+		if !ok {
+			lang = i18n.Negotiate(r.Header.Get("Accept-Language"))
+		}
+
+		w.Header().Set("Content-Language", lang)
+		w.Header().Add("Vary", "Accept-Language")
+		next.ServeHTTP(&localizedWriter{ResponseWriter: w, lang: lang}, r)
+	})
+}
+
+// localize translates message into the language negotiated for w. Response
+// messages are translated by util.JSONResponse; this is for messages carried
+// in the response data.
+func localize(w http.ResponseWriter, message string) string {
+	if l, ok := w.(util.Localized); ok {
+		return i18n.Translate(l.Language(), message)
+	}
+	return message
+}
+
+func (s *server) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		token := r.Header.Get("Authorization")
 		if token == "" {
@@ -33,10 +73,7 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		claims, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-			return []byte("my-secret-key"), nil
-		})
-
+		claims, err := util.ParseToken(token)
 		if err != nil {
 			util.JSONResponse(w, http.StatusUnauthorized, &models.Response{
 				Status:  "error",
@@ -46,9 +83,17 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		id := claims.Claims.(jwt.MapClaims)["Subject"].(string)
+		if s.revoked.IsRevoked(claims.ID) {
+			util.JSONResponse(w, http.StatusUnauthorized, &models.Response{
+				Status:  "error",
+				Message: "Token has been revoked",
+				Data:    nil,
+			})
+			return
+		}
 
-		if id == "" {
+		principal, ok := principalFromClaims(claims)
+		if !ok {
 			util.JSONResponse(w, http.StatusUnauthorized, &models.Response{
 				Status:  "error",
 				Message: "Invalid token",
@@ -57,16 +102,79 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		next.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal)))
+	})
+}
+
+// RateLimitMiddleware limits how often each client calls a route, to the limit
+// configured for its path or otherwise the default one. It runs before
+// AuthMiddleware, so that requests with invalid or expired tokens are limited
+// too. Clients are told apart by the user id of a validly signed token, and
+// by IP address otherwise.
+func (s *server) RateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.rateLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		limit, ok := s.rateLimits[r.URL.Path]
+		if !ok {
+			limit = s.rateLimits[defaultRateLimit]
+		}
+		if limit.Burst < 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := "ip:" + util.ClientIP(r)
+		if token := r.Header.Get("Authorization"); token != "" {
+			if claims, err := util.ParseToken(token); err == nil {
+				if principal, ok := principalFromClaims(claims); ok {
+					client = "user:" + strconv.Itoa(principal.UserID)
+				}
+			}
+		}
+
+		result, err := s.rateLimiter.Take(r.URL.Path+" "+client, limit, time.Now())
+		if err != nil {
+			// An unavailable store should not take the API down with it
+			log.Errorf("Rate limiting %s: %s", r.URL.Path, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			util.JSONResponse(w, http.StatusTooManyRequests, &models.Response{
+				Status:  "error",
+				Message: "Too many requests, try again later.",
+				Data:    nil,
+			})
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
 
+// ceilSeconds returns d in whole seconds, rounded up so that clients waiting
+// that long are not refused again.
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
 // CorsMiddleware is a middleware function that adds the necessary CORS headers to the response.
 func CorsMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Expose-Headers", "Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset")
 
 		// If it's a preflight request, send an empty response with the necessary headers and return
 		if r.Method == http.MethodOptions {
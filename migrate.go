@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/KunalDuran/weather-api/data"
+)
+
+const migrateUsage = "usage: weather-api migrate up|down|status"
+
+// runMigrate implements the migrate subcommand: "up" applies all pending
+// migrations, "down" rolls back the latest one and "status" lists them.
+func runMigrate(db *data.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := data.MigrateUp(db)
+		for _, migration := range applied {
+			fmt.Printf("Applied migration %d %s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+	case "down":
+		migration, err := data.MigrateDown(db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Rolled back migration %d %s\n", migration.Version, migration.Name)
+	case "status":
+		statuses, err := data.FetchMigrationStatus(db)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // User represents the user data
 type User struct {
@@ -9,6 +12,39 @@ type User struct {
 	Password    string    `json:"-"`
 	DateOfBirth time.Time `json:"date_of_birth"`
 	CreatedAt   time.Time `json:"created_at"`
+	Units       string    `json:"units"`
+	Lang        string    `json:"lang"`
+}
+
+// Preferences are the per-user defaults applied when a request does not say otherwise
+type Preferences struct {
+	Units string `json:"units"`
+	Lang  string `json:"lang"`
+}
+
+// UnitLabels names the unit of each converted measurement in a response
+type UnitLabels struct {
+	System      string `json:"system"`
+	Temperature string `json:"temperature"`
+	WindSpeed   string `json:"wind_speed"`
+	Visibility  string `json:"visibility"`
+}
+
+// Session represents a refresh token issued to one of the user's devices
+type Session struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	TokenHash  string     `json:"-"`
+	Device     string     `json:"device"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt time.Time  `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	// AccessTokenID is the id of the access token last issued for the
+	// session, which is revoked with it.
+	AccessTokenID   string    `json:"-"`
+	AccessExpiresAt time.Time `json:"-"`
 }
 
 // WeatherResponse represents the weather data received from the OpenWeatherMap API
@@ -29,7 +65,7 @@ type WeatherResponse struct {
 		Pressure  int     `json:"pressure"`
 		Humidity  int     `json:"humidity"`
 	} `json:"main"`
-	Visibility int `json:"visibility"`
+	Visibility float64 `json:"visibility"`
 	Wind       struct {
 		Speed float64 `json:"speed"`
 		Deg   int     `json:"deg"`
@@ -50,6 +86,243 @@ type WeatherResponse struct {
 	Name      string    `json:"name"`
 	Cod       int       `json:"cod"`
 	CreatedAt time.Time `json:"created_at"`
+	// Provider names the upstream source, ProviderResponseMS is how long it
+	// took to answer and Raw is its response exactly as received.
+	Provider           string          `json:"provider,omitempty"`
+	ProviderResponseMS int             `json:"provider_response_ms,omitempty"`
+	Raw                json.RawMessage `json:"raw,omitempty"`
+	// Units labels the measurements once they are converted for a response.
+	// Stored lookups are always in standard units.
+	Units *UnitLabels `json:"units,omitempty"`
+}
+
+// ForecastItem represents a single 3-hour step of the OpenWeatherMap 5-day forecast
+type ForecastItem struct {
+	Dt   int `json:"dt"`
+	Main struct {
+		Temp      float64 `json:"temp"`
+		FeelsLike float64 `json:"feels_like"`
+		TempMin   float64 `json:"temp_min"`
+		TempMax   float64 `json:"temp_max"`
+		Pressure  int     `json:"pressure"`
+		Humidity  int     `json:"humidity"`
+	} `json:"main"`
+	Weathers []Weather `json:"weather"`
+	Clouds   struct {
+		All int `json:"all"`
+	} `json:"clouds"`
+	Wind struct {
+		Speed float64 `json:"speed"`
+		Deg   int     `json:"deg"`
+		Gust  float64 `json:"gust"`
+	} `json:"wind"`
+	Visibility float64 `json:"visibility"`
+	Pop        float64 `json:"pop"`
+	DtTxt      string  `json:"dt_txt"`
+}
+
+// ForecastCity describes the location a forecast was produced for
+type ForecastCity struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Coord struct {
+		Lon float64 `json:"lon"`
+		Lat float64 `json:"lat"`
+	} `json:"coord"`
+	Country    string `json:"country"`
+	Population int    `json:"population"`
+	Timezone   int    `json:"timezone"`
+	Sunrise    int    `json:"sunrise"`
+	Sunset     int    `json:"sunset"`
+}
+
+// DailyForecast is the aggregate of all forecast steps falling on one local day
+type DailyForecast struct {
+	Date        string  `json:"date"`
+	TempMin     float64 `json:"temp_min"`
+	TempMax     float64 `json:"temp_max"`
+	TempAvg     float64 `json:"temp_avg"`
+	Humidity    int     `json:"humidity"`
+	WindSpeed   float64 `json:"wind_speed"`
+	Pop         float64 `json:"pop"`
+	Main        string  `json:"main"`
+	Description string  `json:"description"`
+	Icon        string  `json:"icon"`
+}
+
+// ForecastResponse represents the 5-day/3-hour forecast and its daily aggregation
+type ForecastResponse struct {
+	ForecastID int             `json:"forecast_id"`
+	City       ForecastCity    `json:"city"`
+	List       []ForecastItem  `json:"list"`
+	Daily      []DailyForecast `json:"daily"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Units      *UnitLabels     `json:"units,omitempty"`
+}
+
+// Location identifies where to look up weather. Exactly one of City, Lat/Lon,
+// CityID or Zip (optionally with Country) is expected to be set.
+type Location struct {
+	City    string   `json:"city,omitempty"`
+	Lat     *float64 `json:"lat,omitempty"`
+	Lon     *float64 `json:"lon,omitempty"`
+	CityID  int      `json:"city_id,omitempty"`
+	Zip     string   `json:"zip,omitempty"`
+	Country string   `json:"country,omitempty"`
+}
+
+// FavoriteLocation is a location a user saved under a label of their own.
+// Favorites are listed by Position, lowest first.
+type FavoriteLocation struct {
+	ID       int    `json:"id"`
+	Label    string `json:"label"`
+	Position int    `json:"position"`
+	Location
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// FavoriteWeather is the current weather at one favorite, or why it could
+// not be fetched
+type FavoriteWeather struct {
+	Favorite FavoriteLocation `json:"favorite"`
+	Weather  *WeatherResponse `json:"weather"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// AlertRule notifies its owner through Channel when the weather at its
+// location is below TempBelow, above TempAbove or has the condition Condition.
+// Thresholds are in Units. Any criterion that is set can trigger the rule.
+type AlertRule struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"-"`
+	Name   string `json:"name"`
+	Location
+	TempBelow       *float64 `json:"temp_below,omitempty"`
+	TempAbove       *float64 `json:"temp_above,omitempty"`
+	Condition       string   `json:"condition,omitempty"`
+	Units           string   `json:"units"`
+	Channel         string   `json:"channel"`
+	Target          string   `json:"target"`
+	CooldownMinutes int      `json:"cooldown_minutes"`
+	Enabled         bool     `json:"enabled"`
+	// Active reports whether the rule matched when it was last evaluated.
+	// NotificationCount changes with every notification sent for the rule.
+	Active            bool       `json:"active"`
+	NotificationCount int        `json:"notification_count"`
+	LastNotifiedAt    *time.Time `json:"last_notified_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+// AlertEmailAddress is an address a user wants alert emails sent to. Alerts
+// are only mailed there once the token sent to it was used to confirm it.
+type AlertEmailAddress struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"-"`
+	Address     string     `json:"address"`
+	TokenHash   string     `json:"-"`
+	SentAt      time.Time  `json:"sent_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Webhook is a URL a user registered to receive the events of their account.
+// An empty Events subscribes to every event.
+type Webhook struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"-"`
+	URL    string `json:"url"`
+	// Secret signs the deliveries. It is only returned when the webhook is created.
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent to a webhook, with the outcome of the
+// latest attempt.
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	UserID         int             `json:"-"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at,omitempty"`
+}
+
+// PollSchedule is a location whose current weather is recorded in the
+// history of its owner every IntervalMinutes, without the owner asking.
+type PollSchedule struct {
+	ID     int `json:"id"`
+	UserID int `json:"-"`
+	Location
+	IntervalMinutes int  `json:"interval_minutes"`
+	Enabled         bool `json:"enabled"`
+	// RunCount changes with every run of the schedule.
+	RunCount  int        `json:"run_count"`
+	NextRunAt time.Time  `json:"next_run_at"`
+	LastRunAt *time.Time `json:"last_run_at"`
+	// LastError says why the latest run failed, if it did.
+	LastError string    `json:"last_error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginAttempt is an event in the login history of a username: a successful
+// or failed login from IP, the account being locked after too many failures,
+// or an admin unlocking it.
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	IP        string    `json:"ip"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// BatchWeatherItem is the current weather at one location of a batch lookup,
+// or why it could not be fetched
+type BatchWeatherItem struct {
+	Location Location         `json:"location"`
+	Weather  *WeatherResponse `json:"weather"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// BatchWeatherResponse lists the outcome of a batch lookup in the order the
+// locations were requested
+type BatchWeatherResponse struct {
+	Items     []BatchWeatherItem `json:"items"`
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+}
+
+// HistoryQuery selects a page of a user's weather history
+type HistoryQuery struct {
+	City      string
+	Country   string
+	Condition string
+	From      *time.Time
+	To        *time.Time
+	MinTemp   *float64
+	MaxTemp   *float64
+	// Sort is "created_at", "temp" or "city".
+	Sort string
+	// Order is "asc" or "desc".
+	Order  string
+	Limit  int
+	Cursor string
+	// IncludeRaw adds the stored upstream payload to each item.
+	IncludeRaw bool
+}
+
+// HistoryPage is one page of a user's weather history
+type HistoryPage struct {
+	Items      []WeatherResponse `json:"items"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+	TotalCount int               `json:"total_count"`
+	Limit      int               `json:"limit"`
 }
 
 // StandardResponse represents the standard response from the OpenWeatherMap API
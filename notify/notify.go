@@ -0,0 +1,79 @@
+// Package notify delivers alert notifications to users.
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+)
+
+// Channels a notification can be sent over.
+const (
+	ChannelWebhook = "webhook"
+	ChannelEmail   = "email"
+)
+
+// Notification tells a user that one of their alert rules matched.
+type Notification struct {
+	RuleID      int                    `json:"rule_id"`
+	RuleName    string                 `json:"rule_name"`
+	Reasons     []string               `json:"reasons"`
+	Weather     models.WeatherResponse `json:"weather"`
+	TriggeredAt time.Time              `json:"triggered_at"`
+}
+
+// Subject is a one line summary of n.
+func (n Notification) Subject() string {
+	return fmt.Sprintf("Weather alert: %s", n.RuleName)
+}
+
+// Text is a plain text description of n.
+func (n Notification) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Your alert %q matched for %s at %s.\n\n", n.RuleName, n.Weather.Name, n.TriggeredAt.UTC().Format(time.RFC1123))
+	for _, reason := range n.Reasons {
+		fmt.Fprintf(&b, "- %s\n", reason)
+	}
+	return b.String()
+}
+
+// Notifier sends notifications over one channel.
+type Notifier interface {
+	// Notify sends n to target, whose meaning depends on the channel: a URL
+	// for webhooks and an address for email.
+	Notify(target string, n Notification) error
+}
+
+// Confirmation asks the owner of an address to confirm that they want the
+// alerts of a user sent there, by posting Token to URL.
+type Confirmation struct {
+	Username string
+	Token    string
+	URL      string
+	SentAt   time.Time
+}
+
+// Subject is a one line summary of c.
+func (c Confirmation) Subject() string {
+	return "Confirm weather alerts"
+}
+
+// Text is a plain text description of c. It leaves out anything the user
+// chose, such as the names of their rules.
+func (c Confirmation) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "The Weather API user %s wants weather alerts sent to this address.\n\n", c.Username)
+	fmt.Fprintf(&b, "To receive them, post {\"token\": \"%s\"} to %s.\n\n", c.Token, c.URL)
+	b.WriteString("If you did not expect this, ignore this email and no alerts will be sent to you.\n")
+	return b.String()
+}
+
+// Confirmer is a Notifier whose targets have to confirm that they want
+// notifications before any are sent to them.
+type Confirmer interface {
+	Notifier
+	// Confirm asks the owner of target to confirm.
+	Confirm(target string, c Confirmation) error
+}
@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/KunalDuran/weather-api/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testNotification() Notification {
+	n := Notification{
+		RuleID:      7,
+		RuleName:    "Hot",
+		Reasons:     []string{"Temperature 31 °C is above 30 °C"},
+		TriggeredAt: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	n.Weather.Name = "Pune"
+	return n
+}
+
+func TestWebhook(t *testing.T) {
+	var received map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+	}))
+	defer srv.Close()
+
+	// The test server listens on loopback, which NewWebhook refuses
+	err := NewWebhook(time.Second).Notify(srv.URL, testNotification())
+	assert.ErrorIs(t, err, util.ErrNonPublicAddress)
+	assert.Nil(t, received)
+
+	hook := &Webhook{Client: &http.Client{Timeout: time.Second}}
+	require.NoError(t, hook.Notify(srv.URL, testNotification()))
+	assert.Equal(t, "alert.triggered", received["event"])
+	assert.Equal(t, float64(7), received["rule_id"])
+	assert.Equal(t, "Pune", received["weather"].(map[string]interface{})["name"])
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	assert.Error(t, hook.Notify(failing.URL, testNotification()))
+}
+
+func TestSMTP(t *testing.T) {
+	s := NewSMTP("mail.example.com", "587", "alerts", "secret", "alerts@example.com")
+
+	var addr string
+	var to []string
+	var msg []byte
+	s.send = func(a string, auth smtp.Auth, from string, recipients []string, m []byte) error {
+		addr, to, msg = a, recipients, m
+		assert.NotNil(t, auth)
+		assert.Equal(t, "alerts@example.com", from)
+		return nil
+	}
+
+	require.NoError(t, s.Notify("user@example.com", testNotification()))
+	assert.Equal(t, "mail.example.com:587", addr)
+	assert.Equal(t, []string{"user@example.com"}, to)
+
+	headers, body, found := strings.Cut(string(msg), "\r\n\r\n")
+	require.True(t, found)
+	assert.Contains(t, headers, "To: user@example.com\r\n")
+	assert.Contains(t, headers, "Subject: Weather alert: Hot\r\n")
+	assert.Contains(t, body, "- Temperature 31 °C is above 30 °C\r\n")
+}
+
+func TestSMTPConfirm(t *testing.T) {
+	s := NewSMTP("mail.example.com", "587", "", "", "alerts@example.com")
+
+	var msg []byte
+	s.send = func(a string, auth smtp.Auth, from string, recipients []string, m []byte) error {
+		msg = m
+		assert.Nil(t, auth)
+		assert.Equal(t, []string{"team@example.com"}, recipients)
+		return nil
+	}
+
+	var confirmer Confirmer = s
+	require.NoError(t, confirmer.Confirm("team@example.com", Confirmation{
+		Username: "user@example.com",
+		Token:    "abc",
+		URL:      "https://weather.example.com/api/alerts/confirm",
+		SentAt:   time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}))
+
+	headers, body, found := strings.Cut(string(msg), "\r\n\r\n")
+	require.True(t, found)
+	assert.Contains(t, headers, "Subject: Confirm weather alerts\r\n")
+	assert.Contains(t, body, "user@example.com wants weather alerts")
+	assert.Contains(t, body, `post {"token": "abc"} to https://weather.example.com/api/alerts/confirm.`)
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTP emails notifications through a mail server.
+type SMTP struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+
+	// send is smtp.SendMail, replaced in tests.
+	send func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// NewSMTP returns an SMTP notifier sending from from through host:port,
+// authenticating when username is not empty.
+func NewSMTP(host, port, username, password, from string) *SMTP {
+	return &SMTP{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+		From:     from,
+		send:     smtp.SendMail,
+	}
+}
+
+func (s *SMTP) Notify(target string, n Notification) error {
+	return s.mail(target, s.message(target, n.Subject(), n.Text(), n.TriggeredAt))
+}
+
+// Confirm emails c to target.
+func (s *SMTP) Confirm(target string, c Confirmation) error {
+	return s.mail(target, s.message(target, c.Subject(), c.Text(), c.SentAt))
+}
+
+// mail sends msg to target through the mail server.
+func (s *SMTP) mail(target string, msg []byte) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, s.Host)
+	}
+
+	return s.send(net.JoinHostPort(s.Host, s.Port), auth, s.From, []string{target}, msg)
+}
+
+// message formats a plain text email to target.
+func (s *SMTP) message(target, subject, text string, date time.Time) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", s.From)
+	fmt.Fprintf(&b, "To: %s\r\n", target)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.Write(bytes.ReplaceAll([]byte(text), []byte("\n"), []byte("\r\n")))
+	return b.Bytes()
+}
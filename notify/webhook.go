@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/KunalDuran/weather-api/util"
+)
+
+// Webhook posts notifications as JSON to the target URL.
+type Webhook struct {
+	Client *http.Client
+}
+
+// NewWebhook returns a Webhook whose requests time out after timeout. It only
+// posts to public addresses and does not follow redirects, see
+// util.NewPublicHTTPClient.
+func NewWebhook(timeout time.Duration) *Webhook {
+	return &Webhook{Client: util.NewPublicHTTPClient(timeout)}
+}
+
+func (h *Webhook) Notify(target string, n Notification) error {
+	payload, err := json.Marshal(struct {
+		Event string `json:"event"`
+		Notification
+	}{
+		Event:        "alert.triggered",
+		Notification: n,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with status %d", target, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+// Principal is the authenticated user behind a request.
+type Principal struct {
+	UserID    int
+	Username  string
+	Roles     []string
+	TokenID   string
+	ExpiresAt time.Time
+}
+
+// HasRole reports whether the principal was granted role.
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type contextKey int
+
+const principalKey contextKey = iota
+
+// principalFromClaims builds a Principal from verified token claims.
+func principalFromClaims(claims *util.Claims) (*Principal, bool) {
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil || userID <= 0 {
+		return nil, false
+	}
+
+	return &Principal{
+		UserID:    userID,
+		Username:  claims.Username,
+		Roles:     claims.Roles,
+		TokenID:   claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, true
+}
+
+// withPrincipal returns a copy of ctx carrying p.
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// principalFromContext returns the principal stored by AuthMiddleware.
+func principalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok && p != nil
+}
+
+// requirePrincipal returns the request's principal, writing a 401 response and
+// returning false when the handler was reached without one.
+func requirePrincipal(w http.ResponseWriter, r *http.Request) (*Principal, bool) {
+	p, ok := principalFromContext(r.Context())
+	if !ok {
+		util.JSONResponse(w, http.StatusUnauthorized, &models.Response{
+			Status:  "error",
+			Message: "Invalid token",
+			Data:    nil,
+		})
+		return nil, false
+	}
+	return p, true
+}
@@ -0,0 +1,187 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+)
+
+var (
+	zipPattern     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 -]{1,9}$`)
+	countryPattern = regexp.MustCompile(`^[A-Za-z]{2}$`)
+)
+
+// LocationError describes why a requested location was rejected.
+type LocationError struct {
+	Message string
+}
+
+func (e *LocationError) Error() string {
+	return e.Message
+}
+
+// ErrUnsupportedLocation is returned when a Location has no lookup mode set.
+var ErrUnsupportedLocation = errors.New("unsupported location")
+
+// LocationFromQuery reads exactly one of city, lat/lon, id or zip (with an optional
+// country) from query parameters and validates it.
+func LocationFromQuery(query url.Values) (models.Location, error) {
+	loc := models.Location{
+		City:    strings.TrimSpace(query.Get("city")),
+		Zip:     strings.TrimSpace(query.Get("zip")),
+		Country: strings.TrimSpace(query.Get("country")),
+	}
+
+	lat, lon := query.Get("lat"), query.Get("lon")
+	if lat != "" || lon != "" {
+		if lat == "" || lon == "" {
+			return loc, &LocationError{"Both lat and lon are required."}
+		}
+		latValue, err := strconv.ParseFloat(lat, 64)
+		if err != nil {
+			return loc, &LocationError{"Invalid latitude."}
+		}
+		lonValue, err := strconv.ParseFloat(lon, 64)
+		if err != nil {
+			return loc, &LocationError{"Invalid longitude."}
+		}
+		loc.Lat, loc.Lon = &latValue, &lonValue
+	}
+
+	if id := query.Get("id"); id != "" {
+		idValue, err := strconv.Atoi(id)
+		if err != nil {
+			return loc, &LocationError{"Invalid city id."}
+		}
+		loc.CityID = idValue
+	}
+
+	return loc, ValidateLocation(loc)
+}
+
+// ValidateLocation checks that exactly one lookup mode is set and that its values are in range.
+func ValidateLocation(loc models.Location) error {
+	modes := 0
+	if loc.City != "" {
+		modes++
+	}
+	if loc.Lat != nil || loc.Lon != nil {
+		modes++
+	}
+	if loc.CityID != 0 {
+		modes++
+	}
+	if loc.Zip != "" {
+		modes++
+	}
+
+	if modes == 0 {
+		return &LocationError{"City name, lat/lon, id or zip is required."}
+	}
+	if modes > 1 {
+		return &LocationError{"Provide only one of city, lat/lon, id or zip."}
+	}
+
+	switch {
+	case loc.City != "":
+		if len(loc.City) > 100 {
+			return &LocationError{"City name is too long."}
+		}
+	case loc.Lat != nil || loc.Lon != nil:
+		if loc.Lat == nil || loc.Lon == nil {
+			return &LocationError{"Both lat and lon are required."}
+		}
+		// Every comparison with NaN is false, so it has to be ruled out
+		// before the ranges are checked.
+		if math.IsNaN(*loc.Lat) || math.IsInf(*loc.Lat, 0) || *loc.Lat < -90 || *loc.Lat > 90 {
+			return &LocationError{"Latitude must be between -90 and 90."}
+		}
+		if math.IsNaN(*loc.Lon) || math.IsInf(*loc.Lon, 0) || *loc.Lon < -180 || *loc.Lon > 180 {
+			return &LocationError{"Longitude must be between -180 and 180."}
+		}
+	case loc.CityID != 0:
+		if loc.CityID < 0 {
+			return &LocationError{"Invalid city id."}
+		}
+	case loc.Zip != "":
+		if !zipPattern.MatchString(loc.Zip) {
+			return &LocationError{"Invalid zip code."}
+		}
+	}
+
+	if loc.Country != "" {
+		if loc.Zip == "" {
+			return &LocationError{"Country can only be used with zip."}
+		}
+		if !countryPattern.MatchString(loc.Country) {
+			return &LocationError{"Invalid country code, use a two letter ISO 3166 code."}
+		}
+	}
+
+	return nil
+}
+
+// LocationKey returns a normalized string identifying loc, suitable for cache keys.
+// Coordinates are rounded to three decimal places (roughly 100 metres).
+func LocationKey(loc models.Location) string {
+	switch {
+	case loc.City != "":
+		return "city:" + loc.City
+	case loc.Lat != nil && loc.Lon != nil:
+		return fmt.Sprintf("coord:%.3f,%.3f", *loc.Lat, *loc.Lon)
+	case loc.CityID != 0:
+		return "id:" + strconv.Itoa(loc.CityID)
+	default:
+		return "zip:" + loc.Zip + "," + loc.Country
+	}
+}
+
+// Current fetches the current conditions for loc using whichever lookup mode it
+// has set, recording which provider answered and how long it took.
+func Current(p WeatherProvider, loc models.Location, opts Options) (*models.WeatherResponse, error) {
+	start := time.Now()
+	weather, err := current(p, loc, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	weather.Provider = p.Name()
+	weather.ProviderResponseMS = int(time.Since(start).Milliseconds())
+	return weather, nil
+}
+
+func current(p WeatherProvider, loc models.Location, opts Options) (*models.WeatherResponse, error) {
+	switch {
+	case loc.City != "":
+		return p.CurrentByCity(loc.City, opts)
+	case loc.Lat != nil && loc.Lon != nil:
+		return p.CurrentByCoordinates(*loc.Lat, *loc.Lon, opts)
+	case loc.CityID != 0:
+		return p.CurrentByCityID(loc.CityID, opts)
+	case loc.Zip != "":
+		return p.CurrentByZip(loc.Zip, loc.Country, opts)
+	}
+	return nil, ErrUnsupportedLocation
+}
+
+// Forecast fetches the forecast for loc using whichever lookup mode it has set.
+func Forecast(p WeatherProvider, loc models.Location, opts Options) (*models.ForecastResponse, error) {
+	switch {
+	case loc.City != "":
+		return p.ForecastByCity(loc.City, opts)
+	case loc.Lat != nil && loc.Lon != nil:
+		return p.ForecastByCoordinates(*loc.Lat, *loc.Lon, opts)
+	case loc.CityID != 0:
+		return p.ForecastByCityID(loc.CityID, opts)
+	case loc.Zip != "":
+		return p.ForecastByZip(loc.Zip, loc.Country, opts)
+	}
+	return nil, ErrUnsupportedLocation
+}
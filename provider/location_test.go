@@ -0,0 +1,55 @@
+package provider
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocationFromQuery(t *testing.T) {
+	loc, err := LocationFromQuery(url.Values{"lat": {"18.5204"}, "lon": {"73.8567"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 18.5204, *loc.Lat)
+	assert.Equal(t, 73.8567, *loc.Lon)
+	assert.Equal(t, "coord:18.520,73.857", LocationKey(loc))
+
+	loc, err = LocationFromQuery(url.Values{"zip": {"411001"}, "country": {"IN"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "411001", loc.Zip)
+	assert.Equal(t, "IN", loc.Country)
+
+	loc, err = LocationFromQuery(url.Values{"id": {"1259229"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1259229, loc.CityID)
+
+	// Coordinates on the equator and prime meridian are valid
+	_, err = LocationFromQuery(url.Values{"lat": {"0"}, "lon": {"0"}})
+	assert.NoError(t, err)
+}
+
+func TestLocationFromQueryInvalid(t *testing.T) {
+	invalidQueries := []url.Values{
+		{},
+		{"lat": {"18.52"}},
+		{"lat": {"91"}, "lon": {"73.85"}},
+		{"lat": {"18.52"}, "lon": {"-181"}},
+		{"lat": {"north"}, "lon": {"73.85"}},
+		{"lat": {"NaN"}, "lon": {"73.85"}},
+		{"lat": {"18.52"}, "lon": {"NaN"}},
+		{"lat": {"+Inf"}, "lon": {"73.85"}},
+		{"lat": {"18.52"}, "lon": {"-Inf"}},
+		{"id": {"abc"}},
+		{"id": {"-5"}},
+		{"zip": {"4110&appid=x"}},
+		{"zip": {"411001"}, "country": {"India"}},
+		{"city": {"Pune"}, "country": {"IN"}},
+		{"city": {"Pune"}, "zip": {"411001"}},
+	}
+
+	for _, query := range invalidQueries {
+		_, err := LocationFromQuery(query)
+		var locErr *LocationError
+		assert.ErrorAs(t, err, &locErr, "query %v", query)
+	}
+}
@@ -0,0 +1,168 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+)
+
+const openWeatherMapBaseURL = "https://api.openweathermap.org/data/2.5"
+
+// openWeatherMapTimeout bounds a whole lookup, so that a hung upstream cannot
+// hold up the scheduler and alert evaluator, which look up one batch at a time.
+const openWeatherMapTimeout = 10 * time.Second
+
+// OpenWeatherMap is a WeatherProvider backed by the OpenWeatherMap API.
+type OpenWeatherMap struct {
+	APIKey  string
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewOpenWeatherMap returns an OpenWeatherMap client using the public API
+// endpoint, whose lookups time out after openWeatherMapTimeout.
+func NewOpenWeatherMap(apiKey string) *OpenWeatherMap {
+	return &OpenWeatherMap{
+		APIKey:  apiKey,
+		BaseURL: openWeatherMapBaseURL,
+		Client:  &http.Client{Timeout: openWeatherMapTimeout},
+	}
+}
+
+func (o *OpenWeatherMap) Name() string {
+	return "openweathermap"
+}
+
+func (o *OpenWeatherMap) CurrentByCity(city string, opts Options) (*models.WeatherResponse, error) {
+	return o.current(cityParams(city), opts)
+}
+
+func (o *OpenWeatherMap) CurrentByCoordinates(lat, lon float64, opts Options) (*models.WeatherResponse, error) {
+	return o.current(coordinateParams(lat, lon), opts)
+}
+
+func (o *OpenWeatherMap) CurrentByCityID(id int, opts Options) (*models.WeatherResponse, error) {
+	return o.current(cityIDParams(id), opts)
+}
+
+func (o *OpenWeatherMap) CurrentByZip(zip, country string, opts Options) (*models.WeatherResponse, error) {
+	return o.current(zipParams(zip, country), opts)
+}
+
+func (o *OpenWeatherMap) ForecastByCity(city string, opts Options) (*models.ForecastResponse, error) {
+	return o.forecast(cityParams(city), opts)
+}
+
+func (o *OpenWeatherMap) ForecastByCoordinates(lat, lon float64, opts Options) (*models.ForecastResponse, error) {
+	return o.forecast(coordinateParams(lat, lon), opts)
+}
+
+func (o *OpenWeatherMap) ForecastByCityID(id int, opts Options) (*models.ForecastResponse, error) {
+	return o.forecast(cityIDParams(id), opts)
+}
+
+func (o *OpenWeatherMap) ForecastByZip(zip, country string, opts Options) (*models.ForecastResponse, error) {
+	return o.forecast(zipParams(zip, country), opts)
+}
+
+func cityParams(city string) url.Values {
+	params := url.Values{}
+	params.Set("q", city)
+	return params
+}
+
+func coordinateParams(lat, lon float64) url.Values {
+	params := url.Values{}
+	params.Set("lat", strconv.FormatFloat(lat, 'f', -1, 64))
+	params.Set("lon", strconv.FormatFloat(lon, 'f', -1, 64))
+	return params
+}
+
+func cityIDParams(id int) url.Values {
+	params := url.Values{}
+	params.Set("id", strconv.Itoa(id))
+	return params
+}
+
+func zipParams(zip, country string) url.Values {
+	params := url.Values{}
+	if country != "" {
+		zip += "," + country
+	}
+	params.Set("zip", zip)
+	return params
+}
+
+func (o *OpenWeatherMap) current(params url.Values, opts Options) (*models.WeatherResponse, error) {
+	body, err := o.get("/weather", params, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var weatherResponse models.WeatherResponse
+	if err := json.Unmarshal(body, &weatherResponse); err != nil {
+		return nil, err
+	}
+	weatherResponse.Raw = body
+
+	return &weatherResponse, nil
+}
+
+func (o *OpenWeatherMap) forecast(params url.Values, opts Options) (*models.ForecastResponse, error) {
+	body, err := o.get("/forecast", params, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var forecastResponse models.ForecastResponse
+	if err := json.Unmarshal(body, &forecastResponse); err != nil {
+		return nil, err
+	}
+
+	return &forecastResponse, nil
+}
+
+func (o *OpenWeatherMap) get(path string, params url.Values, opts Options) ([]byte, error) {
+	params.Set("appid", o.APIKey)
+	if opts.Units != "" {
+		params.Set("units", opts.Units)
+	}
+	if opts.Lang != "" {
+		params.Set("lang", opts.Lang)
+	}
+
+	resp, err := o.Client.Get(o.BaseURL + path + "?" + params.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("openweathermap: %w", ErrRateLimited)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errorResp models.StandardResponse
+		if err := json.Unmarshal(body, &errorResp); err == nil && errorResp.Message != "" {
+			return nil, fmt.Errorf("openweathermap: %s (status %d)", errorResp.Message, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("openweathermap: unexpected status %d", resp.StatusCode)
+	}
+
+	return body, nil
+}
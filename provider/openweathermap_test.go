@@ -0,0 +1,135 @@
+package provider
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenWeatherMapCurrentByCity(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/weather", r.URL.Path)
+		assert.Equal(t, "New Delhi", r.URL.Query().Get("q"))
+		assert.Equal(t, "test-key", r.URL.Query().Get("appid"))
+		w.Write([]byte(`{"name":"New Delhi","weather":[{"id":800,"main":"Clear"}],"main":{"temp":300.5}}`))
+	}))
+	defer server.Close()
+
+	p := NewOpenWeatherMap("test-key")
+	p.BaseURL = server.URL
+
+	weather, err := p.CurrentByCity("New Delhi", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "New Delhi", weather.Name)
+	assert.Equal(t, 300.5, weather.Main.Temp)
+	assert.Equal(t, "Clear", weather.Weathers[0].Main)
+}
+
+func TestCurrentKeepsRawPayload(t *testing.T) {
+	payload := `{"name":"Pune","rain":{"1h":0.25},"wind":{"speed":3.1,"gust":7.2}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(payload))
+	}))
+	defer server.Close()
+
+	p := NewOpenWeatherMap("test-key")
+	p.BaseURL = server.URL
+
+	weather, err := Current(p, models.Location{City: "Pune"}, Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "openweathermap", weather.Provider)
+	assert.GreaterOrEqual(t, weather.ProviderResponseMS, 0)
+	assert.JSONEq(t, payload, string(weather.Raw))
+}
+
+func TestOpenWeatherMapForecastByCoordinates(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/forecast", r.URL.Path)
+		assert.Equal(t, "18.52", r.URL.Query().Get("lat"))
+		assert.Equal(t, "73.85", r.URL.Query().Get("lon"))
+		assert.Equal(t, "metric", r.URL.Query().Get("units"))
+		w.Write([]byte(`{"city":{"name":"Pune","timezone":19800},"list":[{"dt":1690675200,"main":{"temp":24.1}}]}`))
+	}))
+	defer server.Close()
+
+	p := NewOpenWeatherMap("test-key")
+	p.BaseURL = server.URL
+
+	forecast, err := p.ForecastByCoordinates(18.52, 73.85, Options{Units: "metric"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Pune", forecast.City.Name)
+	assert.Len(t, forecast.List, 1)
+	assert.Equal(t, 24.1, forecast.List[0].Main.Temp)
+}
+
+func TestOpenWeatherMapCurrentByZipEscapesQuery(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "SW1A 1AA,GB", r.URL.Query().Get("zip"))
+		assert.Equal(t, "test-key", r.URL.Query().Get("appid"))
+		w.Write([]byte(`{"name":"London"}`))
+	}))
+	defer server.Close()
+
+	p := NewOpenWeatherMap("test-key")
+	p.BaseURL = server.URL
+
+	weather, err := p.CurrentByZip("SW1A 1AA", "GB", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "London", weather.Name)
+}
+
+func TestOpenWeatherMapNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"cod":"404","message":"city not found"}`))
+	}))
+	defer server.Close()
+
+	p := NewOpenWeatherMap("test-key")
+	p.BaseURL = server.URL
+
+	_, err := p.CurrentByCity("Atlantis", Options{})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestOpenWeatherMapRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"cod":429,"message":"Your account is temporary blocked due to exceeding of requests limitation of your subscription type."}`))
+	}))
+	defer server.Close()
+
+	p := NewOpenWeatherMap("test-key")
+	p.BaseURL = server.URL
+
+	_, err := p.CurrentByCity("Pune", Options{})
+	assert.ErrorIs(t, err, ErrRateLimited)
+}
+
+func TestNewUnknownProvider(t *testing.T) {
+	_, err := New(Config{Name: "nope"})
+	assert.Error(t, err)
+}
+
+func TestOpenWeatherMapTimesOut(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	p := NewOpenWeatherMap("test-key")
+	assert.Equal(t, openWeatherMapTimeout, p.Client.Timeout)
+	p.BaseURL = server.URL
+	p.Client.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, err := p.CurrentByCity("Pune", Options{})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
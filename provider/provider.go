@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/KunalDuran/weather-api/models"
+)
+
+// ErrNotFound is returned by a provider when the requested location is unknown upstream.
+var ErrNotFound = errors.New("location not found")
+
+// ErrRateLimited is returned by a provider when the upstream quota is used up.
+var ErrRateLimited = errors.New("upstream rate limit exceeded")
+
+// Options control how a provider formats its response.
+type Options struct {
+	// Units is one of "standard", "metric" or "imperial". Empty means the provider default.
+	Units string
+	// Lang is the language code for condition descriptions. Empty means the provider default.
+	Lang string
+}
+
+// WeatherProvider fetches weather data from an upstream source.
+type WeatherProvider interface {
+	// Name returns the identifier used to select the provider from configuration.
+	Name() string
+	// CurrentByCity returns the current conditions for a city name.
+	CurrentByCity(city string, opts Options) (*models.WeatherResponse, error)
+	// CurrentByCoordinates returns the current conditions for a latitude/longitude pair.
+	CurrentByCoordinates(lat, lon float64, opts Options) (*models.WeatherResponse, error)
+	// CurrentByCityID returns the current conditions for a provider specific city id.
+	CurrentByCityID(id int, opts Options) (*models.WeatherResponse, error)
+	// CurrentByZip returns the current conditions for a zip code in the given ISO 3166 country.
+	CurrentByZip(zip, country string, opts Options) (*models.WeatherResponse, error)
+	// ForecastByCity returns the 5-day/3-hour forecast for a city name.
+	ForecastByCity(city string, opts Options) (*models.ForecastResponse, error)
+	// ForecastByCoordinates returns the 5-day/3-hour forecast for a latitude/longitude pair.
+	ForecastByCoordinates(lat, lon float64, opts Options) (*models.ForecastResponse, error)
+	// ForecastByCityID returns the 5-day/3-hour forecast for a provider specific city id.
+	ForecastByCityID(id int, opts Options) (*models.ForecastResponse, error)
+	// ForecastByZip returns the 5-day/3-hour forecast for a zip code in the given ISO 3166 country.
+	ForecastByZip(zip, country string, opts Options) (*models.ForecastResponse, error)
+}
+
+// Config holds the settings needed to construct a provider.
+type Config struct {
+	Name    string
+	APIKey  string
+	BaseURL string
+}
+
+// New returns the provider selected by cfg.Name. An empty name selects OpenWeatherMap.
+func New(cfg Config) (WeatherProvider, error) {
+	switch strings.ToLower(cfg.Name) {
+	case "", "openweathermap":
+		p := NewOpenWeatherMap(cfg.APIKey)
+		if cfg.BaseURL != "" {
+			p.BaseURL = cfg.BaseURL
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown weather provider %q", cfg.Name)
+	}
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/KunalDuran/weather-api/data"
+)
+
+// maxIdle is how long an unused bucket is kept in the database.
+const maxIdle = 24 * time.Hour
+
+// Database is a Store shared between API instances through the rate_limits
+// table, so a client is limited across all of them.
+type Database struct {
+	db *data.DB
+
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+// NewDatabase returns a Store kept in the rate_limits table of db.
+func NewDatabase(db *data.DB) *Database {
+	return &Database{db: db}
+}
+
+// Take creates or updates the bucket under key only if no other request
+// created or changed it in the meantime, retrying a few times. A bucket that
+// keeps changing is being hammered by its client, so the request is then
+// refused as if the bucket were empty rather than let through.
+func (d *Database) Take(key string, limit Limit, now time.Time) (Result, error) {
+	d.purge(now)
+
+	for attempt := 0; attempt < 3; attempt++ {
+		tokens, updatedMS, version, err := data.GetRateLimitBucket(d.db, key)
+		if errors.Is(err, sql.ErrNoRows) {
+			tokens, result := take(float64(limit.Burst), limit)
+			inserted, err := data.InsertRateLimitBucket(d.db, key, tokens, now.UnixMilli())
+			if err != nil {
+				return Result{}, err
+			}
+			if inserted {
+				return result, nil
+			}
+			continue
+		}
+		if err != nil {
+			return Result{}, err
+		}
+
+		tokens, result := take(refill(tokens, now.Sub(time.UnixMilli(updatedMS)), limit), limit)
+		saved, err := data.UpdateRateLimitBucket(d.db, key, tokens, now.UnixMilli(), version)
+		if err != nil {
+			return Result{}, err
+		}
+		if saved {
+			return result, nil
+		}
+	}
+
+	_, result := take(0, limit)
+	return result, nil
+}
+
+// purge deletes, at most every ten minutes, the buckets unused for maxIdle.
+func (d *Database) purge(now time.Time) {
+	d.mu.Lock()
+	if now.Sub(d.lastPurge) < 10*time.Minute {
+		d.mu.Unlock()
+		return
+	}
+	d.lastPurge = now
+	d.mu.Unlock()
+
+	if _, err := data.PurgeRateLimitBuckets(d.db, now.Add(-maxIdle).UnixMilli()); err != nil {
+		// Stale buckets only take space, try again next time
+		return
+	}
+}
@@ -0,0 +1,114 @@
+// Package ratelimit limits how often clients call the API with token buckets.
+//
+// Every client has a bucket per route holding up to Burst tokens, refilled at
+// Rate tokens per second. A request takes a token and is refused when the
+// bucket is empty.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is the size and refill rate of a token bucket.
+type Limit struct {
+	// Rate is how many tokens are added per second.
+	Rate float64
+	// Burst is how many tokens the bucket holds.
+	Burst int
+}
+
+// Per returns a Limit allowing n requests per period, all of which can be
+// made at once.
+func Per(n int, period time.Duration) Limit {
+	return Limit{Rate: float64(n) / period.Seconds(), Burst: n}
+}
+
+// ParseLimit parses a limit written as requests per period, such as "60/1m".
+func ParseLimit(s string) (Limit, error) {
+	count, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("ratelimit: invalid limit %q, want requests/period such as 60/1m", s)
+	}
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("ratelimit: invalid request count in %q", s)
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("ratelimit: invalid period in %q", s)
+	}
+
+	return Per(n, d), nil
+}
+
+// ParseLimits parses comma separated name=limit pairs, such as
+// "/api/login=10/1m,/api/weather=60/1m".
+func ParseLimits(s string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("ratelimit: invalid limit %q, want name=requests/period", pair)
+		}
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.TrimSpace(name)] = limit
+	}
+	return limits, nil
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket and Remaining the whole tokens left.
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until a token is available, when the request
+	// was refused. Reset is how long until the bucket is full again.
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// Store keeps the state of the token buckets.
+type Store interface {
+	// Take takes a token at now from the bucket under key, which is created
+	// full when it does not exist yet.
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// refill returns the tokens of a bucket that had tokens elapsed ago.
+func refill(tokens float64, elapsed time.Duration, limit Limit) float64 {
+	if elapsed > 0 {
+		tokens += elapsed.Seconds() * limit.Rate
+	}
+	return math.Min(tokens, float64(limit.Burst))
+}
+
+// take takes a token from a bucket holding tokens, returning the tokens left.
+func take(tokens float64, limit Limit) (float64, Result) {
+	result := Result{Limit: limit.Burst}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = seconds((float64(limit.Burst) - tokens) / limit.Rate)
+	return tokens, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/KunalDuran/weather-api/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("/api/login=10/1m, /api/weather=5/30s")
+	require.NoError(t, err)
+	assert.Equal(t, Per(10, time.Minute), limits["/api/login"])
+	assert.Equal(t, Limit{Rate: 5.0 / 30, Burst: 5}, limits["/api/weather"])
+
+	limits, err = ParseLimits("")
+	assert.NoError(t, err)
+	assert.Empty(t, limits)
+
+	for _, spec := range []string{"/api/login", "/api/login=10", "/api/login=0/1m", "/api/login=10/soon", "/api/login=10/-1m"} {
+		_, err := ParseLimits(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+// testTake runs the same checks against every store.
+func testTake(t *testing.T, store Store) {
+	limit := Per(3, time.Minute)
+	now := time.Now()
+
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take("login 10.0.0.1", limit, now)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, i, result.Remaining)
+	}
+
+	result, err := store.Take("login 10.0.0.1", limit, now)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.InDelta(t, (20 * time.Second).Seconds(), result.RetryAfter.Seconds(), 0.01)
+	assert.InDelta(t, time.Minute.Seconds(), result.Reset.Seconds(), 0.01)
+
+	// Other clients have buckets of their own
+	result, err = store.Take("login 10.0.0.2", limit, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	// A token is added every 20 seconds
+	now = now.Add(20 * time.Second)
+	result, err = store.Take("login 10.0.0.1", limit, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	result, err = store.Take("login 10.0.0.1", limit, now)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	// An idle bucket fills up to the burst and no further
+	now = now.Add(time.Hour)
+	result, err = store.Take("login 10.0.0.1", limit, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+}
+
+func TestMemoryTake(t *testing.T) {
+	testTake(t, NewMemory())
+}
+
+func TestMemorySweep(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+
+	_, err := m.Take("a", Per(1, time.Minute), now)
+	require.NoError(t, err)
+	_, err = m.Take("b", Per(1, time.Hour), now)
+	require.NoError(t, err)
+	assert.Len(t, m.buckets, 2)
+
+	// Only the full buckets are dropped
+	_, err = m.Take("c", Per(1, time.Minute), now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.Len(t, m.buckets, 2)
+	assert.Contains(t, m.buckets, "b")
+	assert.Contains(t, m.buckets, "c")
+}
+
+func TestDatabaseTake(t *testing.T) {
+	db, err := data.InitSQLite(filepath.Join(t.TempDir(), "weather.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	_, err = data.MigrateUp(db)
+	require.NoError(t, err)
+
+	testTake(t, NewDatabase(db))
+
+	// A bucket created by a concurrent request is neither replaced nor
+	// reset, but taken from
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	inserted, err := data.InsertRateLimitBucket(db, "race", 0, now.UnixMilli())
+	require.NoError(t, err)
+	assert.True(t, inserted)
+	inserted, err = data.InsertRateLimitBucket(db, "race", 3, now.UnixMilli())
+	require.NoError(t, err)
+	assert.False(t, inserted)
+
+	tokens, _, version, err := data.GetRateLimitBucket(db, "race")
+	require.NoError(t, err)
+	assert.Equal(t, float64(0), tokens)
+	assert.Equal(t, 1, version)
+
+	result, err := NewDatabase(db).Take("race", Per(3, time.Minute), now)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+
+	// Concurrent requests on one bucket never get more than its tokens, even
+	// when some of them lose every update to the others
+	limit := Per(5, time.Hour)
+	store := NewDatabase(db)
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := store.Take("hammered", limit, now)
+			assert.NoError(t, err)
+			if result.Allowed {
+				atomic.AddInt32(&allowed, 1)
+			} else {
+				assert.Greater(t, result.RetryAfter, time.Duration(0))
+			}
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, int(allowed), 5)
+}
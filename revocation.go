@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/KunalDuran/weather-api/data"
+)
+
+// revocationList remembers the ids of tokens that were logged out before they
+// expired. Revocations are stored in the revoked_tokens table so every instance
+// sees them, and mirrored in memory so AuthMiddleware does not query the
+// database on every request.
+type revocationList struct {
+	store   data.RevocationStore
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+func newRevocationList(store data.RevocationStore) *revocationList {
+	return &revocationList{
+		store:   store,
+		revoked: make(map[string]time.Time),
+	}
+}
+
+// Revoke records jti as revoked until expiresAt, after which the token would be
+// rejected anyway.
+func (l *revocationList) Revoke(jti string, userID int, expiresAt time.Time) error {
+	if err := l.store.RevokeToken(jti, userID, expiresAt); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.revoked[jti] = expiresAt
+	l.mu.Unlock()
+
+	return nil
+}
+
+// IsRevoked reports whether jti has been revoked.
+func (l *revocationList) IsRevoked(jti string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	_, ok := l.revoked[jti]
+	return ok
+}
+
+// Sync purges expired revocations from the database and reloads the remaining
+// ones, picking up tokens revoked by other instances.
+func (l *revocationList) Sync(now time.Time) error {
+	if _, err := l.store.PurgeExpiredRevokedTokens(now); err != nil {
+		return err
+	}
+
+	revoked, err := l.store.FetchRevokedTokens(now)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.revoked = revoked
+	l.mu.Unlock()
+
+	return nil
+}
+
+// sweep calls Sync every interval until the process exits.
+func (l *revocationList) sweep(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := l.Sync(time.Now()); err != nil {
+			log.Error(err)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/provider"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+// Bounds of the interval between two runs of a schedule. The lower bound
+// keeps schedules from spending the upstream quota on weather that has barely
+// changed.
+const (
+	minPollIntervalMinutes = 10
+	maxPollIntervalMinutes = 24 * 60
+)
+
+// maxSchedules is how many schedules a user can define.
+const maxSchedules = 10
+
+// maxDueSchedules is how many due schedules a run of the scheduler considers.
+const maxDueSchedules = 500
+
+// pollQuotaPause is how long the scheduler and the alert rules stop looking up
+// weather after the provider reported that the upstream quota is used up.
+const pollQuotaPause = 15 * time.Minute
+
+// upstreamPause is when the background lookups of the scheduler and the alert
+// rules, which run in goroutines of their own, may resume after the upstream
+// quota was used up.
+type upstreamPause struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// paused reports whether background lookups are paused at now.
+func (p *upstreamPause) paused(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return now.Before(p.until)
+}
+
+// pause stops background lookups for pollQuotaPause from now.
+func (p *upstreamPause) pause(now time.Time) {
+	p.mu.Lock()
+	p.until = now.Add(pollQuotaPause)
+	p.mu.Unlock()
+	log.Warnf("Upstream rate limit exceeded, pausing background lookups until %s", p.until.Format(time.RFC3339))
+}
+
+// scheduleFromBody reads a schedule from the JSON request body, writing a 400
+// response and returning false when it is invalid. Schedules start enabled.
+func scheduleFromBody(w http.ResponseWriter, r *http.Request) (models.PollSchedule, bool) {
+	var body struct {
+		models.Location
+		IntervalMinutes int   `json:"interval_minutes"`
+		Enabled         *bool `json:"enabled"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid JSON provided.",
+			Data:    nil,
+		})
+		return models.PollSchedule{}, false
+	}
+
+	schedule := models.PollSchedule{
+		Location:        body.Location,
+		IntervalMinutes: body.IntervalMinutes,
+		Enabled:         true,
+	}
+	schedule.City = strings.TrimSpace(schedule.City)
+	schedule.Zip = strings.TrimSpace(schedule.Zip)
+	schedule.Country = strings.TrimSpace(schedule.Country)
+	if body.Enabled != nil {
+		schedule.Enabled = *body.Enabled
+	}
+
+	message := ""
+	if schedule.IntervalMinutes < minPollIntervalMinutes || schedule.IntervalMinutes > maxPollIntervalMinutes {
+		message = "Interval must be between 10 and 1440 minutes."
+	} else if err := provider.ValidateLocation(schedule.Location); err != nil {
+		message = err.Error()
+	}
+	if message != "" {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: message,
+			Data:    nil,
+		})
+		return schedule, false
+	}
+
+	return schedule, true
+}
+
+// scheduleID reads the scheduleID query parameter, writing a 400 response and
+// returning false when it is not a valid id.
+func scheduleID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("scheduleID"))
+	if err != nil || id <= 0 {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid scheduleID.",
+			Data:    nil,
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// localizeSchedules translates the errors of the latest runs of schedules.
+func localizeSchedules(w http.ResponseWriter, schedules []models.PollSchedule) {
+	for i := range schedules {
+		if schedules[i].LastError != "" {
+			schedules[i].LastError = localize(w, schedules[i].LastError)
+		}
+	}
+}
+
+// pollSchedules runs the schedules that are due at now, recording the current
+// weather at each of their locations in the history of their owners.
+//
+// Schedules watching the same location share one lookup, made through the
+// cache, and at most s.pollBudget locations are looked up per run. Schedules
+// left over stay due and are run first the next time. When the provider
+// reports that the upstream quota is used up, polling and alert evaluation
+// stop for pollQuotaPause.
+func (s *server) pollSchedules(now time.Time) error {
+	if s.upstreamPause.paused(now) {
+		return nil
+	}
+
+	due, err := s.schedules.FetchDueSchedules(now, maxDueSchedules)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	groups := make(map[string][]models.PollSchedule)
+	for _, schedule := range due {
+		key := provider.LocationKey(schedule.Location)
+		if _, ok := groups[key]; !ok {
+			if len(keys) == s.pollBudget {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], schedule)
+	}
+
+	for _, key := range keys {
+		var claimed []models.PollSchedule
+		for _, schedule := range groups[key] {
+			next := now.Add(time.Duration(schedule.IntervalMinutes) * time.Minute)
+			ok, err := s.schedules.ClaimSchedule(schedule.ID, schedule.RunCount, now, next)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			if ok {
+				claimed = append(claimed, schedule)
+			}
+		}
+		if len(claimed) == 0 {
+			continue
+		}
+
+		weather, _, err := s.fetchCurrentWeather(claimed[0].Location, provider.Options{})
+		if errors.Is(err, provider.ErrRateLimited) {
+			s.upstreamPause.pause(now)
+		}
+
+		for _, schedule := range claimed {
+			message := ""
+			switch {
+			case errors.Is(err, provider.ErrNotFound):
+				message = notFoundMessage(schedule.Location)
+			case errors.Is(err, provider.ErrRateLimited):
+				message = "Upstream rate limit exceeded."
+			case err != nil:
+				log.Error(err)
+				message = "Failed to fetch weather."
+			default:
+				if _, err := s.history.InsertWeatherHistory(*weather, schedule.UserID); err != nil {
+					log.Error(err)
+					message = "Failed to record weather."
+				}
+			}
+
+			if message != schedule.LastError {
+				if err := s.schedules.SetScheduleError(schedule.ID, message); err != nil {
+					log.Error(err)
+				}
+			}
+		}
+
+		if errors.Is(err, provider.ErrRateLimited) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// watchSchedules calls pollSchedules every interval until the process exits.
+func (s *server) watchSchedules(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := s.pollSchedules(time.Now()); err != nil {
+			log.Error(err)
+		}
+	}
+}
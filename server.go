@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/KunalDuran/weather-api/cache"
+	"github.com/KunalDuran/weather-api/data"
+	"github.com/KunalDuran/weather-api/notify"
+	"github.com/KunalDuran/weather-api/provider"
+	"github.com/KunalDuran/weather-api/ratelimit"
+)
+
+// defaultRateLimit is the key of rateLimits holding the limit of the routes
+// without one of their own.
+const defaultRateLimit = "*"
+
+// defaultRateLimits returns the rate limits of the routes, keyed by path.
+// Routes that look up weather upstream and the unauthenticated ones, which
+// could be used to guess passwords, are limited the most.
+func defaultRateLimits() map[string]ratelimit.Limit {
+	return map[string]ratelimit.Limit{
+		defaultRateLimit:         ratelimit.Per(120, time.Minute),
+		"/api/login":             ratelimit.Per(10, time.Minute),
+		"/api/register":          ratelimit.Per(10, time.Hour),
+		"/api/token/refresh":     ratelimit.Per(30, time.Minute),
+		"/api/weather":           ratelimit.Per(60, time.Minute),
+		"/api/weather/batch":     ratelimit.Per(10, time.Minute),
+		"/api/forecast":          ratelimit.Per(60, time.Minute),
+		"/api/favorites/weather": ratelimit.Per(20, time.Minute),
+		"/api/alerts/confirm":    ratelimit.Per(10, time.Minute),
+	}
+}
+
+// server holds the dependencies shared by the HTTP handlers.
+type server struct {
+	users     data.UserStore
+	history   data.HistoryStore
+	favorites data.FavoriteStore
+	alerts    data.AlertStore
+	webhooks  data.WebhookStore
+	schedules data.ScheduleStore
+	sessions  data.SessionStore
+	logins    data.LoginAttemptStore
+	revoked   *revocationList
+
+	// loginPolicy throttles failed logins. admins holds the ids of the users
+	// granted the admin role.
+	loginPolicy loginPolicy
+	admins      map[int]bool
+
+	// dispatcher sends account events to the webhooks users registered.
+	dispatcher *webhookDispatcher
+
+	// notifiers sends alert notifications, keyed by channel. A channel
+	// without a notifier cannot be used by alert rules. publicURL is where
+	// the API is reached, for the links mailed to users.
+	notifiers map[string]notify.Notifier
+	publicURL string
+
+	provider provider.WeatherProvider
+	cache    cache.Cache
+	cacheTTL time.Duration
+
+	// rateLimiter keeps the token buckets of the clients, limited to
+	// rateLimits. Rate limiting is disabled when it is nil.
+	rateLimiter ratelimit.Store
+	rateLimits  map[string]ratelimit.Limit
+
+	storeForecasts  bool
+	refreshTokenTTL time.Duration
+
+	// lookupConcurrency bounds the provider lookups made at once for a
+	// request covering several locations.
+	lookupConcurrency int
+
+	// pollBudget is how many locations the scheduler, and the evaluation of
+	// the alert rules, look up per run. upstreamPause is set when the upstream
+	// quota is used up. alertOffset is where the next evaluation starts in the
+	// watched locations, and is only touched by evaluateAlerts.
+	pollBudget    int
+	upstreamPause upstreamPause
+	alertOffset   int
+}
+
+// newServer returns a server using store for persistence and p for weather
+// lookups, with caching disabled, default token lifetimes, in-memory rate
+// limiting and webhook alert notifications.
+func newServer(store data.Store, p provider.WeatherProvider) *server {
+	return &server{
+		users:           store,
+		history:         store,
+		favorites:       store,
+		alerts:          store,
+		webhooks:        store,
+		schedules:       store,
+		dispatcher:      newWebhookDispatcher(store),
+		sessions:        store,
+		logins:          store,
+		revoked:         newRevocationList(store),
+		provider:        p,
+		cache:           cache.Nop{},
+		cacheTTL:        10 * time.Minute,
+		refreshTokenTTL: 30 * 24 * time.Hour,
+
+		lookupConcurrency: 5,
+		pollBudget:        30,
+
+		loginPolicy: defaultLoginPolicy(),
+		admins:      map[int]bool{},
+
+		rateLimiter: ratelimit.NewMemory(),
+		rateLimits:  defaultRateLimits(),
+
+		notifiers: map[string]notify.Notifier{
+			notify.ChannelWebhook: notify.NewWebhook(10 * time.Second),
+		},
+		publicURL: "http://localhost:8080",
+	}
+}
+
+// routes returns the API handler with all endpoints and middlewares attached.
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/login", s.RateLimitMiddleware(s.loginHandler))
+	mux.HandleFunc("/api/register", s.RateLimitMiddleware(s.registerHandler))
+	mux.HandleFunc("/api/token/refresh", s.RateLimitMiddleware(s.refreshTokenHandler))
+	mux.HandleFunc("/api/logout", s.RateLimitMiddleware(s.AuthMiddleware(s.logoutHandler)))
+	mux.HandleFunc("/api/sessions", s.RateLimitMiddleware(s.AuthMiddleware(s.sessionsHandler)))
+	mux.HandleFunc("/api/sessions/revoke", s.RateLimitMiddleware(s.AuthMiddleware(s.revokeSessionHandler)))
+	mux.HandleFunc("/api/preferences", s.RateLimitMiddleware(s.AuthMiddleware(s.preferencesHandler)))
+	mux.HandleFunc("/api/weather", s.RateLimitMiddleware(s.AuthMiddleware(s.weatherHandler)))
+	mux.HandleFunc("/api/weather/batch", s.RateLimitMiddleware(s.AuthMiddleware(s.batchWeatherHandler)))
+	mux.HandleFunc("/api/forecast", s.RateLimitMiddleware(s.AuthMiddleware(s.forecastHandler)))
+	mux.HandleFunc("/api/favorites", s.RateLimitMiddleware(s.AuthMiddleware(s.favoritesHandler)))
+	mux.HandleFunc("/api/favorites/update", s.RateLimitMiddleware(s.AuthMiddleware(s.updateFavoriteHandler)))
+	mux.HandleFunc("/api/favorites/delete", s.RateLimitMiddleware(s.AuthMiddleware(s.deleteFavoriteHandler)))
+	mux.HandleFunc("/api/favorites/reorder", s.RateLimitMiddleware(s.AuthMiddleware(s.reorderFavoritesHandler)))
+	mux.HandleFunc("/api/favorites/weather", s.RateLimitMiddleware(s.AuthMiddleware(s.favoritesWeatherHandler)))
+	mux.HandleFunc("/api/alerts", s.RateLimitMiddleware(s.AuthMiddleware(s.alertsHandler)))
+	mux.HandleFunc("/api/alerts/update", s.RateLimitMiddleware(s.AuthMiddleware(s.updateAlertHandler)))
+	mux.HandleFunc("/api/alerts/delete", s.RateLimitMiddleware(s.AuthMiddleware(s.deleteAlertHandler)))
+	mux.HandleFunc("/api/alerts/confirm", s.RateLimitMiddleware(s.confirmAlertEmailHandler))
+	mux.HandleFunc("/api/webhooks", s.RateLimitMiddleware(s.AuthMiddleware(s.webhooksHandler)))
+	mux.HandleFunc("/api/webhooks/delete", s.RateLimitMiddleware(s.AuthMiddleware(s.deleteWebhookHandler)))
+	mux.HandleFunc("/api/webhooks/deliveries", s.RateLimitMiddleware(s.AuthMiddleware(s.webhookDeliveriesHandler)))
+	mux.HandleFunc("/api/webhooks/redeliver", s.RateLimitMiddleware(s.AuthMiddleware(s.redeliverWebhookHandler)))
+	mux.HandleFunc("/api/schedules", s.RateLimitMiddleware(s.AuthMiddleware(s.schedulesHandler)))
+	mux.HandleFunc("/api/schedules/update", s.RateLimitMiddleware(s.AuthMiddleware(s.updateScheduleHandler)))
+	mux.HandleFunc("/api/schedules/delete", s.RateLimitMiddleware(s.AuthMiddleware(s.deleteScheduleHandler)))
+	mux.HandleFunc("/api/history", s.RateLimitMiddleware(s.AuthMiddleware(s.getWeatherHistoryHandler)))
+	mux.HandleFunc("/api/history/delete", s.RateLimitMiddleware(s.AuthMiddleware(s.deleteWeatherHistoryHandler)))
+	mux.HandleFunc("/api/history/bulkdelete", s.RateLimitMiddleware(s.AuthMiddleware(s.bulkDeleteWeatherHistoryHandler)))
+	mux.HandleFunc("/api/admin/unlock", s.RateLimitMiddleware(s.AuthMiddleware(s.unlockAccountHandler)))
+
+	return CorsMiddleware(loggingMiddleware(languageMiddleware(mux)))
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"time"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+// maxDeviceLength matches the size of the sessions.device column.
+const maxDeviceLength = 255
+
+// issueTokens creates an access token for the user and starts a new session
+// holding a refresh token for the given device.
+func (s *server) issueTokens(userID int, username, device, ip string) (map[string]interface{}, error) {
+	token, claims, err := util.IssueToken(userID, username, s.roles(userID)...)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := util.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		UserID:          userID,
+		TokenHash:       util.HashToken(refreshToken),
+		Device:          util.Truncate(device, maxDeviceLength),
+		IP:              ip,
+		LastUsedAt:      now,
+		ExpiresAt:       now.Add(s.refreshTokenTTL),
+		AccessTokenID:   claims.ID,
+		AccessExpiresAt: claims.ExpiresAt.Time,
+	}
+
+	sessionID, err := s.sessions.CreateSession(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"token":              token,
+		"refresh_token":      refreshToken,
+		"refresh_expires_at": session.ExpiresAt.UTC(),
+		"session_id":         sessionID,
+	}, nil
+}
+
+// revokeSessionToken revokes the access token last issued for the revoked
+// session with id, which would otherwise stay valid until it expires. The
+// session is read after it was revoked, as it can no longer be refreshed then.
+func (s *server) revokeSessionToken(id int, userID int) error {
+	session, err := s.sessions.GetSessionByID(id, userID)
+	if err != nil {
+		return err
+	}
+	return s.revokeAccessToken(session)
+}
+
+// revokeAccessToken revokes the access token last issued for session, if it
+// has not expired yet.
+func (s *server) revokeAccessToken(session *models.Session) error {
+	if session.AccessTokenID == "" || !time.Now().Before(session.AccessExpiresAt) {
+		return nil
+	}
+	return s.revoked.Revoke(session.AccessTokenID, session.UserID, session.AccessExpiresAt)
+}
+
+// roles returns the roles granted to the user with userID.
+func (s *server) roles(userID int) []string {
+	if s.admins[userID] {
+		return []string{roleAdmin}
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package util
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// SigningKey is a key used to sign or verify access tokens. Verify-only keys,
+// kept around while tokens signed with them are still valid, have no private half.
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private interface{}
+	public  interface{}
+}
+
+// KeySet holds the active signing key together with any previous keys that
+// tokens may still be signed with, looked up by the token's "kid" header.
+type KeySet struct {
+	Issuer string
+	TTL    time.Duration
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// KeyConfig describes where the signing keys come from.
+type KeyConfig struct {
+	// Algorithm is HS256, RS256 or EdDSA.
+	Algorithm string
+	// KeyID is the "kid" written to new tokens.
+	KeyID string
+	// Secret is the HMAC secret when Algorithm is HS256.
+	Secret string
+	// PrivateKeyFile is a PEM encoded private key when Algorithm is RS256 or EdDSA.
+	PrivateKeyFile string
+	// PreviousSecrets maps key ids to retired HMAC secrets.
+	PreviousSecrets map[string]string
+	// PreviousPublicKeyFiles maps key ids to PEM encoded public keys of retired RSA or Ed25519 keys.
+	PreviousPublicKeyFiles map[string]string
+	Issuer                 string
+	TTL                    time.Duration
+}
+
+var (
+	keysMu    sync.RWMutex
+	tokenKeys = randomKeySet()
+)
+
+// randomKeySet returns an HS256 key set with a random secret, so tokens work
+// out of the box but do not survive a restart until keys are configured.
+func randomKeySet() *KeySet {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic(err)
+	}
+	ks, err := NewKeySet(&SigningKey{ID: "default", Method: jwt.SigningMethodHS256, private: secret, public: secret}, "weather-api", 24*time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	return ks
+}
+
+// SetKeySet replaces the keys used by CreateToken and ParseToken.
+func SetKeySet(ks *KeySet) {
+	keysMu.Lock()
+	tokenKeys = ks
+	keysMu.Unlock()
+}
+
+func currentKeySet() *KeySet {
+	keysMu.RLock()
+	defer keysMu.RUnlock()
+	return tokenKeys
+}
+
+// NewKeySet returns a KeySet signing with active and verifying with active and previous.
+func NewKeySet(active *SigningKey, issuer string, ttl time.Duration, previous ...*SigningKey) (*KeySet, error) {
+	if active == nil || active.private == nil {
+		return nil, errors.New("active signing key must have a private key")
+	}
+
+	ks := &KeySet{
+		Issuer: issuer,
+		TTL:    ttl,
+		active: active,
+		keys:   map[string]*SigningKey{active.ID: active},
+	}
+	for _, key := range previous {
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+
+	return ks, nil
+}
+
+// NewHMACKey returns an HS256 key.
+func NewHMACKey(id string, secret []byte) *SigningKey {
+	return &SigningKey{ID: id, Method: jwt.SigningMethodHS256, private: secret, public: secret}
+}
+
+// NewPrivateKey returns an RS256 or EdDSA signing key for an *rsa.PrivateKey or ed25519.PrivateKey.
+func NewPrivateKey(id string, key crypto.PrivateKey) (*SigningKey, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, private: k, public: &k.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, private: k, public: k.Public()}, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+// NewPublicKey returns a verify-only RS256 or EdDSA key for an *rsa.PublicKey or ed25519.PublicKey.
+func NewPublicKey(id string, key crypto.PublicKey) (*SigningKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodRS256, public: k}, nil
+	case ed25519.PublicKey:
+		return &SigningKey{ID: id, Method: jwt.SigningMethodEdDSA, public: k}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// LoadKeySet builds a KeySet from cfg, reading PEM files from disk.
+func LoadKeySet(cfg KeyConfig) (*KeySet, error) {
+	if cfg.KeyID == "" {
+		return nil, errors.New("signing key id is required")
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 24 * time.Hour
+	}
+
+	var active *SigningKey
+	switch strings.ToUpper(cfg.Algorithm) {
+	case "", "HS256":
+		if len(cfg.Secret) < 32 {
+			return nil, errors.New("HS256 secret must be at least 32 characters")
+		}
+		active = NewHMACKey(cfg.KeyID, []byte(cfg.Secret))
+	case "RS256", "EDDSA":
+		pem, err := os.ReadFile(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePrivateKey(pem)
+		if err != nil {
+			return nil, err
+		}
+		active, err = NewPrivateKey(cfg.KeyID, key)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(active.Method.Alg(), cfg.Algorithm) {
+			return nil, fmt.Errorf("private key is a %s key, not %s", active.Method.Alg(), cfg.Algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", cfg.Algorithm)
+	}
+
+	var previous []*SigningKey
+	for id, secret := range cfg.PreviousSecrets {
+		previous = append(previous, NewHMACKey(id, []byte(secret)))
+	}
+	for id, file := range cfg.PreviousPublicKeyFiles {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parsePublicKey(pem)
+		if err != nil {
+			return nil, err
+		}
+		verifyKey, err := NewPublicKey(id, key)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, verifyKey)
+	}
+
+	return NewKeySet(active, cfg.Issuer, cfg.TTL, previous...)
+}
+
+func parsePrivateKey(pem []byte) (crypto.PrivateKey, error) {
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+		return key, nil
+	}
+	return jwt.ParseEdPrivateKeyFromPEM(pem)
+}
+
+func parsePublicKey(pem []byte) (crypto.PublicKey, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+		return key, nil
+	}
+	return jwt.ParseEdPublicKeyFromPEM(pem)
+}
+
+// ParseKeyList parses "kid=value" pairs separated by commas, as used by the
+// JWT_PREVIOUS_SECRETS and JWT_PREVIOUS_PUBLIC_KEYS settings.
+func ParseKeyList(list string) (map[string]string, error) {
+	keys := make(map[string]string)
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, value, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || value == "" {
+			return nil, fmt.Errorf("invalid key entry %q, expected kid=value", entry)
+		}
+		keys[id] = value
+	}
+	return keys, nil
+}
+
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.active.Method, claims)
+	token.Header["kid"] = ks.active.ID
+	return token.SignedString(ks.active.private)
+}
+
+// keyFunc selects the verification key named by the token's "kid" header and
+// refuses tokens whose algorithm does not match that key.
+func (ks *KeySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.public, nil
+}
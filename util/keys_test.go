@@ -0,0 +1,128 @@
+package util
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// useKeySet installs ks for the duration of the test.
+func useKeySet(t *testing.T, ks *KeySet) {
+	previous := currentKeySet()
+	SetKeySet(ks)
+	t.Cleanup(func() { SetKeySet(previous) })
+}
+
+func TestExpiredTokenIsRejected(t *testing.T) {
+	ks, err := NewKeySet(NewHMACKey("k1", []byte("0123456789abcdef0123456789abcdef")), "weather-api", -time.Minute)
+	assert.NoError(t, err)
+	useKeySet(t, ks)
+
+	token, err := CreateToken(1, "expired@example.com")
+	assert.NoError(t, err)
+
+	_, err = ParseToken(token)
+	assert.Error(t, err)
+}
+
+func TestTokenFromOtherIssuerIsRejected(t *testing.T) {
+	key := NewHMACKey("k1", []byte("0123456789abcdef0123456789abcdef"))
+
+	other, err := NewKeySet(key, "someone-else", time.Hour)
+	assert.NoError(t, err)
+	useKeySet(t, other)
+	token, err := CreateToken(1, "user@example.com")
+	assert.NoError(t, err)
+
+	ours, err := NewKeySet(key, "weather-api", time.Hour)
+	assert.NoError(t, err)
+	SetKeySet(ours)
+
+	_, err = ParseToken(token)
+	assert.Error(t, err)
+}
+
+func TestKeyRotationKeepsOldTokensValid(t *testing.T) {
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	oldKey, err := NewPrivateKey("2023-01", edPrivate)
+	assert.NoError(t, err)
+
+	oldSet, err := NewKeySet(oldKey, "weather-api", time.Hour)
+	assert.NoError(t, err)
+	useKeySet(t, oldSet)
+	oldToken, err := CreateToken(7, "old@example.com")
+	assert.NoError(t, err)
+
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	newKey, err := NewPrivateKey("2023-07", rsaPrivate)
+	assert.NoError(t, err)
+	retiredKey, err := NewPublicKey("2023-01", edPrivate.Public())
+	assert.NoError(t, err)
+
+	newSet, err := NewKeySet(newKey, "weather-api", time.Hour, retiredKey)
+	assert.NoError(t, err)
+	SetKeySet(newSet)
+
+	newToken, err := CreateToken(8, "new@example.com")
+	assert.NoError(t, err)
+
+	claims, err := ParseToken(oldToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "7", claims.Subject)
+
+	claims, err = ParseToken(newToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "8", claims.Subject)
+
+	// Once the retired key is dropped, its tokens stop verifying
+	withoutRetired, err := NewKeySet(newKey, "weather-api", time.Hour)
+	assert.NoError(t, err)
+	SetKeySet(withoutRetired)
+	_, err = ParseToken(oldToken)
+	assert.Error(t, err)
+}
+
+func TestAlgorithmMismatchIsRejected(t *testing.T) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	key, err := NewPrivateKey("rsa", rsaPrivate)
+	assert.NoError(t, err)
+	ks, err := NewKeySet(key, "weather-api", time.Hour)
+	assert.NoError(t, err)
+	useKeySet(t, ks)
+
+	// An HS256 token claiming the RSA key id must not verify
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "weather-api",
+			Subject:   "1",
+			ID:        "forged",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	forged.Header["kid"] = "rsa"
+	signed, err := forged.SignedString([]byte("guess"))
+	assert.NoError(t, err)
+
+	_, err = ParseToken(signed)
+	assert.Error(t, err)
+}
+
+func TestParseKeyList(t *testing.T) {
+	keys, err := ParseKeyList("2023-01=/etc/keys/old.pem, 2023-04=/etc/keys/older.pem")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"2023-01": "/etc/keys/old.pem",
+		"2023-04": "/etc/keys/older.pem",
+	}, keys)
+
+	_, err = ParseKeyList("missing-value=")
+	assert.Error(t, err)
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	for ip, public := range map[string]bool{
+		"8.8.8.8":              true,
+		"2606:4700::1111":      true,
+		"127.0.0.1":            false,
+		"10.1.2.3":             false,
+		"172.16.0.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.64.0.1":           false,
+		"0.0.0.0":              false,
+		"255.255.255.255":      false,
+		"::1":                  false,
+		"fd00::1":              false,
+		"fe80::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"64:ff9b::a9fe:a9fe":   false,
+		"::ffff:93.184.216.34": true,
+	} {
+		assert.Equal(t, public, IsPublicIP(net.ParseIP(ip)), ip)
+	}
+}
+
+func TestPublicHTTPClientRefusesInternalAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback server")
+	}))
+	defer server.Close()
+
+	_, err := NewPublicHTTPClient(time.Second).Post(server.URL, "application/json", nil)
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrNonPublicAddress)
+
+	// Names are checked once resolved
+	_, err = NewPublicHTTPClient(time.Second).Get("http://localhost:" + server.URL[len("http://127.0.0.1:"):])
+	assert.ErrorIs(t, err, ErrNonPublicAddress)
+}
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"math"
+
+	"github.com/KunalDuran/weather-api/models"
+)
+
+// Unit systems a response can be converted to. Weather is always fetched and
+// stored in standard units: Kelvin, meters per second and meters.
+const (
+	UnitsStandard = "standard"
+	UnitsMetric   = "metric"
+	UnitsImperial = "imperial"
+)
+
+const (
+	metersPerMile  = 1609.344
+	mphPerMeterSec = 3600 / metersPerMile
+)
+
+// ValidUnits reports whether units is one of the supported unit systems.
+func ValidUnits(units string) bool {
+	switch units {
+	case UnitsStandard, UnitsMetric, UnitsImperial:
+		return true
+	}
+	return false
+}
+
+// Labels returns the unit of each converted measurement for a unit system.
+func Labels(units string) models.UnitLabels {
+	switch units {
+	case UnitsMetric:
+		return models.UnitLabels{System: units, Temperature: "°C", WindSpeed: "m/s", Visibility: "m"}
+	case UnitsImperial:
+		return models.UnitLabels{System: units, Temperature: "°F", WindSpeed: "mph", Visibility: "mi"}
+	}
+	return models.UnitLabels{System: UnitsStandard, Temperature: "K", WindSpeed: "m/s", Visibility: "m"}
+}
+
+// ConvertTemperature converts a temperature in Kelvin to units.
+func ConvertTemperature(kelvin float64, units string) float64 {
+	switch units {
+	case UnitsMetric:
+		return round2(kelvin - 273.15)
+	case UnitsImperial:
+		return round2((kelvin-273.15)*9/5 + 32)
+	}
+	return kelvin
+}
+
+// TemperatureToKelvin converts a temperature given in units back to Kelvin,
+// for comparing it with stored values.
+func TemperatureToKelvin(temp float64, units string) float64 {
+	switch units {
+	case UnitsMetric:
+		return round2(temp + 273.15)
+	case UnitsImperial:
+		return round2((temp-32)*5/9 + 273.15)
+	}
+	return temp
+}
+
+// ConvertSpeed converts a speed in meters per second to units.
+func ConvertSpeed(speed float64, units string) float64 {
+	if units == UnitsImperial {
+		return round2(speed * mphPerMeterSec)
+	}
+	return speed
+}
+
+// ConvertDistance converts a distance in meters to units.
+func ConvertDistance(meters float64, units string) float64 {
+	if units == UnitsImperial {
+		return round2(meters / metersPerMile)
+	}
+	return meters
+}
+
+// ConvertWeather converts weather from standard units to units in place and
+// labels it.
+func ConvertWeather(weather *models.WeatherResponse, units string) {
+	weather.Main.Temp = ConvertTemperature(weather.Main.Temp, units)
+	weather.Main.FeelsLike = ConvertTemperature(weather.Main.FeelsLike, units)
+	weather.Main.TempMin = ConvertTemperature(weather.Main.TempMin, units)
+	weather.Main.TempMax = ConvertTemperature(weather.Main.TempMax, units)
+	weather.Wind.Speed = ConvertSpeed(weather.Wind.Speed, units)
+	weather.Visibility = ConvertDistance(weather.Visibility, units)
+
+	labels := Labels(units)
+	weather.Units = &labels
+}
+
+// ConvertForecast converts forecast, including its daily aggregation, from
+// standard units to units in place and labels it.
+func ConvertForecast(forecast *models.ForecastResponse, units string) {
+	for i := range forecast.List {
+		item := &forecast.List[i]
+		item.Main.Temp = ConvertTemperature(item.Main.Temp, units)
+		item.Main.FeelsLike = ConvertTemperature(item.Main.FeelsLike, units)
+		item.Main.TempMin = ConvertTemperature(item.Main.TempMin, units)
+		item.Main.TempMax = ConvertTemperature(item.Main.TempMax, units)
+		item.Wind.Speed = ConvertSpeed(item.Wind.Speed, units)
+		item.Wind.Gust = ConvertSpeed(item.Wind.Gust, units)
+		item.Visibility = ConvertDistance(item.Visibility, units)
+	}
+
+	for i := range forecast.Daily {
+		day := &forecast.Daily[i]
+		day.TempMin = ConvertTemperature(day.TempMin, units)
+		day.TempMax = ConvertTemperature(day.TempMax, units)
+		day.TempAvg = ConvertTemperature(day.TempAvg, units)
+		day.WindSpeed = ConvertSpeed(day.WindSpeed, units)
+	}
+
+	labels := Labels(units)
+	forecast.Units = &labels
+}
+
+// round2 rounds x to two decimals, hiding floating point noise such as
+// 300 - 273.15 = 26.850000000000023.
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertWeather(t *testing.T) {
+	weather := models.WeatherResponse{Visibility: 10000}
+	weather.Main.Temp = 300
+	weather.Wind.Speed = 10
+
+	standard := weather
+	ConvertWeather(&standard, UnitsStandard)
+	assert.Equal(t, 300.0, standard.Main.Temp)
+	assert.Equal(t, "K", standard.Units.Temperature)
+
+	metric := weather
+	ConvertWeather(&metric, UnitsMetric)
+	assert.Equal(t, 26.85, metric.Main.Temp)
+	assert.Equal(t, 10.0, metric.Wind.Speed)
+	assert.Equal(t, 10000.0, metric.Visibility)
+
+	imperial := weather
+	ConvertWeather(&imperial, UnitsImperial)
+	assert.Equal(t, 80.33, imperial.Main.Temp)
+	assert.Equal(t, 22.37, imperial.Wind.Speed)
+	assert.Equal(t, 6.21, imperial.Visibility)
+	assert.Equal(t, models.UnitLabels{System: "imperial", Temperature: "°F", WindSpeed: "mph", Visibility: "mi"}, *imperial.Units)
+}
+
+func TestTemperatureToKelvin(t *testing.T) {
+	for _, units := range []string{UnitsStandard, UnitsMetric, UnitsImperial} {
+		assert.Equal(t, 300.0, TemperatureToKelvin(ConvertTemperature(300, units), units), units)
+	}
+	assert.False(t, ValidUnits(""))
+	assert.False(t, ValidUnits("kelvin"))
+}
@@ -1,84 +1,269 @@
 package util
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
-	"io"
+	"errors"
+	"net"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 	"unicode"
+	"unicode/utf8"
 
+	"github.com/KunalDuran/weather-api/i18n"
 	"github.com/KunalDuran/weather-api/models"
 	"github.com/golang-jwt/jwt/v4"
 )
 
-func WebRequest(method string, url string, body io.Reader) (*http.Response, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest(method, url, body)
+// ClientIP returns the address of the client that sent r.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		return nil, err
+		return r.RemoteAddr
 	}
-	return client.Do(req)
+	return host
+}
+
+// Localized is implemented by response writers that carry the language
+// negotiated for the request. JSONResponse translates the message of responses
+// written to them.
+type Localized interface {
+	Language() string
 }
 
 func JSONResponse(w http.ResponseWriter, statusCode int, resp *models.Response) {
 	w.Header().Set("Content-Type", "application/json")
 
+	if l, ok := w.(Localized); ok {
+		localized := *resp
+		localized.Message = i18n.Translate(l.Language(), resp.Message)
+		resp = &localized
+	}
+
 	w.WriteHeader(statusCode)
 
 	json.NewEncoder(w).Encode(resp)
 
 }
 
-func CreateToken(id int, username string) (string, error) {
-	claims := jwt.MapClaims{
-		"Issuer":    "my-app",
-		"Subject":   strconv.Itoa(id),
-		"Username":  username,
-		"ExpiresAt": time.Now().Add(time.Hour * 24),
+// Claims are the claims carried by access tokens.
+type Claims struct {
+	Username string   `json:"username"`
+	Roles    []string `json:"roles,omitempty"`
+	jwt.RegisteredClaims
+}
+
+func CreateToken(id int, username string, roles ...string) (string, error) {
+	token, _, err := IssueToken(id, username, roles...)
+	return token, err
+}
+
+// IssueToken is CreateToken, also returning the claims of the token so that
+// its id and expiry can be recorded.
+func IssueToken(id int, username string, roles ...string) (string, *Claims, error) {
+	jti, err := GenerateTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+
+	ks := currentKeySet()
+	now := time.Now()
+	claims := Claims{
+		Username: username,
+		Roles:    roles,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    ks.Issuer,
+			Subject:   strconv.Itoa(id),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ks.TTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ID:        jti,
+		},
+	}
+
+	token, err := ks.sign(claims)
+	if err != nil {
+		return "", nil, err
 	}
+	return token, &claims, nil
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+// ParseToken verifies the signature, expiry and issuer of token, which may be
+// prefixed with "Bearer ", and returns its claims.
+func ParseToken(token string) (*Claims, error) {
+	token = strings.TrimPrefix(token, "Bearer ")
 
-	secret := []byte("my-secret-key")
-	signedToken, err := token.SignedString(secret)
+	ks := currentKeySet()
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, ks.keyFunc)
 	if err != nil {
+		return nil, err
+	}
+
+	if !claims.VerifyIssuer(ks.Issuer, true) {
+		return nil, errors.New("token has an invalid issuer")
+	}
+	if claims.ExpiresAt == nil || claims.Subject == "" || claims.ID == "" {
+		return nil, errors.New("token is missing required claims")
+	}
+
+	return claims, nil
+}
+
+// GenerateTokenID returns a random identifier for a JWT.
+func GenerateTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
+	return hex.EncodeToString(b), nil
+}
+
+// AggregateDailyForecast groups 3-hour forecast steps by local calendar day,
+// using the location's UTC offset in seconds, and summarizes each day.
+func AggregateDailyForecast(items []models.ForecastItem, timezone int) []models.DailyForecast {
+	type totals struct {
+		count    int
+		temp     float64
+		wind     float64
+		humidity int
+	}
 
-	return signedToken, nil
+	var daily []models.DailyForecast
+	var sums []totals
+	conditions := map[string]map[string]int{}
+	representative := map[string]models.Weather{}
+
+	zone := time.FixedZone("", timezone)
+	for _, item := range items {
+		date := time.Unix(int64(item.Dt), 0).In(zone).Format("2006-01-02")
+
+		if len(daily) == 0 || daily[len(daily)-1].Date != date {
+			daily = append(daily, models.DailyForecast{
+				Date:    date,
+				TempMin: item.Main.TempMin,
+				TempMax: item.Main.TempMax,
+			})
+			sums = append(sums, totals{})
+			conditions[date] = map[string]int{}
+		}
+
+		day := &daily[len(daily)-1]
+		sum := &sums[len(sums)-1]
+
+		if item.Main.TempMin < day.TempMin {
+			day.TempMin = item.Main.TempMin
+		}
+		if item.Main.TempMax > day.TempMax {
+			day.TempMax = item.Main.TempMax
+		}
+		if item.Pop > day.Pop {
+			day.Pop = item.Pop
+		}
+
+		// Averages are taken over the steps the day actually has, so the partial
+		// first and last days of the forecast window are not skewed.
+		sum.count++
+		sum.temp += item.Main.Temp
+		sum.wind += item.Wind.Speed
+		sum.humidity += item.Main.Humidity
+		day.TempAvg = sum.temp / float64(sum.count)
+		day.WindSpeed = sum.wind / float64(sum.count)
+		day.Humidity = sum.humidity / sum.count
+
+		if len(item.Weathers) > 0 {
+			condition := item.Weathers[0]
+			conditions[date][condition.Main]++
+			if conditions[date][condition.Main] > conditions[date][day.Main] || day.Main == "" {
+				day.Main = condition.Main
+				representative[date] = condition
+			}
+		}
+	}
+
+	for i := range daily {
+		if condition, ok := representative[daily[i].Date]; ok {
+			daily[i].Description = condition.Description
+			daily[i].Icon = condition.Icon
+		}
+	}
+
+	return daily
 }
 
-func GetUserIDFromToken(token string) (string, error) {
-	claims, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		return []byte("my-secret-key"), nil
-	})
-	if err != nil {
+// GenerateRefreshToken returns a random, URL safe opaque token.
+func GenerateRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	id := claims.Claims.(jwt.MapClaims)["Subject"].(string)
+// GenerateSecret returns a random, hex encoded secret for signing payloads.
+func GenerateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
 
-	return id, nil
+// HashToken returns the hex encoded SHA-256 of token, so opaque tokens can be
+// stored and looked up without keeping the token itself.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
+// ParseDOB parses a date of birth as stored by the database. Drivers that
+// return dates as times, such as Postgres, yield RFC 3339 strings instead.
 func ParseDOB(dob string) (time.Time, error) {
 	birthDate, err := time.Parse("2006-01-02", dob)
 	if err != nil {
-		return time.Time{}, err
+		t, rfcErr := time.Parse(time.RFC3339Nano, dob)
+		if rfcErr != nil {
+			return time.Time{}, err
+		}
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
 	}
 	return birthDate, nil
 }
 
+// ParseTimestamp parses a timestamp as stored by the database, in UTC. Like
+// ParseDOB it also accepts the RFC 3339 strings of drivers that return times.
 func ParseTimestamp(timestamp string) (time.Time, error) {
 	t, err := time.Parse("2006-01-02 15:04:05", timestamp)
 	if err != nil {
-		return time.Time{}, err
+		t, rfcErr := time.Parse(time.RFC3339Nano, timestamp)
+		if rfcErr != nil {
+			return time.Time{}, err
+		}
+		return t.UTC(), nil
 	}
 	return t, nil
 }
 
+// Truncate shortens s to at most max bytes, cutting before a whole character
+// rather than in the middle of one, so that the result stays valid UTF-8.
+func Truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+
+	cut := max
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
+
 func ValidateEmail(email string) bool {
 	// Regular expression to match a valid email address.
 	re := regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
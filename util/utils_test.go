@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/KunalDuran/weather-api/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -18,11 +19,29 @@ func TestCreateTokenAndParseUserID(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Parse the user ID from the token
-	parsedID, err := GetUserIDFromToken(token)
+	claims, err := ParseToken(token)
 	assert.NoError(t, err)
 
 	// Ensure the parsed user ID matches the original ID
-	assert.Equal(t, strconv.Itoa(id), parsedID)
+	assert.Equal(t, strconv.Itoa(id), claims.Subject)
+
+	// Every token carries its own id and an expiry
+	assert.Len(t, claims.ID, 32)
+	assert.Equal(t, username, claims.Username)
+	assert.WithinDuration(t, time.Now().Add(24*time.Hour), claims.ExpiresAt.Time, time.Minute)
+
+	otherToken, err := CreateToken(id, username)
+	assert.NoError(t, err)
+	otherClaims, err := ParseToken("Bearer " + otherToken)
+	assert.NoError(t, err)
+	assert.NotEqual(t, claims.ID, otherClaims.ID)
+
+	adminToken, err := CreateToken(id, username, "admin")
+	assert.NoError(t, err)
+	adminClaims, err := ParseToken(adminToken)
+	assert.NoError(t, err)
+	assert.Empty(t, claims.Roles)
+	assert.Equal(t, []string{"admin"}, adminClaims.Roles)
 }
 
 func TestParseDOB(t *testing.T) {
@@ -51,6 +70,30 @@ func TestParseTimestamp(t *testing.T) {
 	assert.Equal(t, expectedTimestamp, parsedTimestamp)
 }
 
+func TestParseTimestampFromTimeDrivers(t *testing.T) {
+	expectedTimestamp := time.Date(2023, 7, 30, 12, 34, 56, 0, time.UTC)
+
+	parsedTimestamp, err := ParseTimestamp("2023-07-30T18:04:56+05:30")
+	assert.NoError(t, err)
+	assert.Equal(t, expectedTimestamp, parsedTimestamp)
+
+	parsedDOB, err := ParseDOB("1990-05-15T00:00:00Z")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(1990, 5, 15, 0, 0, 0, 0, time.UTC), parsedDOB)
+
+	_, err = ParseTimestamp("yesterday")
+	assert.Error(t, err)
+}
+
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "Pune", Truncate("Pune", 4))
+	assert.Equal(t, "Pu", Truncate("Pune", 2))
+	// "ü" takes two bytes, which are kept or dropped together
+	assert.Equal(t, "Zür", Truncate("Zürich", 4))
+	assert.Equal(t, "Z", Truncate("Zürich", 2))
+	assert.Equal(t, "", Truncate("日本", 2))
+}
+
 func TestValidateEmail(t *testing.T) {
 	// Test valid email addresses
 	validEmails := []string{
@@ -146,3 +189,52 @@ func TestValidateDateOfBirthPastDate(t *testing.T) {
 	pastDate := time.Now().AddDate(-20, 0, 0).Format("2006-01-02")
 	assert.True(t, ValidateDateOfBirth(pastDate))
 }
+
+func TestAggregateDailyForecast(t *testing.T) {
+	// Three steps on 2023-07-30 and one on 2023-07-31 in UTC+5:30
+	start := time.Date(2023, 7, 30, 0, 0, 0, 0, time.FixedZone("IST", 19800)).Unix()
+	var items []models.ForecastItem
+	for i, temp := range []float64{290, 300, 295, 280} {
+		item := models.ForecastItem{Dt: int(start) + i*3*3600}
+		if i == 3 {
+			item.Dt = int(start) + 24*3600
+		}
+		item.Main.Temp = temp
+		item.Main.TempMin = temp - 1
+		item.Main.TempMax = temp + 1
+		item.Main.Humidity = 60
+		item.Weathers = []models.Weather{{Main: "Clear", Description: "clear sky", Icon: "01d"}}
+		if i == 1 || i == 2 {
+			item.Weathers = []models.Weather{{Main: "Rain", Description: "light rain", Icon: "10d"}}
+			item.Pop = 0.4
+		}
+		items = append(items, item)
+	}
+
+	daily := AggregateDailyForecast(items, 19800)
+	assert.Len(t, daily, 2)
+
+	assert.Equal(t, "2023-07-30", daily[0].Date)
+	assert.Equal(t, 289.0, daily[0].TempMin)
+	assert.Equal(t, 301.0, daily[0].TempMax)
+	assert.InDelta(t, 295.0, daily[0].TempAvg, 0.001)
+	assert.Equal(t, 60, daily[0].Humidity)
+	assert.Equal(t, 0.4, daily[0].Pop)
+	assert.Equal(t, "Rain", daily[0].Main)
+	assert.Equal(t, "light rain", daily[0].Description)
+
+	assert.Equal(t, "2023-07-31", daily[1].Date)
+	assert.Equal(t, "Clear", daily[1].Main)
+}
+
+func TestGenerateRefreshToken(t *testing.T) {
+	first, err := GenerateRefreshToken()
+	assert.NoError(t, err)
+	second, err := GenerateRefreshToken()
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, first, second)
+	assert.Len(t, HashToken(first), 64)
+	assert.Equal(t, HashToken(first), HashToken(first))
+	assert.NotEqual(t, HashToken(first), HashToken(second))
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sync"
+
+	"github.com/KunalDuran/weather-api/cache"
+	"github.com/KunalDuran/weather-api/i18n"
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/provider"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+var langPattern = regexp.MustCompile(`^[a-zA-Z]{2}(_[a-zA-Z]{2})?$`)
+
+// validLang reports whether lang looks like a language code such as "en" or "zh_cn".
+func validLang(lang string) bool {
+	return lang == "" || langPattern.MatchString(lang)
+}
+
+// preferences returns the preferences of the user behind principal, falling
+// back to the defaults when they cannot be loaded.
+func (s *server) preferences(principal *Principal) models.Preferences {
+	user, err := s.users.GetUserByID(principal.UserID)
+	if err != nil {
+		log.Error(err)
+		return models.Preferences{Units: util.UnitsStandard}
+	}
+	return models.Preferences{Units: user.Units, Lang: user.Lang}
+}
+
+// weatherOptions reads the lang query parameter, writing a 400 response and
+// returning false when it is invalid. Without it lookups are fetched in the
+// user's preferred language, or else the best Accept-Language match. Units
+// are not passed upstream: lookups are fetched in standard units and
+// converted for each response, see requestUnits.
+func weatherOptions(w http.ResponseWriter, r *http.Request, prefs models.Preferences) (provider.Options, bool) {
+	opts := provider.Options{
+		Lang: r.URL.Query().Get("lang"),
+	}
+	if !validLang(opts.Lang) {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid language code.",
+			Data:    nil,
+		})
+		return opts, false
+	}
+
+	if opts.Lang == "" {
+		opts.Lang = prefs.Lang
+	}
+	if opts.Lang == "" {
+		// English is what providers answer in anyway, leaving the cache key as it is
+		if lang := i18n.Negotiate(r.Header.Get("Accept-Language")); lang != i18n.Default {
+			opts.Lang = lang
+		}
+	}
+	return opts, true
+}
+
+// requestUnits returns the unit system a response should be converted to:
+// the units query parameter when given, otherwise the user's preference. It
+// writes a 400 response and returns false when the parameter is invalid.
+func requestUnits(w http.ResponseWriter, r *http.Request, prefs models.Preferences) (string, bool) {
+	units := r.URL.Query().Get("units")
+	if units == "" {
+		if !util.ValidUnits(prefs.Units) {
+			return util.UnitsStandard, true
+		}
+		return prefs.Units, true
+	}
+
+	if !util.ValidUnits(units) {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid units, use standard, metric or imperial.",
+			Data:    nil,
+		})
+		return units, false
+	}
+	return units, true
+}
+
+// weatherLocation reads the location to look up from the query string, writing
+// a 400 response and returning false when it is missing or invalid.
+func weatherLocation(w http.ResponseWriter, r *http.Request) (models.Location, bool) {
+	loc, err := provider.LocationFromQuery(r.URL.Query())
+	if err != nil {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: err.Error(),
+			Data:    nil,
+		})
+		return loc, false
+	}
+	return loc, true
+}
+
+// setCacheHeader tells the client whether the response was served from the cache.
+func setCacheHeader(w http.ResponseWriter, hit bool) {
+	if hit {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+}
+
+// cachedFetch returns the value stored under key, calling fetch and caching
+// its result on a miss. The boolean result reports whether the cache was hit.
+func cachedFetch[T any](s *server, key string, fetch func() (*T, error)) (*T, bool, error) {
+	payload, found, err := s.cache.Get(key)
+	if err != nil {
+		log.Error(err)
+	}
+
+	if found {
+		var value T
+		err := json.Unmarshal(payload, &value)
+		if err == nil {
+			return &value, true, nil
+		}
+		log.Error(err)
+	}
+
+	value, err := fetch()
+	if err != nil {
+		return nil, false, err
+	}
+
+	payload, err = json.Marshal(value)
+	if err != nil {
+		log.Error(err)
+	} else if err := s.cache.Set(key, payload, s.cacheTTL); err != nil {
+		log.Error(err)
+	}
+
+	return value, false, nil
+}
+
+// fetchCurrentWeather returns the current conditions for loc through the cache.
+func (s *server) fetchCurrentWeather(loc models.Location, opts provider.Options) (*models.WeatherResponse, bool, error) {
+	key := s.cacheKey("current", loc, opts)
+	return cachedFetch(s, key, func() (*models.WeatherResponse, error) {
+		return provider.Current(s.provider, loc, opts)
+	})
+}
+
+// fetchForecast returns the forecast for loc through the cache, with the
+// daily aggregation filled in.
+func (s *server) fetchForecast(loc models.Location, opts provider.Options) (*models.ForecastResponse, bool, error) {
+	key := s.cacheKey("forecast", loc, opts)
+	return cachedFetch(s, key, func() (*models.ForecastResponse, error) {
+		forecast, err := provider.Forecast(s.provider, loc, opts)
+		if err != nil {
+			return nil, err
+		}
+		forecast.Daily = util.AggregateDailyForecast(forecast.List, forecast.City.Timezone)
+		return forecast, nil
+	})
+}
+
+// maxBatchLocations is how many locations a batch lookup can cover.
+const maxBatchLocations = 50
+
+// lookup is the outcome of fetching the current weather at one location.
+type lookup struct {
+	weather *models.WeatherResponse
+	err     error
+}
+
+// fetchCurrentWeatherAll fetches the current weather at every location in
+// locs through the cache, with at most s.lookupConcurrency lookups running at
+// once. The results are in the order of locs.
+func (s *server) fetchCurrentWeatherAll(locs []models.Location, opts provider.Options) []lookup {
+	concurrency := s.lookupConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]lookup, len(locs))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, loc := range locs {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int, loc models.Location) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			weather, _, err := s.fetchCurrentWeather(loc, opts)
+			results[i] = lookup{weather: weather, err: err}
+		}(i, loc)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func (s *server) cacheKey(kind string, loc models.Location, opts provider.Options) string {
+	return cache.Key(s.provider.Name(), kind, provider.LocationKey(loc), opts.Units, opts.Lang)
+}
+
+// notFoundMessage returns the message reported when the provider does not know loc.
+func notFoundMessage(loc models.Location) string {
+	if loc.City != "" {
+		return "City not found."
+	}
+	return "Location not found."
+}
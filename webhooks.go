@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KunalDuran/weather-api/data"
+	"github.com/KunalDuran/weather-api/models"
+	"github.com/KunalDuran/weather-api/util"
+)
+
+// Events sent to webhooks.
+const (
+	eventWeatherFetched = "weather.fetched"
+	eventHistoryDeleted = "history.deleted"
+	eventHistoryCleared = "history.cleared"
+)
+
+// webhookEvents lists every event a webhook can subscribe to.
+var webhookEvents = []string{eventWeatherFetched, eventHistoryDeleted, eventHistoryCleared}
+
+// Statuses of a webhook delivery.
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+)
+
+// maxWebhooks is how many webhooks a user can register.
+const maxWebhooks = 10
+
+// webhookEvent is the JSON body posted to webhooks.
+type webhookEvent struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// maxDueDeliveries is how many deliveries one pass of the dispatcher fetches
+// at a time.
+const maxDueDeliveries = 50
+
+// webhookDispatcher sends events to the webhooks users registered. Every
+// delivery is logged in the store together with when it is next attempted,
+// and attempted in the background, so emitting an event does not hold up the
+// response and pending deliveries survive restarts.
+//
+// A background worker hands the due deliveries to at most workers concurrent
+// senders, with one delivery in flight per webhook, so that a slow receiver
+// holds up its own deliveries only.
+type webhookDispatcher struct {
+	store data.WebhookStore
+	// client only connects to public addresses, so webhooks cannot be used
+	// to reach the internal network of the server.
+	client *http.Client
+
+	// maxAttempts is how many times a delivery is tried before it fails,
+	// waiting backoff before the first retry and twice as long before each
+	// later one.
+	maxAttempts int
+	backoff     time.Duration
+
+	// workers bounds the deliveries sent at once. sending holds the webhooks
+	// with a delivery in flight, and inFlight waits for those deliveries.
+	workers  int
+	mu       sync.Mutex
+	sending  map[int]bool
+	inFlight sync.WaitGroup
+
+	// wake tells the worker that a new delivery is due or a sender is free,
+	// done asks it to stop and stopped is closed once it has.
+	wake    chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newWebhookDispatcher(store data.WebhookStore) *webhookDispatcher {
+	return &webhookDispatcher{
+		store:       store,
+		client:      util.NewPublicHTTPClient(10 * time.Second),
+		maxAttempts: 5,
+		backoff:     10 * time.Second,
+		workers:     8,
+		sending:     make(map[int]bool),
+		wake:        make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+}
+
+// emit sends event with data to every webhook of userID subscribed to it.
+func (d *webhookDispatcher) emit(userID int, event string, data interface{}) {
+	hooks, err := d.store.FetchWebhooks(userID)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	var payload []byte
+	for _, hook := range hooks {
+		if !subscribed(hook, event) {
+			continue
+		}
+
+		if payload == nil {
+			payload, err = json.Marshal(webhookEvent{
+				Event:     event,
+				CreatedAt: time.Now().UTC(),
+				Data:      data,
+			})
+			if err != nil {
+				log.Error(err)
+				return
+			}
+		}
+
+		if _, err := d.enqueue(hook, event, payload); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+// subscribed reports whether hook wants to receive event.
+func subscribed(hook models.Webhook, event string) bool {
+	if len(hook.Events) == 0 {
+		return true
+	}
+	for _, e := range hook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue logs a new delivery of payload to hook, due right away, and wakes
+// the worker to send it.
+func (d *webhookDispatcher) enqueue(hook models.Webhook, event string, payload []byte) (*models.WebhookDelivery, error) {
+	now := time.Now().UTC()
+	delivery := models.WebhookDelivery{
+		WebhookID:     hook.ID,
+		UserID:        hook.UserID,
+		Event:         event,
+		Payload:       payload,
+		Status:        deliveryPending,
+		NextAttemptAt: &now,
+	}
+
+	id, err := d.store.CreateWebhookDelivery(delivery)
+	if err != nil {
+		return nil, err
+	}
+	delivery.ID = id
+	delivery.CreatedAt = now
+
+	d.notify()
+
+	return &delivery, nil
+}
+
+// notify wakes the worker, unless it is already due to wake.
+func (d *webhookDispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run attempts the due deliveries when woken by enqueue or a sender finishing,
+// and at least every interval for retries, until stop is called. The first pass resumes the
+// deliveries left pending by an earlier run.
+func (d *webhookDispatcher) run(interval time.Duration) {
+	defer close(d.stopped)
+
+	for {
+		if err := d.deliverDue(time.Now()); err != nil {
+			log.Error(err)
+		}
+
+		select {
+		case <-d.done:
+			return
+		case <-d.wake:
+		case <-time.After(interval):
+		}
+	}
+}
+
+// stop asks the worker started by run to stop and waits until it has. The
+// deliveries in flight are finished first; later ones stay pending in the
+// store for the next run.
+func (d *webhookDispatcher) stop() {
+	close(d.done)
+	<-d.stopped
+	d.wait()
+}
+
+// wait waits for the deliveries in flight.
+func (d *webhookDispatcher) wait() {
+	d.inFlight.Wait()
+}
+
+// deliverDue starts an attempt at every delivery due at now, as long as a
+// sender is free and no other delivery to the same webhook is in flight,
+// until none is left or stop is called. Each attempt is claimed first, which
+// counts it and schedules the retry, so an attempt cut short by a crash is
+// retried as well.
+func (d *webhookDispatcher) deliverDue(now time.Time) error {
+	for {
+		busy := d.busyWebhooks()
+		if len(busy) >= d.workers {
+			return nil
+		}
+
+		due, err := d.store.FetchDueWebhookDeliveries(now, busy, maxDueDeliveries)
+		if err != nil {
+			return err
+		}
+
+		progressed := false
+		for _, delivery := range due {
+			select {
+			case <-d.done:
+				return nil
+			default:
+			}
+
+			if delivery.Attempts >= d.maxAttempts {
+				// The last attempt was cut short before its outcome was recorded
+				delivery.Status = deliveryFailed
+				delivery.NextAttemptAt = nil
+				if err := d.store.UpdateWebhookDelivery(&delivery); err != nil {
+					log.Error(err)
+				}
+				progressed = true
+				continue
+			}
+
+			if !d.reserve(delivery.WebhookID) {
+				// Left out of the next fetch, or a sender has to free up
+				progressed = true
+				continue
+			}
+
+			retryAt := now.Add(d.backoff << delivery.Attempts)
+			ok, err := d.store.ClaimWebhookDelivery(delivery.ID, delivery.Attempts, retryAt)
+			if err != nil || !ok {
+				if err != nil {
+					log.Error(err)
+				}
+				d.release(delivery.WebhookID)
+				continue
+			}
+			delivery.Attempts++
+			delivery.NextAttemptAt = &retryAt
+			progressed = true
+
+			hook, err := d.store.GetWebhookByID(delivery.WebhookID, delivery.UserID)
+			if err != nil {
+				// A deleted webhook takes its deliveries with it
+				if !errors.Is(err, sql.ErrNoRows) {
+					log.Error(err)
+				}
+				d.release(delivery.WebhookID)
+				continue
+			}
+
+			d.inFlight.Add(1)
+			go func(hook models.Webhook, delivery models.WebhookDelivery) {
+				defer d.inFlight.Done()
+				d.deliver(hook, delivery)
+				d.release(hook.ID)
+				d.notify()
+			}(*hook, delivery)
+		}
+
+		if len(due) < maxDueDeliveries || !progressed {
+			return nil
+		}
+	}
+}
+
+// busyWebhooks returns the webhooks with a delivery in flight.
+func (d *webhookDispatcher) busyWebhooks() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	busy := make([]int, 0, len(d.sending))
+	for id := range d.sending {
+		busy = append(busy, id)
+	}
+	return busy
+}
+
+// reserve marks webhook as having a delivery in flight, provided it has none
+// yet and a sender is free.
+func (d *webhookDispatcher) reserve(webhook int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.sending[webhook] || len(d.sending) >= d.workers {
+		return false
+	}
+	d.sending[webhook] = true
+	return true
+}
+
+// release frees the sender reserved for webhook.
+func (d *webhookDispatcher) release(webhook int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.sending, webhook)
+}
+
+// deliver makes the claimed attempt at delivery and records its outcome. A
+// delivery that fails on its last attempt is given up on.
+func (d *webhookDispatcher) deliver(hook models.Webhook, delivery models.WebhookDelivery) {
+	status, sendErr := d.post(hook, delivery)
+	delivery.ResponseStatus = status
+	delivery.Error = ""
+	switch {
+	case sendErr == nil:
+		deliveredAt := time.Now().UTC()
+		delivery.Status = deliverySucceeded
+		delivery.DeliveredAt = &deliveredAt
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = deliveryFailed
+		delivery.NextAttemptAt = nil
+	}
+	if sendErr != nil {
+		delivery.Error = util.Truncate(sendErr.Error(), 255)
+	}
+
+	if err := d.store.UpdateWebhookDelivery(&delivery); err != nil {
+		log.Error(err)
+	}
+	if delivery.Status == deliveryFailed {
+		log.Errorf("Webhook %d: delivery %d failed after %d attempts: %s", hook.ID, delivery.ID, delivery.Attempts, delivery.Error)
+	}
+}
+
+// post makes one attempt at delivery, returning the status the webhook
+// responded with, if any.
+func (d *webhookDispatcher) post(hook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(delivery.ID))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+signPayload(hook.Secret, timestamp, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// signPayload returns the hex encoded HMAC-SHA256, keyed with secret, of the
+// timestamp and payload joined by a dot. Signing the timestamp lets receivers
+// reject replayed deliveries.
+func signPayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookFromBody reads a webhook URL and the events it subscribes to from
+// the JSON request body, writing a 400 response and returning false when
+// either is invalid. The secret is generated.
+func webhookFromBody(w http.ResponseWriter, r *http.Request) (models.Webhook, bool) {
+	var body struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid JSON provided.",
+			Data:    nil,
+		})
+		return models.Webhook{}, false
+	}
+
+	hook := models.Webhook{URL: strings.TrimSpace(body.URL), Events: []string{}}
+	seen := make(map[string]bool)
+	for _, event := range body.Events {
+		if !seen[event] {
+			seen[event] = true
+			hook.Events = append(hook.Events, event)
+		}
+	}
+
+	message := ""
+	switch {
+	case !util.ValidateWebhookURL(hook.URL) || len(hook.URL) > 255:
+		message = "Invalid webhook URL."
+	default:
+		for _, event := range hook.Events {
+			if !validWebhookEvent(event) {
+				message = "Invalid event, use weather.fetched, history.deleted or history.cleared."
+			}
+		}
+	}
+	if message != "" {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: message,
+			Data:    nil,
+		})
+		return hook, false
+	}
+
+	return hook, true
+}
+
+func validWebhookEvent(event string) bool {
+	for _, e := range webhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// webhookID reads the webhookID query parameter, writing a 400 response and
+// returning false when it is not a valid id.
+func webhookID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("webhookID"))
+	if err != nil || id <= 0 {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid webhookID.",
+			Data:    nil,
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// deliveryID reads the deliveryID query parameter, writing a 400 response and
+// returning false when it is not a valid id.
+func deliveryID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("deliveryID"))
+	if err != nil || id <= 0 {
+		util.JSONResponse(w, http.StatusBadRequest, &models.Response{
+			Status:  "error",
+			Message: "Invalid deliveryID.",
+			Data:    nil,
+		})
+		return 0, false
+	}
+	return id, true
+}